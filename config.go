@@ -0,0 +1,84 @@
+/*
+Copyright 2017 The Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/kuoss/eventrouter/config"
+	"github.com/kuoss/eventrouter/sinks"
+	"github.com/spf13/viper"
+)
+
+// Config holds the top level settings of the eventrouter. The settings of
+// the selected sink are decoded by the sinks package.
+type Config struct {
+	Kubeconfig       string        `mapstructure:"kubeconfig"`
+	Sink             string        `mapstructure:"sink" validate:"required"`
+	ResyncInterval   time.Duration `mapstructure:"resync-interval"`
+	EnablePrometheus bool          `mapstructure:"enable-prometheus"`
+
+	// SinkFailureEvents publishes Warning events on the eventrouter pod when
+	// a sink fails SinkFailureThreshold times in a row or its buffer is full,
+	// at most once per SinkFailureEventInterval for each sink and reason.
+	SinkFailureEvents        bool          `mapstructure:"sink-failure-events"`
+	SinkFailureThreshold     int           `mapstructure:"sink-failure-threshold"`
+	SinkFailureEventInterval time.Duration `mapstructure:"sink-failure-event-interval"`
+
+	// ForwardOwnEvents sends the events published by eventrouter to the
+	// sinks too
+	ForwardOwnEvents bool `mapstructure:"forward-own-events"`
+
+	// HeartbeatInterval is the period of the heartbeat records sent through
+	// every sink, 0 disables them
+	HeartbeatInterval time.Duration `mapstructure:"heartbeat-interval"`
+
+	// ShutdownTimeout bounds the time given to the sinks to flush buffered
+	// records on shutdown, and on configuration reloads
+	ShutdownTimeout time.Duration `mapstructure:"shutdown-timeout"`
+
+	// ReloadConfig rebuilds the sinks when the configuration file changes
+	ReloadConfig bool `mapstructure:"reload-config"`
+}
+
+// Validate implements config.Validator
+func (c *Config) Validate() error {
+	var errs []error
+	if c.SinkFailureEvents && (c.SinkFailureThreshold < 1 || c.SinkFailureEventInterval <= 0) {
+		errs = append(errs, errors.New("sink-failure-threshold and sink-failure-event-interval must be positive"))
+	}
+	if c.HeartbeatInterval < 0 {
+		errs = append(errs, errors.New("heartbeat-interval must not be negative"))
+	}
+	if c.ShutdownTimeout < 0 {
+		errs = append(errs, errors.New("shutdown-timeout must not be negative"))
+	}
+	return errors.Join(errs...)
+}
+
+// validateConfig checks the whole configuration held by v and reports every
+// problem found at once.
+func validateConfig(v *viper.Viper) error {
+	var cfg Config
+	known := append(config.Keys(&cfg), sinks.ConfigKeys()...)
+	return errors.Join(
+		config.CheckUnknownKeys(v, known),
+		config.Decode(v, &cfg),
+		sinks.ValidateConfig(v),
+	)
+}
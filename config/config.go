@@ -0,0 +1,262 @@
+/*
+Copyright 2017 The Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package config decodes the flat viper configuration of eventrouter into
+// typed structs and validates it.
+//
+// A config struct declares its keys with `mapstructure` tags. Embedded structs
+// tagged `mapstructure:",squash"` contribute their keys to the outer struct.
+// A field may also carry a `validate` tag with a comma separated list of
+// rules:
+//
+//	required        the key must be set to a non-empty value
+//	oneof=a b c     the value must be one of the space separated choices
+//
+// Decoding never stops at the first problem, every unknown key, wrong type and
+// missing required field is reported in a single error.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// Validator is implemented by config structs that need checks spanning
+// several fields. Validate is called after every field has been decoded.
+type Validator interface {
+	Validate() error
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Decode copies every key declared by the struct pointed to by out from v.
+// Fields whose key is not set in v keep their current value, so defaults are
+// applied by filling out before calling Decode.
+func Decode(v *viper.Viper, out interface{}) error {
+	rv := reflect.ValueOf(out)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("config: Decode expects a pointer to a struct, got %T", out)
+	}
+	errs := decodeStruct(v, rv.Elem())
+
+	if len(errs) == 0 {
+		if val, ok := out.(Validator); ok {
+			if err := val.Validate(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func decodeStruct(v *viper.Viper, rv reflect.Value) []error {
+	rt := rv.Type()
+
+	var errs []error
+	for i := 0; i < rt.NumField(); i++ {
+		f := rt.Field(i)
+		key := f.Tag.Get("mapstructure")
+		if isSquashed(f) {
+			errs = append(errs, decodeStruct(v, rv.Field(i))...)
+			continue
+		}
+		if key == "" || key == "-" || !f.IsExported() {
+			continue
+		}
+		fv := rv.Field(i)
+		if v.IsSet(key) {
+			if err := setField(fv, v.Get(key)); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", key, err))
+				continue
+			}
+		}
+		if err := checkRules(fv, f.Tag.Get("validate")); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+		}
+	}
+	return errs
+}
+
+// isSquashed reports whether f is an embedded struct whose keys belong to
+// the outer struct.
+func isSquashed(f reflect.StructField) bool {
+	return f.Anonymous && f.Type.Kind() == reflect.Struct && f.Tag.Get("mapstructure") == ",squash"
+}
+
+// Keys returns the keys declared by the `mapstructure` tags of cfg, which may
+// be a struct or a pointer to one.
+func Keys(cfg interface{}) []string {
+	if cfg == nil {
+		return nil
+	}
+	rt := reflect.TypeOf(cfg)
+	if rt.Kind() == reflect.Ptr {
+		rt = rt.Elem()
+	}
+	if rt.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var keys []string
+	for i := 0; i < rt.NumField(); i++ {
+		f := rt.Field(i)
+		if isSquashed(f) {
+			keys = append(keys, Keys(reflect.New(f.Type).Interface())...)
+			continue
+		}
+		key := f.Tag.Get("mapstructure")
+		if key != "" && key != "-" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// CheckUnknownKeys reports every top level key of v that is not listed in
+// known. Keys are compared case-insensitively, like viper does.
+func CheckUnknownKeys(v *viper.Viper, known []string) error {
+	knownSet := make(map[string]bool, len(known))
+	for _, k := range known {
+		knownSet[strings.ToLower(k)] = true
+	}
+
+	var unknown []string
+	for k := range v.AllSettings() {
+		if !knownSet[strings.ToLower(k)] {
+			unknown = append(unknown, k)
+		}
+	}
+	sort.Strings(unknown)
+
+	errs := make([]error, 0, len(unknown))
+	for _, k := range unknown {
+		errs = append(errs, fmt.Errorf("%s: unknown key", k))
+	}
+	return errors.Join(errs...)
+}
+
+// setField converts raw to the type of fv and stores it.
+func setField(fv reflect.Value, raw interface{}) error {
+	if fv.Type() == durationType {
+		d, err := cast.ToDurationE(raw)
+		if err != nil {
+			return wrongType(raw, "duration")
+		}
+		fv.SetInt(int64(d))
+		return nil
+	}
+
+	switch fv.Kind() {
+	case reflect.String:
+		switch raw.(type) {
+		case map[string]interface{}, []interface{}, bool:
+			return wrongType(raw, "string")
+		}
+		s, err := cast.ToStringE(raw)
+		if err != nil {
+			return wrongType(raw, "string")
+		}
+		fv.SetString(s)
+	case reflect.Bool:
+		b, err := cast.ToBoolE(raw)
+		if err != nil {
+			return wrongType(raw, "bool")
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if _, ok := raw.(bool); ok {
+			return wrongType(raw, "integer")
+		}
+		n, err := cast.ToInt64E(raw)
+		if err != nil {
+			return wrongType(raw, "integer")
+		}
+		fv.SetInt(n)
+	case reflect.Float32, reflect.Float64:
+		if _, ok := raw.(bool); ok {
+			return wrongType(raw, "number")
+		}
+		n, err := cast.ToFloat64E(raw)
+		if err != nil {
+			return wrongType(raw, "number")
+		}
+		fv.SetFloat(n)
+	case reflect.Slice:
+		if fv.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported field type %s", fv.Type())
+		}
+		if _, ok := raw.(map[string]interface{}); ok {
+			return wrongType(raw, "list of strings")
+		}
+		ss, err := cast.ToStringSliceE(raw)
+		if err != nil {
+			return wrongType(raw, "list of strings")
+		}
+		fv.Set(reflect.ValueOf(ss))
+	case reflect.Map:
+		if fv.Type().Key().Kind() != reflect.String || fv.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported field type %s", fv.Type())
+		}
+		m, err := cast.ToStringMapStringE(raw)
+		if err != nil {
+			return wrongType(raw, "map of strings")
+		}
+		fv.Set(reflect.ValueOf(m))
+	default:
+		return fmt.Errorf("unsupported field type %s", fv.Type())
+	}
+	return nil
+}
+
+// checkRules applies the rules of a `validate` tag to the decoded value fv.
+func checkRules(fv reflect.Value, tag string) error {
+	if tag == "" {
+		return nil
+	}
+	for _, rule := range strings.Split(tag, ",") {
+		switch {
+		case rule == "required":
+			if fv.IsZero() || (fv.Kind() == reflect.Slice && fv.Len() == 0) {
+				return errors.New("required but not set")
+			}
+		case strings.HasPrefix(rule, "oneof="):
+			choices := strings.Fields(strings.TrimPrefix(rule, "oneof="))
+			got := fmt.Sprint(fv.Interface())
+			found := false
+			for _, c := range choices {
+				if c == got {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return fmt.Errorf("invalid value %q, must be one of: %s", got, strings.Join(choices, ", "))
+			}
+		}
+	}
+	return nil
+}
+
+func wrongType(raw interface{}, want string) error {
+	return fmt.Errorf("invalid value %v (%T), expected %s", raw, raw, want)
+}
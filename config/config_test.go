@@ -0,0 +1,130 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+)
+
+type testConfig struct {
+	Name     string            `mapstructure:"name" validate:"required"`
+	Size     int               `mapstructure:"size"`
+	Enabled  bool              `mapstructure:"enabled"`
+	Interval time.Duration     `mapstructure:"interval"`
+	Brokers  []string          `mapstructure:"brokers"`
+	Labels   map[string]string `mapstructure:"labels"`
+	Format   string            `mapstructure:"format" validate:"oneof=json text"`
+	internal string
+}
+
+func TestDecode(t *testing.T) {
+	testCases := []struct {
+		name      string
+		settings  map[string]interface{}
+		want      testConfig
+		wantError string
+	}{
+		{
+			"defaults are kept",
+			map[string]interface{}{"name": "a"},
+			testConfig{Name: "a", Size: 10, Format: "json"},
+			"",
+		},
+		{
+			"all types",
+			map[string]interface{}{
+				"name":     "a",
+				"size":     float64(3),
+				"enabled":  "true",
+				"interval": "1m",
+				"brokers":  []interface{}{"b1", "b2"},
+				"labels":   map[string]interface{}{"k": "v"},
+				"format":   "text",
+			},
+			testConfig{Name: "a", Size: 3, Enabled: true, Interval: time.Minute, Brokers: []string{"b1", "b2"}, Labels: map[string]string{"k": "v"}, Format: "text"},
+			"",
+		},
+		{
+			"string slice from string",
+			map[string]interface{}{"name": "a", "brokers": "kafka:9092"},
+			testConfig{Name: "a", Size: 10, Brokers: []string{"kafka:9092"}, Format: "json"},
+			"",
+		},
+		{
+			"all errors at once",
+			map[string]interface{}{"size": "big", "enabled": "nope", "format": "xml", "labels": []interface{}{"x"}},
+			testConfig{Size: 10, Format: "xml"},
+			"name: required but not set\n" +
+				"size: invalid value big (string), expected integer\n" +
+				"enabled: invalid value nope (string), expected bool\n" +
+				"labels: invalid value [x] ([]interface {}), expected map of strings\n" +
+				`format: invalid value "xml", must be one of: json, text`,
+		},
+		{
+			"bool is not a string",
+			map[string]interface{}{"name": true},
+			testConfig{Size: 10, Format: "json"},
+			"name: invalid value true (bool), expected string",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			v := viper.New()
+			for k, val := range tc.settings {
+				v.Set(k, val)
+			}
+			got := testConfig{Size: 10, Format: "json"}
+			err := Decode(v, &got)
+			if tc.wantError == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tc.wantError)
+			}
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
+
+type squashedConfig struct {
+	testConfig `mapstructure:",squash"`
+	Extra      string `mapstructure:"extra" validate:"required"`
+}
+
+func TestDecode_squash(t *testing.T) {
+	v := viper.New()
+	v.Set("name", "a")
+	v.Set("size", "x")
+
+	var got squashedConfig
+	err := Decode(v, &got)
+	require.EqualError(t, err, "size: invalid value x (string), expected integer\n"+
+		`format: invalid value "", must be one of: json, text`+"\n"+
+		"extra: required but not set")
+	require.Equal(t, "a", got.Name)
+	require.Equal(t, []string{"name", "size", "enabled", "interval", "brokers", "labels", "format", "extra"}, Keys(&got))
+}
+
+func TestDecode_notStruct(t *testing.T) {
+	var s string
+	require.EqualError(t, Decode(viper.New(), &s), "config: Decode expects a pointer to a struct, got *string")
+}
+
+func TestKeys(t *testing.T) {
+	require.Equal(t, []string{"name", "size", "enabled", "interval", "brokers", "labels", "format"}, Keys(&testConfig{}))
+	require.Equal(t, []string{"name", "size", "enabled", "interval", "brokers", "labels", "format"}, Keys(testConfig{}))
+	require.Nil(t, Keys(nil))
+	require.Nil(t, Keys("string"))
+}
+
+func TestCheckUnknownKeys(t *testing.T) {
+	v := viper.New()
+	v.Set("name", "a")
+	v.Set("kakfkaAsync", true)
+	v.Set("rocksetAPIKey", "")
+	err := CheckUnknownKeys(v, []string{"name", "size"})
+	require.EqualError(t, err, "kakfkaasync: unknown key\nrocksetapikey: unknown key")
+
+	require.NoError(t, CheckUnknownKeys(v, []string{"Name", "kakfkaAsync", "rocksetAPIKey"}))
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateConfig(t *testing.T) {
+	testCases := []struct {
+		name      string
+		settings  map[string]interface{}
+		wantError string
+	}{
+		{
+			"valid",
+			map[string]interface{}{"sink": "stdout", "resync-interval": "10m", "kafkaTopic": "unused"},
+			"",
+		},
+		{
+			"every problem",
+			map[string]interface{}{"sink": "http", "kakfkaAsync": true, "enable-prometheus": "sometimes"},
+			"kakfkaasync: unknown key\n" +
+				"enable-prometheus: invalid value sometimes (string), expected bool\n" +
+				"sink http: httpSinkUrl: required but not set",
+		},
+		{
+			"sink failure events",
+			map[string]interface{}{"sink": "glog", "sink-failure-events": true, "sink-failure-threshold": 0, "sink-failure-event-interval": "5m"},
+			"sink-failure-threshold and sink-failure-event-interval must be positive",
+		},
+		{
+			"shutdown timeout",
+			map[string]interface{}{"sink": "glog", "shutdown-timeout": "-1s"},
+			"shutdown-timeout must not be negative",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			v := viper.New()
+			for k, val := range tc.settings {
+				v.Set(k, val)
+			}
+			err := validateConfig(v)
+			if tc.wantError == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tc.wantError)
+			}
+		})
+	}
+}
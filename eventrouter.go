@@ -18,8 +18,12 @@ package main
 
 import (
 	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/golang/glog"
+	"github.com/kuoss/eventrouter/redact"
 	"github.com/kuoss/eventrouter/sinks"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/spf13/viper"
@@ -73,6 +77,10 @@ var (
 		"reason",
 		"source",
 	})
+	ownEventsSuppressedCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "eventrouter_own_events_suppressed_total",
+		Help: "Total number of events published by eventrouter that were not forwarded to the sinks",
+	})
 )
 
 // EventRouter is responsible for maintaining a stream of kubernetes
@@ -87,25 +95,51 @@ type EventRouter struct {
 	// returns true if the event store has been synced
 	eListerSynched cache.InformerSynced
 
-	// event sink
+	// event sink, replaced by configuration reloads while holding sinkMu
 	// TODO: Determine if we want to support multiple sinks.
-	eSink sinks.EventSinkInterface
+	eSink  sinks.EventSinkInterface
+	sinkMu sync.RWMutex
+
+	// forwardOwnEvents sends the events published by eventrouter to eSink
+	forwardOwnEvents bool
+
+	// heartbeatInterval is the period of the heartbeat records, 0 disables
+	// them
+	heartbeatInterval time.Duration
+
+	// startTime and processed are reported by the heartbeats
+	startTime time.Time
+	processed atomic.Uint64
 }
 
 // NewEventRouter will create a new event router using the input params
-func NewEventRouter(kubeClient kubernetes.Interface, eventsInformer coreinformers.EventInformer) *EventRouter {
+func NewEventRouter(kubeClient kubernetes.Interface, eventsInformer coreinformers.EventInformer) (*EventRouter, error) {
 	if viper.GetBool("enable-prometheus") {
 		prometheus.MustRegister(kubernetesWarningEventCounterVec)
 		prometheus.MustRegister(kubernetesNormalEventCounterVec)
 		prometheus.MustRegister(kubernetesInfoEventCounterVec)
 		prometheus.MustRegister(kubernetesUnknownEventCounterVec)
+		prometheus.MustRegister(ownEventsSuppressedCounter)
+		prometheus.MustRegister(sinks.FilterErrorsTotal)
+		prometheus.MustRegister(redact.RedactionsTotal)
+		prometheus.MustRegister(sinks.SelfEventsSuppressedTotal)
+		prometheus.MustRegister(sinks.EventsDroppedTotal)
+		prometheus.MustRegister(sinks.KafkaMessagesTotal)
+	}
+
+	eSink, err := sinks.ManufactureSink()
+	if err != nil {
+		return nil, fmt.Errorf("ManufactureSink err: %w", err)
 	}
 
 	er := &EventRouter{
-		kubeClient: kubeClient,
-		eSink:      sinks.ManufactureSink(),
+		kubeClient:        kubeClient,
+		eSink:             eSink,
+		forwardOwnEvents:  viper.GetBool("forward-own-events"),
+		heartbeatInterval: viper.GetDuration("heartbeat-interval"),
+		startTime:         time.Now(),
 	}
-	_, err := eventsInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+	_, err = eventsInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc:    er.addEvent,
 		UpdateFunc: er.updateEvent,
 		DeleteFunc: er.deleteEvent,
@@ -115,7 +149,7 @@ func NewEventRouter(kubeClient kubernetes.Interface, eventsInformer coreinformer
 	}
 	er.eLister = eventsInformer.Lister()
 	er.eListerSynched = eventsInformer.Informer().HasSynced
-	return er
+	return er, nil
 }
 
 // Run starts the EventRouter/Controller.
@@ -125,6 +159,10 @@ func (er *EventRouter) Run(stopCh <-chan struct{}) {
 
 	glog.Infof("Starting EventRouter")
 
+	if er.heartbeatInterval > 0 {
+		go er.runHeartbeats(stopCh)
+	}
+
 	// here is where we kick the caches into gear
 	if !cache.WaitForCacheSync(stopCh, er.eListerSynched) {
 		utilruntime.HandleError(fmt.Errorf("timed out waiting for caches to sync"))
@@ -136,16 +174,49 @@ func (er *EventRouter) Run(stopCh <-chan struct{}) {
 // addEvent is called when an event is created, or during the initial list
 func (er *EventRouter) addEvent(obj interface{}) {
 	e := obj.(*v1.Event)
+	er.processed.Add(1)
 	prometheusEvent(e)
-	er.eSink.UpdateEvents(e, nil)
+	if er.isOwnEvent(e) {
+		return
+	}
+	er.sink(func(s sinks.EventSinkInterface) { s.UpdateEvents(e, nil) })
 }
 
 // updateEvent is called any time there is an update to an existing event
 func (er *EventRouter) updateEvent(objOld interface{}, objNew interface{}) {
 	eOld := objOld.(*v1.Event)
 	eNew := objNew.(*v1.Event)
+	er.processed.Add(1)
 	prometheusEvent(eNew)
-	er.eSink.UpdateEvents(eNew, eOld)
+	if er.isOwnEvent(eNew) {
+		return
+	}
+	er.sink(func(s sinks.EventSinkInterface) { s.UpdateEvents(eNew, eOld) })
+}
+
+// sink calls f with the event sink, which is not replaced until f returns
+func (er *EventRouter) sink(f func(sinks.EventSinkInterface)) {
+	er.sinkMu.RLock()
+	defer er.sinkMu.RUnlock()
+	f(er.eSink)
+}
+
+// setSink replaces the event sink, waiting for the events being written to
+// the previous one
+func (er *EventRouter) setSink(s sinks.EventSinkInterface) {
+	er.sinkMu.Lock()
+	defer er.sinkMu.Unlock()
+	er.eSink = s
+}
+
+// isOwnEvent reports whether e was published by eventrouter and must not be
+// forwarded, which would let a failing sink report about itself in a loop.
+func (er *EventRouter) isOwnEvent(e *v1.Event) bool {
+	if er.forwardOwnEvents || e.Source.Component != eventComponent {
+		return false
+	}
+	ownEventsSuppressedCounter.Inc()
+	return true
 }
 
 // prometheusEvent is called when an event is added or updated
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/kuoss/eventrouter/sinks"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/tools/cache"
@@ -113,3 +115,33 @@ func TestToEventPointer(t *testing.T) {
 		})
 	}
 }
+
+type recordingSink struct {
+	events []*v1.Event
+}
+
+func (s *recordingSink) UpdateEvents(eNew *v1.Event, eOld *v1.Event) {
+	s.events = append(s.events, eNew)
+}
+
+func TestAddEvent_ownEvents(t *testing.T) {
+	own := &v1.Event{Source: v1.EventSource{Component: eventComponent}, Reason: "SinkFailing"}
+	other := &v1.Event{Source: v1.EventSource{Component: "kubelet"}, Reason: "BackOff"}
+
+	suppressed := testutil.ToFloat64(ownEventsSuppressedCounter)
+	selfSuppressed := testutil.ToFloat64(sinks.SelfEventsSuppressedTotal)
+
+	sink := &recordingSink{}
+	er := EventRouter{eSink: sink}
+	er.addEvent(own)
+	er.addEvent(other)
+	er.updateEvent(own, own)
+	require.Equal(t, []*v1.Event{other}, sink.events)
+	require.Equal(t, suppressed+2, testutil.ToFloat64(ownEventsSuppressedCounter))
+	require.Equal(t, selfSuppressed, testutil.ToFloat64(sinks.SelfEventsSuppressedTotal))
+
+	sink = &recordingSink{}
+	er = EventRouter{eSink: sink, forwardOwnEvents: true}
+	er.addEvent(own)
+	require.Equal(t, []*v1.Event{own}, sink.events)
+}
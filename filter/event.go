@@ -0,0 +1,144 @@
+/*
+Copyright 2017 The Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filter
+
+import (
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// The types below are the form of a v1.Event seen by the expressions, with
+// its JSON field names. They are declared to CEL so that field names are
+// checked by Compile, and fields the event does not set read as their zero
+// value: "", 0, an empty map or list, and the Unix epoch for timestamps.
+
+// event is a v1.Event
+type event struct {
+	Metadata            objectMeta      `json:"metadata"`
+	InvolvedObject      objectReference `json:"involvedObject"`
+	Reason              string          `json:"reason"`
+	Message             string          `json:"message"`
+	Source              eventSource     `json:"source"`
+	FirstTimestamp      time.Time       `json:"firstTimestamp"`
+	LastTimestamp       time.Time       `json:"lastTimestamp"`
+	Count               int64           `json:"count"`
+	Type                string          `json:"type"`
+	EventTime           time.Time       `json:"eventTime"`
+	Series              eventSeries     `json:"series"`
+	Action              string          `json:"action"`
+	Related             objectReference `json:"related"`
+	ReportingController string          `json:"reportingComponent"`
+	ReportingInstance   string          `json:"reportingInstance"`
+}
+
+type objectMeta struct {
+	Name              string            `json:"name"`
+	GenerateName      string            `json:"generateName"`
+	Namespace         string            `json:"namespace"`
+	UID               string            `json:"uid"`
+	ResourceVersion   string            `json:"resourceVersion"`
+	CreationTimestamp time.Time         `json:"creationTimestamp"`
+	Labels            map[string]string `json:"labels"`
+	Annotations       map[string]string `json:"annotations"`
+	OwnerReferences   []ownerReference  `json:"ownerReferences"`
+}
+
+type ownerReference struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	UID        string `json:"uid"`
+}
+
+type objectReference struct {
+	Kind            string `json:"kind"`
+	Namespace       string `json:"namespace"`
+	Name            string `json:"name"`
+	UID             string `json:"uid"`
+	APIVersion      string `json:"apiVersion"`
+	ResourceVersion string `json:"resourceVersion"`
+	FieldPath       string `json:"fieldPath"`
+}
+
+type eventSource struct {
+	Component string `json:"component"`
+	Host      string `json:"host"`
+}
+
+type eventSeries struct {
+	Count            int64     `json:"count"`
+	LastObservedTime time.Time `json:"lastObservedTime"`
+}
+
+// newEvent returns the expression form of e, the zero event when e is nil
+func newEvent(e *v1.Event) *event {
+	if e == nil {
+		return &event{}
+	}
+	out := &event{
+		Metadata: objectMeta{
+			Name:              e.Name,
+			GenerateName:      e.GenerateName,
+			Namespace:         e.Namespace,
+			UID:               string(e.UID),
+			ResourceVersion:   e.ResourceVersion,
+			CreationTimestamp: e.CreationTimestamp.Time,
+			Labels:            e.Labels,
+			Annotations:       e.Annotations,
+		},
+		InvolvedObject:      newObjectReference(&e.InvolvedObject),
+		Reason:              e.Reason,
+		Message:             e.Message,
+		Source:              eventSource{Component: e.Source.Component, Host: e.Source.Host},
+		FirstTimestamp:      e.FirstTimestamp.Time,
+		LastTimestamp:       e.LastTimestamp.Time,
+		Count:               int64(e.Count),
+		Type:                e.Type,
+		EventTime:           e.EventTime.Time,
+		Action:              e.Action,
+		ReportingController: e.ReportingController,
+		ReportingInstance:   e.ReportingInstance,
+	}
+	for _, o := range e.OwnerReferences {
+		out.Metadata.OwnerReferences = append(out.Metadata.OwnerReferences, ownerReference{
+			APIVersion: o.APIVersion,
+			Kind:       o.Kind,
+			Name:       o.Name,
+			UID:        string(o.UID),
+		})
+	}
+	if e.Series != nil {
+		out.Series = eventSeries{Count: int64(e.Series.Count), LastObservedTime: e.Series.LastObservedTime.Time}
+	}
+	if e.Related != nil {
+		out.Related = newObjectReference(e.Related)
+	}
+	return out
+}
+
+func newObjectReference(o *v1.ObjectReference) objectReference {
+	return objectReference{
+		Kind:            o.Kind,
+		Namespace:       o.Namespace,
+		Name:            o.Name,
+		UID:             string(o.UID),
+		APIVersion:      o.APIVersion,
+		ResourceVersion: o.ResourceVersion,
+		FieldPath:       o.FieldPath,
+	}
+}
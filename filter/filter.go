@@ -0,0 +1,228 @@
+/*
+Copyright 2017 The Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package filter evaluates CEL expressions over eventrouter events.
+//
+// An expression sees three variables:
+//
+//	verb       "ADDED" or "UPDATED"
+//	event      the new event, with the JSON field names of a v1.Event
+//	old_event  the previous event of an update, or an empty event
+//
+// The fields of the events are typed, so a misspelled field fails to
+// compile, and a field an event does not set reads as its zero value.
+//
+// For example:
+//
+//	event.type == "Warning" && event.message.contains("OOMKilled") && event.count > 3
+//
+// Besides the standard CEL functions and the string extensions, these helpers
+// are available:
+//
+//	s.find(re)        the first match of the regular expression re in s, or ""
+//	s.findAll(re)     every match of the regular expression re in s
+//	now()             the current time
+//	age(ts)           the time elapsed since ts, a timestamp or RFC3339 string
+//
+// so that, for instance, age(event.lastTimestamp) < duration("5m") selects
+// recent events.
+package filter
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/ext"
+	v1 "k8s.io/api/core/v1"
+)
+
+var (
+	envOnce sync.Once
+	env     *cel.Env
+	envErr  error
+
+	// regexps caches the compiled regular expressions of find and findAll
+	regexps sync.Map
+)
+
+// Expression is a compiled CEL expression evaluating to a bool
+type Expression struct {
+	source  string
+	program cel.Program
+}
+
+// Compile parses and type-checks src. It fails unless src evaluates to a
+// bool.
+func Compile(src string) (*Expression, error) {
+	e, err := newEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	ast, iss := e.Compile(src)
+	if iss.Err() != nil {
+		return nil, fmt.Errorf("invalid expression %q: %w", src, iss.Err())
+	}
+	if ast.OutputType() != cel.BoolType {
+		return nil, fmt.Errorf("invalid expression %q: must evaluate to bool, not %s", src, ast.OutputType())
+	}
+	prg, err := e.Program(ast)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %w", src, err)
+	}
+	return &Expression{source: src, program: prg}, nil
+}
+
+// String returns the source of the expression
+func (x *Expression) String() string {
+	return x.source
+}
+
+// Match evaluates the expression against in
+func (x *Expression) Match(in *Input) (bool, error) {
+	out, _, err := x.program.Eval(in.vars())
+	if err != nil {
+		return false, fmt.Errorf("evaluating %q: %w", x.source, err)
+	}
+	b, ok := out.Value().(bool)
+	if !ok {
+		return false, fmt.Errorf("evaluating %q: got %T instead of bool", x.source, out.Value())
+	}
+	return b, nil
+}
+
+// Input holds the variables of one event. They are converted on first use
+// and shared by every expression evaluated against the same event.
+type Input struct {
+	verb     string
+	event    *v1.Event
+	oldEvent *v1.Event
+
+	converted map[string]interface{}
+}
+
+// NewInput builds the input of an event and its previous version, which is
+// nil for new events.
+func NewInput(verb string, eNew *v1.Event, eOld *v1.Event) *Input {
+	return &Input{verb: verb, event: eNew, oldEvent: eOld}
+}
+
+func (in *Input) vars() map[string]interface{} {
+	if in.converted == nil {
+		in.converted = map[string]interface{}{
+			"verb":      in.verb,
+			"event":     newEvent(in.event),
+			"old_event": newEvent(in.oldEvent),
+		}
+	}
+	return in.converted
+}
+
+func newEnv() (*cel.Env, error) {
+	envOnce.Do(func() {
+		env, envErr = cel.NewEnv(
+			cel.Variable("verb", cel.StringType),
+			ext.NativeTypes(reflect.TypeOf(&event{}), ext.ParseStructTag("json")),
+			cel.Variable("event", cel.ObjectType("filter.event")),
+			cel.Variable("old_event", cel.ObjectType("filter.event")),
+			cel.CrossTypeNumericComparisons(true),
+			ext.Strings(),
+			cel.Function("find",
+				cel.MemberOverload("string_find_string", []*cel.Type{cel.StringType, cel.StringType}, cel.StringType,
+					cel.BinaryBinding(find)),
+			),
+			cel.Function("findAll",
+				cel.MemberOverload("string_find_all_string", []*cel.Type{cel.StringType, cel.StringType}, cel.ListType(cel.StringType),
+					cel.BinaryBinding(findAll)),
+			),
+			cel.Function("now",
+				cel.Overload("now", nil, cel.TimestampType,
+					cel.FunctionBinding(func(...ref.Val) ref.Val { return types.Timestamp{Time: time.Now()} })),
+			),
+			cel.Function("age",
+				cel.Overload("age_timestamp", []*cel.Type{cel.TimestampType}, cel.DurationType,
+					cel.UnaryBinding(age)),
+				cel.Overload("age_string", []*cel.Type{cel.StringType}, cel.DurationType,
+					cel.UnaryBinding(age)),
+			),
+		)
+	})
+	return env, envErr
+}
+
+func compileRegexp(val ref.Val) (*regexp.Regexp, ref.Val) {
+	pattern, ok := val.(types.String)
+	if !ok {
+		return nil, types.MaybeNoSuchOverloadErr(val)
+	}
+	if re, ok := regexps.Load(string(pattern)); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(string(pattern))
+	if err != nil {
+		return nil, types.NewErr("invalid regular expression %q: %v", string(pattern), err)
+	}
+	regexps.Store(string(pattern), re)
+	return re, nil
+}
+
+func find(lhs, rhs ref.Val) ref.Val {
+	s, ok := lhs.(types.String)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(lhs)
+	}
+	re, errVal := compileRegexp(rhs)
+	if errVal != nil {
+		return errVal
+	}
+	return types.String(re.FindString(string(s)))
+}
+
+func findAll(lhs, rhs ref.Val) ref.Val {
+	s, ok := lhs.(types.String)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(lhs)
+	}
+	re, errVal := compileRegexp(rhs)
+	if errVal != nil {
+		return errVal
+	}
+	matches := re.FindAllString(string(s), -1)
+	if matches == nil {
+		matches = []string{}
+	}
+	return types.DefaultTypeAdapter.NativeToValue(matches)
+}
+
+func age(val ref.Val) ref.Val {
+	switch ts := val.(type) {
+	case types.Timestamp:
+		return types.Duration{Duration: time.Since(ts.Time)}
+	case types.String:
+		t, err := time.Parse(time.RFC3339, string(ts))
+		if err != nil {
+			return types.NewErr("age: invalid timestamp %q: %v", string(ts), err)
+		}
+		return types.Duration{Duration: time.Since(t)}
+	}
+	return types.MaybeNoSuchOverloadErr(val)
+}
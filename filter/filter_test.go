@@ -0,0 +1,120 @@
+package filter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCompile(t *testing.T) {
+	testCases := []struct {
+		src       string
+		wantError string
+	}{
+		{`event.type == "Warning"`, ""},
+		{`verb == "UPDATED" && old_event.count < event.count`, ""},
+		{`event.message.find("[0-9]+") != ""`, ""},
+		{`age(event.lastTimestamp) < duration("5m")`, ""},
+		{`event.type`, `invalid expression "event.type": must evaluate to bool, not string`},
+		{`event.mesage.contains("x")`, "invalid expression \"event.mesage.contains(\\\"x\\\")\": ERROR: <input>:1:6: undefined field 'mesage'\n | event.mesage.contains(\"x\")\n | .....^"},
+		{`event.count == "3"`, "invalid expression \"event.count == \\\"3\\\"\": ERROR: <input>:1:13: found no matching overload for '_==_' applied to '(int, string)'\n | event.count == \"3\"\n | ............^"},
+		{`verb + 1`, "invalid expression \"verb + 1\": ERROR: <input>:1:6: found no matching overload for '_+_' applied to '(string, int)'\n | verb + 1\n | .....^"},
+		{`pod.name == "x"`, "invalid expression \"pod.name == \\\"x\\\"\": ERROR: <input>:1:1: undeclared reference to 'pod' (in container '')\n | pod.name == \"x\"\n | ^"},
+		{`event.type ==`, "invalid expression \"event.type ==\": ERROR: <input>:1:14: Syntax error: mismatched input '<EOF>' expecting {'[', '{', '(', '.', '-', '!', 'true', 'false', 'null', NUM_FLOAT, NUM_INT, NUM_UINT, STRING, BYTES, IDENTIFIER}\n | event.type ==\n | .............^"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.src, func(t *testing.T) {
+			expr, err := Compile(tc.src)
+			if tc.wantError == "" {
+				require.NoError(t, err)
+				require.Equal(t, tc.src, expr.String())
+			} else {
+				require.EqualError(t, err, tc.wantError)
+			}
+		})
+	}
+}
+
+func TestMatch(t *testing.T) {
+	eOld := &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{Name: "web-1.17a", Namespace: "payments"},
+		Type:       "Warning",
+		Message:    "Container web was OOMKilled, exit code 137",
+		Count:      3,
+	}
+	eNew := eOld.DeepCopy()
+	eNew.Count = 4
+	eNew.FirstTimestamp = metav1.NewTime(time.Now().Add(-time.Hour))
+	eNew.LastTimestamp = metav1.NewTime(time.Now().Add(-time.Minute))
+
+	testCases := []struct {
+		src  string
+		want bool
+	}{
+		{`event.type == "Warning" && event.message.contains("OOMKilled") && event.count > 3`, true},
+		{`event.type == "Warning" && event.message.contains("OOMKilled") && old_event.count > 3`, false},
+		{`verb == "UPDATED"`, true},
+		{`event.metadata.namespace in ["payments", "billing"]`, true},
+		{`event.message.find("exit code [0-9]+") == "exit code 137"`, true},
+		{`event.message.findAll("[0-9]+") == ["137"]`, true},
+		{`event.message.matches("^Container .* was OOMKilled")`, true},
+		{`age(event.lastTimestamp) < duration("5m")`, true},
+		{`age(timestamp(event.firstTimestamp)) > duration("30m")`, true},
+		{`timestamp(event.lastTimestamp) - timestamp(event.firstTimestamp) > duration("50m")`, true},
+		{`now() > timestamp(event.lastTimestamp)`, true},
+		{`event.message.lowerAscii().startsWith("container")`, true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.src, func(t *testing.T) {
+			expr, err := Compile(tc.src)
+			require.NoError(t, err)
+			got, err := expr.Match(NewInput("UPDATED", eNew, eOld))
+			require.NoError(t, err)
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestMatch_absentFields(t *testing.T) {
+	// Fields the event does not set read as zero values instead of failing
+	in := NewInput("ADDED", &v1.Event{Reason: "BackOff", Message: "x"}, nil)
+	testCases := []struct {
+		src  string
+		want bool
+	}{
+		{`!(event.reason == "BackOff" && event.count > 3)`, true},
+		{`event.type == "Warning"`, false},
+		{`old_event.count > 1`, false},
+		{`has(old_event.count) && old_event.count > 1`, false},
+		{`has(event.reason) && !has(event.metadata.labels)`, true},
+		{`"app" in event.metadata.labels`, false},
+		{`event.series.count == 0 && event.related.kind == ""`, true},
+		{`age(event.lastTimestamp) > duration("24h")`, true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.src, func(t *testing.T) {
+			expr, err := Compile(tc.src)
+			require.NoError(t, err)
+			got, err := expr.Match(in)
+			require.NoError(t, err)
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestMatch_errors(t *testing.T) {
+	in := NewInput("ADDED", &v1.Event{Message: "x"}, nil)
+
+	expr, err := Compile(`event.message.find("(") == ""`)
+	require.NoError(t, err)
+	_, err = expr.Match(in)
+	require.ErrorContains(t, err, "invalid regular expression \"(\"")
+
+	expr, err = Compile(`event.metadata.labels["app"] == "web"`)
+	require.NoError(t, err)
+	_, err = expr.Match(in)
+	require.EqualError(t, err, `evaluating "event.metadata.labels[\"app\"] == \"web\"": no such key: app`)
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2017 The Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/kuoss/eventrouter/sinks"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// The annotations of a heartbeat record carry its figures in a form that is
+// easy to parse for consumers.
+const (
+	heartbeatVersionAnnotation   = "eventrouter.kuoss.io/version"
+	heartbeatUptimeAnnotation    = "eventrouter.kuoss.io/uptime-seconds"
+	heartbeatSyncedAnnotation    = "eventrouter.kuoss.io/informer-synced"
+	heartbeatProcessedAnnotation = "eventrouter.kuoss.io/events-processed"
+	heartbeatDroppedAnnotation   = "eventrouter.kuoss.io/events-dropped"
+)
+
+// runHeartbeats sends a heartbeat record through every sink each
+// heartbeatInterval until stopCh is closed, so consumers can tell a quiet
+// cluster from a wedged eventrouter.
+func (er *EventRouter) runHeartbeats(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(er.heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			event := er.heartbeatEvent(now)
+			er.sink(func(s sinks.EventSinkInterface) { sinks.SendHeartbeat(s, event) })
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// heartbeatEvent builds a heartbeat record about the eventrouter pod. The
+// counts cover the time since the previous heartbeat.
+func (er *EventRouter) heartbeatEvent(now time.Time) *v1.Event {
+	uptime := now.Sub(er.startTime).Truncate(time.Second)
+	synced := er.eListerSynched != nil && er.eListerSynched()
+	processed := er.processed.Swap(0)
+	dropped := sinks.TakeDroppedEvents()
+
+	podName, podNamespace := os.Getenv("POD_NAME"), os.Getenv("POD_NAMESPACE")
+	host, _ := os.Hostname()
+	ts := metav1.NewTime(now)
+	return &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s.heartbeat.%d", eventComponent, now.UnixNano()),
+			Namespace: podNamespace,
+			Annotations: map[string]string{
+				heartbeatVersionAnnotation:   version,
+				heartbeatUptimeAnnotation:    strconv.FormatInt(int64(uptime.Seconds()), 10),
+				heartbeatSyncedAnnotation:    strconv.FormatBool(synced),
+				heartbeatProcessedAnnotation: strconv.FormatUint(processed, 10),
+				heartbeatDroppedAnnotation:   strconv.FormatUint(dropped, 10),
+			},
+		},
+		InvolvedObject: v1.ObjectReference{Kind: "Pod", Namespace: podNamespace, Name: podName},
+		Reason:         sinks.HeartbeatReason,
+		Message: fmt.Sprintf("eventrouter %s up %v, informer synced: %t, %d events processed and %d dropped since the last heartbeat",
+			version, uptime, synced, processed, dropped),
+		Source:         v1.EventSource{Component: eventComponent, Host: host},
+		FirstTimestamp: ts,
+		LastTimestamp:  ts,
+		Count:          1,
+		Type:           v1.EventTypeNormal,
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestHeartbeatEvent(t *testing.T) {
+	t.Setenv("POD_NAME", "eventrouter-1")
+	t.Setenv("POD_NAMESPACE", "kube-system")
+
+	start := time.Date(2024, 3, 7, 10, 0, 0, 0, time.UTC)
+	sink := &recordingSink{}
+	er := &EventRouter{eSink: sink, startTime: start, eListerSynched: func() bool { return true }}
+	er.addEvent(&v1.Event{Reason: "BackOff"})
+	er.addEvent(&v1.Event{Reason: "Pulled"})
+
+	hb := er.heartbeatEvent(start.Add(90 * time.Second))
+	require.Equal(t, "Heartbeat", hb.Reason)
+	require.Equal(t, v1.ObjectReference{Kind: "Pod", Namespace: "kube-system", Name: "eventrouter-1"}, hb.InvolvedObject)
+	require.Equal(t, "eventrouter", hb.Source.Component)
+	require.Equal(t, "dev", hb.Annotations[heartbeatVersionAnnotation])
+	require.Equal(t, "90", hb.Annotations[heartbeatUptimeAnnotation])
+	require.Equal(t, "true", hb.Annotations[heartbeatSyncedAnnotation])
+	require.Equal(t, "2", hb.Annotations[heartbeatProcessedAnnotation])
+	require.Equal(t, "eventrouter dev up 1m30s, informer synced: true, 2 events processed and 0 dropped since the last heartbeat", hb.Message)
+
+	// Counts restart with every heartbeat
+	hb = er.heartbeatEvent(start.Add(120 * time.Second))
+	require.Equal(t, "0", hb.Annotations[heartbeatProcessedAnnotation])
+}
+
+func TestRunHeartbeats(t *testing.T) {
+	sink := &recordingSink{}
+	er := &EventRouter{eSink: sink, startTime: time.Now(), heartbeatInterval: 10 * time.Millisecond}
+	stopCh := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		er.runHeartbeats(stopCh)
+		close(done)
+	}()
+	time.Sleep(55 * time.Millisecond)
+	close(stopCh)
+	<-done
+
+	require.NotEmpty(t, sink.events)
+	require.Equal(t, "false", sink.events[0].Annotations[heartbeatSyncedAnnotation])
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"net/http"
@@ -27,15 +28,21 @@ import (
 	"time"
 
 	"github.com/golang/glog"
+	"github.com/kuoss/eventrouter/sinks"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/viper"
 
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
+// version is the version of eventrouter, set at build time with
+// -ldflags "-X main.version=..."
+var version = "dev"
+
 // addr tells us what address to have the Prometheus metrics listen on.
 var addr = flag.String("listen-address", ":8080", "The address to listen on for HTTP requests.")
 
@@ -58,13 +65,11 @@ func sigHandler() <-chan struct{} {
 	return stop
 }
 
-// loadConfig will parse input + config file and return a clientset
-func loadConfig() (kubernetes.Interface, error) {
-	var config *rest.Config
-	var err error
-
-	flag.Parse()
+// validate tells us to only check the configuration and exit.
+var validate = flag.Bool("validate", false, "Validate the configuration file and exit without contacting the cluster.")
 
+// readConfig will locate, parse and validate the config file
+func readConfig() error {
 	// leverages a file|(ConfigMap)
 	// to be located at /etc/eventrouter/config
 	viper.SetConfigType("json")
@@ -75,21 +80,50 @@ func loadConfig() (kubernetes.Interface, error) {
 	viper.SetDefault("sink", "glog")
 	viper.SetDefault("resync-interval", time.Minute*30)
 	viper.SetDefault("enable-prometheus", true)
+	viper.SetDefault("sink-failure-events", true)
+	viper.SetDefault("sink-failure-threshold", 5)
+	viper.SetDefault("sink-failure-event-interval", time.Minute*5)
+	viper.SetDefault("forward-own-events", false)
+	viper.SetDefault("heartbeat-interval", time.Duration(0))
+	viper.SetDefault("shutdown-timeout", time.Second*30)
+	viper.SetDefault("reload-config", true)
 
-	err = viper.ReadInConfig()
+	// Allow specifying a custom config file via the EVENTROUTER_CONFIG env var
+	if forceCfg := os.Getenv("EVENTROUTER_CONFIG"); forceCfg != "" {
+		viper.SetConfigFile(forceCfg)
+	}
+
+	err := viper.BindEnv("kubeconfig") // Allows the KUBECONFIG env var to override where the kubeconfig is
 	if err != nil {
-		return nil, fmt.Errorf("ReadInConfig err: %w", err)
+		return fmt.Errorf("BindEnv err: %w", err)
 	}
+	return parseConfig()
+}
 
-	err = viper.BindEnv("kubeconfig") // Allows the KUBECONFIG env var to override where the kubeconfig is
+// parseConfig reads and validates the config file, at startup and on reloads
+func parseConfig() error {
+	err := viper.ReadInConfig()
 	if err != nil {
-		return nil, fmt.Errorf("BindEnv err: %w", err)
+		return fmt.Errorf("ReadInConfig err: %w", err)
 	}
 
-	// Allow specifying a custom config file via the EVENTROUTER_CONFIG env var
-	if forceCfg := os.Getenv("EVENTROUTER_CONFIG"); forceCfg != "" {
-		viper.SetConfigFile(forceCfg)
+	err = validateConfig(viper.GetViper())
+	if err != nil {
+		return fmt.Errorf("invalid config %s:\n%w", viper.ConfigFileUsed(), err)
 	}
+	return nil
+}
+
+// loadConfig will parse input + config file and return a clientset
+func loadConfig() (kubernetes.Interface, error) {
+	var config *rest.Config
+	var err error
+
+	err = readConfig()
+	if err != nil {
+		return nil, err
+	}
+
 	kubeconfig := viper.GetString("kubeconfig")
 	if len(kubeconfig) > 0 {
 		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
@@ -111,20 +145,69 @@ func loadConfig() (kubernetes.Interface, error) {
 	return clientset, nil
 }
 
+// discoverClusterID returns the UID of the kube-system namespace, which
+// identifies the cluster for as long as it exists.
+func discoverClusterID(clientset kubernetes.Interface) (string, error) {
+	ns, err := clientset.CoreV1().Namespaces().Get(context.Background(), metav1.NamespaceSystem, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("get namespace %s: %w", metav1.NamespaceSystem, err)
+	}
+	return string(ns.UID), nil
+}
+
 // main entry point of the program
 func main() {
 	var wg sync.WaitGroup
 
+	flag.Parse()
+
+	if *validate {
+		if err := readConfig(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Printf("config %s is valid\n", viper.ConfigFileUsed())
+		os.Exit(0)
+	}
+
 	clientset, err := loadConfig()
 	if err != nil {
 		glog.Errorf("loadConfig err: %v", err)
 		os.Exit(1)
 	}
+
+	if sinks.NeedsClusterID(viper.GetViper()) {
+		clusterID, err := discoverClusterID(clientset)
+		if err != nil {
+			glog.Errorf("discoverClusterID err: %v", err)
+			os.Exit(1)
+		}
+		viper.Set("clusterID", clusterID)
+	}
+
+	var reporter configReporter = nopConfigReporter{}
+	if viper.GetBool("sink-failure-events") {
+		hr, err := newHealthRecorder(clientset, viper.GetInt("sink-failure-threshold"), viper.GetDuration("sink-failure-event-interval"))
+		if err != nil {
+			glog.Warningf("Not publishing events about sink failures: %v", err)
+		} else {
+			sinks.SetHealthReporter(hr)
+			reporter = hr
+		}
+	}
+
 	sharedInformers := informers.NewSharedInformerFactory(clientset, viper.GetDuration("resync-interval"))
 	eventsInformer := sharedInformers.Core().V1().Events()
 
 	// TODO: Support locking for HA https://github.com/kubernetes/kubernetes/pull/42666
-	eventRouter := NewEventRouter(clientset, eventsInformer)
+	eventRouter, err := NewEventRouter(clientset, eventsInformer)
+	if err != nil {
+		glog.Errorf("NewEventRouter err: %v", err)
+		os.Exit(1)
+	}
+	if viper.GetBool("reload-config") {
+		eventRouter.watchConfig(reporter)
+	}
 	stop := sigHandler()
 
 	// Startup the http listener for Prometheus Metrics endpoint.
@@ -147,6 +230,7 @@ func main() {
 	glog.Infof("Starting shared Informer(s)")
 	sharedInformers.Start(stop)
 	wg.Wait()
+	sinks.Shutdown(viper.GetDuration("shutdown-timeout"))
 	glog.Warningf("Exiting main()")
 	os.Exit(1)
 }
@@ -4,6 +4,9 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
 )
 
 func TestLoadConfig(t *testing.T) {
@@ -11,3 +14,15 @@ func TestLoadConfig(t *testing.T) {
 	require.EqualError(t, err, "BuildConfigFromFlags err: stat /var/run/kubernetes/admin.kubeconfig: no such file or directory")
 	require.Nil(t, k8s)
 }
+
+func TestDiscoverClusterID(t *testing.T) {
+	_, err := discoverClusterID(fake.NewSimpleClientset())
+	require.EqualError(t, err, `get namespace kube-system: namespaces "kube-system" not found`)
+
+	clientset := fake.NewSimpleClientset(&v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: "kube-system", UID: "3f6b1a52-51d2-4c43-9c4e-0f1f0d0e7a11"},
+	})
+	id, err := discoverClusterID(clientset)
+	require.NoError(t, err)
+	require.Equal(t, "3f6b1a52-51d2-4c43-9c4e-0f1f0d0e7a11", id)
+}
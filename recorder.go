@@ -0,0 +1,155 @@
+/*
+Copyright 2017 The Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/tools/reference"
+)
+
+// eventComponent is the source component of the events published by
+// eventrouter. They are not forwarded to the sinks unless forward-own-events
+// is set.
+const eventComponent = "eventrouter"
+
+// healthRecorder publishes Warning events on the eventrouter pod when a sink
+// keeps failing, its buffer is full or a configuration reload fails, so that
+// operators see them with kubectl describe. It implements
+// sinks.HealthReporter and configReporter.
+type healthRecorder struct {
+	recorder record.EventRecorder
+	ref      *v1.ObjectReference
+
+	// threshold is the number of consecutive failures of a sink before an
+	// event is published
+	threshold int
+
+	// interval is the minimum time between two events with the same reason
+	// about the same sink
+	interval time.Duration
+
+	now func() time.Time
+
+	mu       sync.Mutex
+	failures map[string]int
+	reported map[string]bool
+	lastSent map[string]time.Time
+}
+
+func newHealthRecorderWith(recorder record.EventRecorder, ref *v1.ObjectReference, threshold int, interval time.Duration) *healthRecorder {
+	return &healthRecorder{
+		recorder:  recorder,
+		ref:       ref,
+		threshold: threshold,
+		interval:  interval,
+		now:       time.Now,
+		failures:  map[string]int{},
+		reported:  map[string]bool{},
+		lastSent:  map[string]time.Time{},
+	}
+}
+
+// newHealthRecorder looks up the eventrouter pod named by the POD_NAME and
+// POD_NAMESPACE environment variables and records events about it.
+func newHealthRecorder(kubeClient kubernetes.Interface, threshold int, interval time.Duration) (*healthRecorder, error) {
+	name, namespace := os.Getenv("POD_NAME"), os.Getenv("POD_NAMESPACE")
+	if name == "" || namespace == "" {
+		return nil, errors.New("POD_NAME and POD_NAMESPACE are not set")
+	}
+	pod, err := kubeClient.CoreV1().Pods(namespace).Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("get pod %s/%s: %w", namespace, name, err)
+	}
+	ref, err := reference.GetReference(scheme.Scheme, pod)
+	if err != nil {
+		return nil, fmt.Errorf("GetReference err: %w", err)
+	}
+
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeClient.CoreV1().Events("")})
+	recorder := broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: eventComponent})
+	return newHealthRecorderWith(recorder, ref, threshold, interval), nil
+}
+
+// allow reports whether an event with reason about sink may be published
+// now. It must be called with mu held.
+func (h *healthRecorder) allow(reason string, sink string) bool {
+	key := reason + "/" + sink
+	now := h.now()
+	if last, ok := h.lastSent[key]; ok && now.Sub(last) < h.interval {
+		return false
+	}
+	h.lastSent[key] = now
+	return true
+}
+
+// SinkFailed implements sinks.HealthReporter
+func (h *healthRecorder) SinkFailed(sink string, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.failures[sink]++
+	n := h.failures[sink]
+	if n < h.threshold || !h.allow("SinkFailing", sink) {
+		return
+	}
+	h.reported[sink] = true
+	h.recorder.Eventf(h.ref, v1.EventTypeWarning, "SinkFailing", "Sink %s failed %d times in a row: %v", sink, n, err)
+}
+
+// SinkSucceeded implements sinks.HealthReporter
+func (h *healthRecorder) SinkSucceeded(sink string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.failures[sink] = 0
+	if h.reported[sink] {
+		h.reported[sink] = false
+		h.recorder.Eventf(h.ref, v1.EventTypeNormal, "SinkRecovered", "Sink %s delivers events again", sink)
+	}
+}
+
+// BufferFull implements sinks.HealthReporter
+func (h *healthRecorder) BufferFull(sink string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.allow("SinkBufferFull", sink) {
+		return
+	}
+	h.recorder.Eventf(h.ref, v1.EventTypeWarning, "SinkBufferFull", "The buffer of sink %s is full, events are dropped or delayed", sink)
+}
+
+// ConfigReloaded implements configReporter
+func (h *healthRecorder) ConfigReloaded(file string) {
+	h.recorder.Eventf(h.ref, v1.EventTypeNormal, "ConfigReloaded", "Reloaded the configuration from %s", file)
+}
+
+// ConfigReloadFailed implements configReporter. Every failed reload is
+// published, they only happen when the configuration file changes.
+func (h *healthRecorder) ConfigReloadFailed(file string, err error) {
+	h.recorder.Eventf(h.ref, v1.EventTypeWarning, "ConfigReloadFailed", "Could not reload the configuration from %s, the sinks are unchanged: %v", file, err)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kuoss/eventrouter/sinks"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func drain(ch chan string) []string {
+	var out []string
+	for {
+		select {
+		case e := <-ch:
+			out = append(out, e)
+		default:
+			return out
+		}
+	}
+}
+
+func TestHealthRecorder(t *testing.T) {
+	fake := record.NewFakeRecorder(100)
+	now := time.Date(2024, 3, 7, 10, 0, 0, 0, time.UTC)
+	h := newHealthRecorderWith(fake, &v1.ObjectReference{Kind: "Pod", Name: "eventrouter-1"}, 3, time.Minute)
+	h.now = func() time.Time { return now }
+	var _ sinks.HealthReporter = h
+
+	down := errors.New("connection refused")
+	h.SinkFailed("kafka", down)
+	h.SinkFailed("kafka", down)
+	require.Empty(t, drain(fake.Events), "failures below the threshold are not published")
+
+	h.SinkFailed("kafka", down)
+	h.SinkFailed("kafka", down)
+	require.Equal(t, []string{"Warning SinkFailing Sink kafka failed 3 times in a row: connection refused"}, drain(fake.Events))
+
+	now = now.Add(time.Minute)
+	h.SinkFailed("kafka", down)
+	require.Equal(t, []string{"Warning SinkFailing Sink kafka failed 5 times in a row: connection refused"}, drain(fake.Events))
+
+	h.SinkSucceeded("kafka")
+	h.SinkSucceeded("kafka")
+	require.Equal(t, []string{"Normal SinkRecovered Sink kafka delivers events again"}, drain(fake.Events))
+
+	h.BufferFull("http")
+	h.BufferFull("http")
+	h.BufferFull("s3sink")
+	require.Equal(t, []string{
+		"Warning SinkBufferFull The buffer of sink http is full, events are dropped or delayed",
+		"Warning SinkBufferFull The buffer of sink s3sink is full, events are dropped or delayed",
+	}, drain(fake.Events))
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2017 The Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/golang/glog"
+	"github.com/kuoss/eventrouter/sinks"
+	"github.com/spf13/viper"
+)
+
+// configReporter is told about the outcome of configuration reloads
+type configReporter interface {
+	ConfigReloaded(file string)
+	ConfigReloadFailed(file string, err error)
+}
+
+// nopConfigReporter is the configReporter used when no events are published
+type nopConfigReporter struct{}
+
+func (nopConfigReporter) ConfigReloaded(string)            {}
+func (nopConfigReporter) ConfigReloadFailed(string, error) {}
+
+// watchConfig reloads the configuration whenever the configuration file
+// changes, including when the ConfigMap it is mounted from is updated.
+func (er *EventRouter) watchConfig(reporter configReporter) {
+	viper.OnConfigChange(func(fsnotify.Event) {
+		er.reloadConfig(reporter)
+	})
+	viper.WatchConfig()
+}
+
+// reloadConfig reads and validates the configuration file again and replaces
+// the sinks with those it configures, flushing and closing the previous ones.
+// The other settings keep the values eventrouter was started with. When the
+// configuration is invalid or its sinks cannot be built, the running sinks
+// are kept.
+func (er *EventRouter) reloadConfig(reporter configReporter) {
+	file := viper.ConfigFileUsed()
+	glog.Infof("Reloading the configuration from %s", file)
+
+	err := parseConfig()
+	var sink sinks.EventSinkInterface
+	var closeOld func(time.Duration) bool
+	if err == nil {
+		sink, closeOld, err = sinks.Rebuild()
+	}
+	if err != nil {
+		glog.Errorf("Config reload failed, the sinks are unchanged: %v", err)
+		reporter.ConfigReloadFailed(file, err)
+		return
+	}
+
+	er.setSink(sink)
+	closeOld(viper.GetDuration("shutdown-timeout"))
+	reporter.ConfigReloaded(file)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kuoss/eventrouter/sinks"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestReloadConfig(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.json")
+	viper.SetConfigFile(file)
+	defer viper.SetConfigFile("")
+	defer sinks.Shutdown(0)
+
+	fake := record.NewFakeRecorder(10)
+	h := newHealthRecorderWith(fake, &v1.ObjectReference{Kind: "Pod", Name: "eventrouter-1"}, 3, 0)
+	var _ configReporter = h
+	initial := &recordingSink{}
+	er := &EventRouter{eSink: initial}
+
+	require.NoError(t, os.WriteFile(file, []byte(`{"sink": "stdout"`), 0o600))
+	er.reloadConfig(h)
+	require.Same(t, initial, er.eSink)
+	events := drain(fake.Events)
+	require.Len(t, events, 1)
+	require.Contains(t, events[0], "Warning ConfigReloadFailed Could not reload the configuration from "+file+", the sinks are unchanged: ReadInConfig err:")
+
+	require.NoError(t, os.WriteFile(file, []byte(`{"sink": "nope"}`), 0o600))
+	er.reloadConfig(h)
+	require.Same(t, initial, er.eSink)
+	events = drain(fake.Events)
+	require.Len(t, events, 1)
+	require.Contains(t, events[0], `sink: invalid Sink Specified "nope"`)
+
+	require.NoError(t, os.WriteFile(file, []byte(`{"sink": "stdout"}`), 0o600))
+	er.reloadConfig(h)
+	require.IsType(t, &sinks.StdoutSink{}, er.eSink)
+	require.Equal(t, []string{"Normal ConfigReloaded Reloaded the configuration from " + file}, drain(fake.Events))
+}
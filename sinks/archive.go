@@ -0,0 +1,365 @@
+/*
+Copyright 2017 The Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sinks
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+	"time"
+
+	"github.com/eapache/channels"
+	"github.com/golang/glog"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/uuid"
+)
+
+// objectStore writes the files of an archive sink
+type objectStore interface {
+	put(key string, body []byte, contentType string, contentEncoding string) error
+}
+
+// archiveOptions are the options shared by the archive sinks, read from the
+// configuration keys starting with prefix
+type archiveOptions struct {
+	prefix          string
+	dir             string
+	bufferSize      int
+	discardMessages bool
+	uploadInterval  int
+	uploadBytes     int
+	retainBytes     int
+	compression     string
+	keyTemplate     string
+	splitBatch      bool
+
+	// tmpl is keyTemplate parsed by validate, and fileCompression the
+	// compression of whole files
+	tmpl            *template.Template
+	fileCompression string
+}
+
+// validate checks the options and the output of the sink, and sets the
+// encoder of out
+func (o *archiveOptions) validate(out *OutputConfig) []error {
+	var errs []error
+	if o.uploadBytes < 0 || o.retainBytes < 0 {
+		errs = append(errs, fmt.Errorf("%sUploadBytes and %sRetainBytes must not be negative", o.prefix, o.prefix))
+	}
+	if o.keyTemplate != "" {
+		var err error
+		if o.tmpl, err = parseKeyTemplate(o.prefix+"KeyTemplate", o.keyTemplate); err != nil {
+			errs = append(errs, err)
+		}
+	} else if o.splitBatch {
+		errs = append(errs, fmt.Errorf("%sSplitBatch needs %sKeyTemplate", o.prefix, o.prefix))
+	}
+	if err := out.Validate(); err != nil {
+		errs = append(errs, err)
+	} else {
+		out.encoder, o.fileCompression = compressFiles(out.encoder, o.compression, o.uploadBytes)
+	}
+	return errs
+}
+
+// defaultRetainBytes is the default size of the retained failed uploads
+const defaultRetainBytes = 64 << 20
+
+// defaultArchiveKeyTemplate names the files of the archive sinks but the
+// s3sink, which keeps its legacy keys by default
+const defaultArchiveKeyTemplate = "{{.Dir}}/{{.Year}}/{{.Month}}/{{.Day}}/{{.Hostname}}-{{.UUID}}{{.Ext}}"
+
+// archiveFile is an encoded batch of events to be uploaded under key
+type archiveFile struct {
+	key    string
+	body   []byte
+	events int
+}
+
+/*
+archive is the batching shared by the sinks writing the events to files of
+an object store. The events are buffered and written to a new file if any of
+the below criteria gets fulfilled
+1) Time(uploadInterval): If the specfied time has passed since the last upload it uploads
+2) Data size(uploadBytes): If the total data getting uploaded becomes greater than N bytes
+
+Files that fail to upload are retained, up to retainBytes, and uploaded again
+under the same key before any newer file.
+*/
+type archive struct {
+	// sink is the name of the sink in logs and metrics, prefix the prefix of
+	// its configuration keys
+	sink   string
+	prefix string
+
+	// store writes the files
+	store objectStore
+
+	// dir is the first level directory in the bucket where the events would be stored
+	dir string
+
+	// lastUploadTimestamp stores the timestamp when the last upload happened
+	lastUploadTimestamp int64
+
+	// uploadInterval tells after how many seconds the next upload can happen
+	// sink waits till this time is passed before next upload can happen
+	uploadInterval time.Duration
+
+	// eventCh is used to interact eventRouter and the sharedInformer
+	eventCh channels.Channel
+
+	// pending stores the events captured since the last upload. They are
+	// encoded together so every file is a single payload of the output format.
+	pending []EventData
+
+	// pendingBytes estimates the encoded size of pending, it is tracked when
+	// uploadBytes is set
+	pendingBytes int
+
+	// uploadBytes uploads the pending events as soon as their encoded size
+	// reaches it, 0 disables the trigger
+	uploadBytes int
+
+	// retained holds the files waiting to be uploaded, oldest first. The
+	// oldest are dropped when they hold more than retainBytes.
+	retained      []archiveFile
+	retainedBytes int
+	retainBytes   int
+
+	// keyTemplate names the files instead of getNewKey when set, splitBatch
+	// writes the events of a batch to the files named by their own key
+	keyTemplate *template.Template
+	splitBatch  bool
+	hostname    string
+
+	// compression is the compression of the files, their Content-Encoding
+	compression string
+
+	// eventShaper shapes the events before they are written to the files
+	eventShaper
+}
+
+// newArchive returns the archive of the sink named name, writing to store
+func newArchive(name string, store objectStore, o *archiveOptions) archive {
+	a := archive{
+		sink:           name,
+		prefix:         o.prefix,
+		store:          store,
+		dir:            o.dir,
+		uploadInterval: time.Second * time.Duration(o.uploadInterval),
+		uploadBytes:    o.uploadBytes,
+		retainBytes:    o.retainBytes,
+		keyTemplate:    o.tmpl,
+		splitBatch:     o.splitBatch,
+		hostname:       podName(),
+		compression:    o.fileCompression,
+	}
+
+	if o.discardMessages {
+		a.eventCh = channels.NewOverflowingChannel(channels.BufferCap(o.bufferSize))
+	} else {
+		a.eventCh = channels.NewNativeChannel(channels.BufferCap(o.bufferSize))
+	}
+	return a
+}
+
+// start runs the archive until Shutdown, which uploads the buffered events
+func (a *archive) start() {
+	runUntilShutdown(a.Run)
+}
+
+// UpdateEvents implements the EventSinkInterface. It really just writes the
+// event data to the event OverflowingChannel, which should never block.
+// Messages that are buffered beyond the bufferSize specified for this sink
+// are discarded.
+func (a *archive) UpdateEvents(eNew *v1.Event, eOld *v1.Event) {
+	bufferEvent(a.sink, a.eventCh, a.eventData(eNew, eOld))
+}
+
+// Run sits in a loop, waiting for data to come in through h.eventCh,
+// and uploading them. If multiple events have happened
+// between loop iterations, it puts all of them in one request instead of
+// making a single request per event. A ticker uploads the events of quiet
+// periods and retries failed uploads. When stopCh is closed the buffered
+// events are uploaded before Run returns.
+func (a *archive) Run(stopCh <-chan bool) {
+	ticker := time.NewTicker(a.tick())
+	defer ticker.Stop()
+loop:
+	for {
+		select {
+		case e := <-a.eventCh.Out():
+			var evt EventData
+			var ok bool
+			if evt, ok = e.(EventData); !ok {
+				glog.Warningf("Invalid type sent through event channel: %T", e)
+				continue loop
+			}
+
+			// Start with just this event...
+			arr := []EventData{evt}
+
+			// Consume all buffered events into an array, in case more have been written
+			// since we last forwarded them
+			numEvents := a.eventCh.Len()
+			for i := 0; i < numEvents; i++ {
+				e := <-a.eventCh.Out()
+				if evt, ok = e.(EventData); ok {
+					arr = append(arr, evt)
+				} else {
+					glog.Warningf("Invalid type sent through event channel: %T", e)
+				}
+			}
+
+			a.drainEvents(arr)
+		case <-ticker.C:
+			a.flush()
+		case <-stopCh:
+			break loop
+		}
+	}
+
+	var arr []EventData
+	for a.eventCh.Len() > 0 {
+		if evt, ok := (<-a.eventCh.Out()).(EventData); ok {
+			arr = append(arr, evt)
+		}
+	}
+	a.pending = append(a.pending, arr...)
+	a.upload()
+}
+
+// drainEvents takes an array of event data and uploads it
+func (a *archive) drainEvents(events []EventData) {
+	a.pending = append(a.pending, events...)
+	if a.uploadBytes > 0 {
+		for i := range events {
+			if n, err := a.recordSize(&events[i]); err == nil {
+				a.pendingBytes += n
+			}
+		}
+	}
+
+	if !a.canUpload() && (a.uploadBytes == 0 || a.pendingBytes < a.uploadBytes) {
+		return
+	}
+
+	a.upload()
+}
+
+// flush uploads the pending events once uploadInterval has passed, and
+// retries the retained files
+func (a *archive) flush() {
+	if len(a.pending) > 0 && a.canUpload() {
+		a.upload()
+		return
+	}
+	a.uploadRetained()
+}
+
+// tick is the period of the ticker of Run. It is a fraction of
+// uploadInterval, so that the events of a quiet period are uploaded soon
+// after uploadInterval has passed rather than up to twice as late.
+func (a *archive) tick() time.Duration {
+	return max(a.uploadInterval/4, time.Second)
+}
+
+// canUpload verifies the conditions suitable for a new file upload and upload the data
+func (a *archive) canUpload() bool {
+	now := time.Now().UnixNano()
+	return (a.lastUploadTimestamp + a.uploadInterval.Nanoseconds()) <= now
+}
+
+// getNewKey gets the key name based on time
+func (a *archive) getNewKey(t time.Time) string {
+	return fmt.Sprintf("%s/%d/%d/%d/%d.txt", a.dir, t.Year(), t.Month(), t.Day(), t.UnixNano())
+}
+
+// upload encodes the pending events into new files and uploads them, after
+// the retained files
+func (a *archive) upload() {
+	now := time.Now()
+	a.lastUploadTimestamp = now.UnixNano()
+	if len(a.pending) > 0 {
+		for _, b := range a.batches(now) {
+			var buf bytes.Buffer
+			if err := a.output().WriteBatch(&buf, b.events); err != nil {
+				glog.Warningf("Could not write to event request body (wrote %v bytes): %v", buf.Len(), err)
+				discardEvents(a.sink, len(b.events))
+				continue
+			}
+			body, err := compressBody(a.compression, buf.Bytes())
+			if err != nil {
+				glog.Warningf("Could not compress event request body: %v", err)
+				discardEvents(a.sink, len(b.events))
+				continue
+			}
+			a.retained = append(a.retained, archiveFile{key: b.key, body: body, events: len(b.events)})
+			a.retainedBytes += len(body)
+		}
+		a.pending, a.pendingBytes = nil, 0
+	}
+	a.uploadRetained()
+}
+
+// batches names the files of the pending events uploaded at now
+func (a *archive) batches(now time.Time) []objectBatch {
+	if a.keyTemplate != nil {
+		base := objectKey{
+			Dir:      a.dir,
+			Cluster:  a.clusterID,
+			Hostname: a.hostname,
+			UUID:     string(uuid.NewUUID()),
+			Ext:      fileExtension(a.output()) + compressionExtensions[a.compression],
+		}
+		batches, err := splitObjects(a.keyTemplate, base, a.pending, a.splitBatch)
+		if err == nil {
+			return batches
+		}
+		glog.Errorf("Naming the upload with the default key: %v", err)
+	}
+	return []objectBatch{{key: a.getNewKey(now) + compressionExtensions[a.compression], events: a.pending}}
+}
+
+// uploadRetained uploads the retained files in order, stopping at the first
+// failure. The oldest files left are dropped while they exceed retainBytes.
+func (a *archive) uploadRetained() {
+	for len(a.retained) > 0 {
+		f := a.retained[0]
+		var encoding string
+		if _, ok := compressionExtensions[a.compression]; ok {
+			encoding = a.compression
+		}
+		err := a.store.put(f.key, f.body, a.output().ContentType(), encoding)
+		if err != nil {
+			glog.Errorf("Error uploading %s from %s, %v", f.key, a.sink, err)
+			reportFailure(a.sink, err)
+			break
+		}
+		reportSuccess(a.sink)
+		glog.Infof("Uploaded at %s", f.key)
+		a.retained = a.retained[1:]
+		a.retainedBytes -= len(f.body)
+	}
+	for len(a.retained) > 0 && a.retainedBytes > a.retainBytes {
+		f := a.retained[0]
+		glog.Errorf("Dropping %s with %d events, the failed uploads exceed %sRetainBytes", f.key, f.events, a.prefix)
+		discardEvents(a.sink, f.events)
+		a.retained = a.retained[1:]
+		a.retainedBytes -= len(f.body)
+	}
+}
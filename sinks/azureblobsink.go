@@ -0,0 +1,165 @@
+/*
+Copyright 2017 The Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sinks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+)
+
+func init() {
+	Register("azureblob", SinkFactory{
+		NewConfig: func() interface{} { return defaultAzureBlobSinkConfig() },
+		New: func(name string, cfg interface{}) (EventSinkInterface, error) {
+			c := cfg.(*AzureBlobSinkConfig)
+			s, err := newAzureBlobSink(name, c)
+			if err != nil {
+				return nil, err
+			}
+			s.configure(&c.OutputConfig)
+			s.start()
+			return s, nil
+		},
+	})
+}
+
+// AzureBlobSink is the sink that uploads the kubernetes events to block blobs
+// of an Azure Storage container, batched like the files of the S3Sink
+type AzureBlobSink struct {
+	// client is the client of the storage account
+	client *azblob.Client
+
+	// container is the container where the events data would be stored
+	container string
+
+	archive
+}
+
+// AzureBlobSinkConfig is the configuration of the azureblob sink
+type AzureBlobSinkConfig struct {
+	// ServiceURL is the blob endpoint of the storage account, like
+	// https://account.blob.core.windows.net/, or the one of Azurite. It may
+	// carry a SAS token.
+	ServiceURL   string `mapstructure:"azureBlobSinkServiceURL" validate:"required"`
+	Container    string `mapstructure:"azureBlobSinkContainer" validate:"required"`
+	ContainerDir string `mapstructure:"azureBlobSinkContainerDir" validate:"required"`
+
+	// AccountName and AccountKey are a shared key. When they are not set
+	// and ServiceURL has no SAS token the default Azure credential is used:
+	// the environment, workload identity and managed identities.
+	AccountName string `mapstructure:"azureBlobSinkAccountName"`
+	AccountKey  string `mapstructure:"azureBlobSinkAccountKey"`
+
+	// The keys below behave like their s3Sink counterpart
+	BufferSize      int    `mapstructure:"azureBlobSinkBufferSize"`
+	DiscardMessages bool   `mapstructure:"azureBlobSinkDiscardMessages"`
+	UploadInterval  int    `mapstructure:"azureBlobSinkUploadInterval"`
+	UploadBytes     int    `mapstructure:"azureBlobSinkUploadBytes"`
+	RetainBytes     int    `mapstructure:"azureBlobSinkRetainBytes"`
+	Compression     string `mapstructure:"azureBlobSinkCompression" validate:"oneof=none gzip zstd"`
+	KeyTemplate     string `mapstructure:"azureBlobSinkKeyTemplate"`
+	SplitBatch      bool   `mapstructure:"azureBlobSinkSplitBatch"`
+
+	// options are the archive options set by Validate
+	options archiveOptions
+
+	OutputConfig `mapstructure:",squash"`
+}
+
+// Validate implements config.Validator
+func (c *AzureBlobSinkConfig) Validate() error {
+	c.options = archiveOptions{
+		prefix:          "azureBlobSink",
+		dir:             c.ContainerDir,
+		bufferSize:      c.BufferSize,
+		discardMessages: c.DiscardMessages,
+		uploadInterval:  c.UploadInterval,
+		uploadBytes:     c.UploadBytes,
+		retainBytes:     c.RetainBytes,
+		compression:     c.Compression,
+		keyTemplate:     c.KeyTemplate,
+		splitBatch:      c.SplitBatch,
+	}
+	errs := c.options.validate(&c.OutputConfig)
+	if u, err := url.Parse(c.ServiceURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, fmt.Errorf("azureBlobSinkServiceURL: invalid URL %q", c.ServiceURL))
+	}
+	if (c.AccountName == "") != (c.AccountKey == "") {
+		errs = append(errs, errors.New("azureBlobSinkAccountName and azureBlobSinkAccountKey must be set together"))
+	}
+	return errors.Join(errs...)
+}
+
+func defaultAzureBlobSinkConfig() *AzureBlobSinkConfig {
+	return &AzureBlobSinkConfig{
+		BufferSize:      1500,
+		DiscardMessages: true,
+		UploadInterval:  120,
+		RetainBytes:     defaultRetainBytes,
+		Compression:     "none",
+		KeyTemplate:     defaultArchiveKeyTemplate,
+		OutputConfig:    OutputConfig{Format: "ndjson"},
+	}
+}
+
+// newAzureBlobSink returns the sink named name of c, which has been validated
+func newAzureBlobSink(name string, c *AzureBlobSinkConfig) (*AzureBlobSink, error) {
+	client, err := c.newClient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the Azure Blob Storage client: %w", err)
+	}
+
+	s := &AzureBlobSink{client: client, container: c.Container}
+	s.archive = newArchive(name, s, &c.options)
+	return s, nil
+}
+
+// newClient returns the client of the storage account, authenticated with
+// the shared key, the SAS token of the URL or the default Azure credential
+func (c *AzureBlobSinkConfig) newClient() (*azblob.Client, error) {
+	if c.AccountName != "" {
+		cred, err := azblob.NewSharedKeyCredential(c.AccountName, c.AccountKey)
+		if err != nil {
+			return nil, err
+		}
+		return azblob.NewClientWithSharedKeyCredential(c.ServiceURL, cred, nil)
+	}
+	if u, err := url.Parse(c.ServiceURL); err == nil && u.Query().Get("sig") != "" {
+		return azblob.NewClientWithNoCredential(c.ServiceURL, nil)
+	}
+	cred, err := azidentity.NewDefaultAzureCredential(nil)
+	if err != nil {
+		return nil, err
+	}
+	return azblob.NewClient(c.ServiceURL, cred, nil)
+}
+
+// put implements objectStore, the files are written in a single request
+func (s *AzureBlobSink) put(key string, body []byte, contentType string, contentEncoding string) error {
+	headers := &blob.HTTPHeaders{BlobContentType: &contentType}
+	if contentEncoding != "" {
+		headers.BlobContentEncoding = &contentEncoding
+	}
+	_, err := s.client.UploadBuffer(context.Background(), s.container, key, body, &azblob.UploadBufferOptions{HTTPHeaders: headers})
+	return err
+}
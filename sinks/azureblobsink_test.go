@@ -0,0 +1,112 @@
+package sinks
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+)
+
+// azureBlob is a blob kept by fakeAzureBlob
+type azureBlob struct {
+	contentType, contentEncoding string
+	body                         string
+}
+
+// fakeAzureBlob is an in-process Blob service keeping the block blobs put in
+// a single request by path, and how they were authorized, like Azurite
+type fakeAzureBlob struct {
+	mu    sync.Mutex
+	blobs map[string]azureBlob
+	auth  []string
+}
+
+func (f *fakeAzureBlob) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut || r.Header.Get("x-ms-blob-type") != "BlockBlob" {
+		http.Error(w, "not implemented", http.StatusNotImplemented)
+		return
+	}
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.blobs == nil {
+		f.blobs = map[string]azureBlob{}
+	}
+	f.blobs[r.URL.Path] = azureBlob{
+		contentType:     r.Header.Get("x-ms-blob-content-type"),
+		contentEncoding: r.Header.Get("x-ms-blob-content-encoding"),
+		body:            string(b),
+	}
+	auth, _, _ := strings.Cut(r.Header.Get("Authorization"), ":")
+	if r.URL.Query().Get("sig") != "" {
+		auth = "SAS"
+	}
+	f.auth = append(f.auth, auth)
+	w.Header().Set("ETag", `"0x8DC0000000000000"`)
+	w.Header().Set("Last-Modified", "Tue, 05 Mar 2024 10:00:00 GMT")
+	w.WriteHeader(http.StatusCreated)
+}
+
+func TestAzureBlobSink(t *testing.T) {
+	tests := []struct {
+		name   string
+		config func(c *AzureBlobSinkConfig, url string)
+		auth   string
+	}{
+		{
+			name: "shared key",
+			config: func(c *AzureBlobSinkConfig, url string) {
+				// The well-known account of Azurite
+				c.ServiceURL = url + "/devstoreaccount1"
+				c.AccountName = "devstoreaccount1"
+				c.AccountKey = "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw=="
+			},
+			auth: "SharedKey devstoreaccount1",
+		},
+		{
+			name: "sas token",
+			config: func(c *AzureBlobSinkConfig, url string) {
+				c.ServiceURL = url + "/devstoreaccount1?sv=2023-11-03&sp=cw&sig=c2lnbmF0dXJl"
+			},
+			auth: "SAS",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &fakeAzureBlob{}
+			srv := httptest.NewServer(f)
+			defer srv.Close()
+
+			c := defaultAzureBlobSinkConfig()
+			c.Container, c.ContainerDir = "events", "archive"
+			c.Format = "csv"
+			c.KeyTemplate = "{{.Dir}}/namespace={{.Namespace}}/{{.UUID}}{{.Ext}}"
+			tt.config(c, srv.URL)
+			require.NoError(t, c.Validate())
+			s, err := newAzureBlobSink("azureblob", c)
+			require.NoError(t, err)
+			s.configure(&c.OutputConfig)
+
+			s.drainEvents([]EventData{NewEventData(&v1.Event{InvolvedObject: v1.ObjectReference{Namespace: "a"}, Message: "archived"}, nil)})
+			require.Empty(t, s.retained)
+			require.Len(t, f.blobs, 1)
+			for path, b := range f.blobs {
+				require.True(t, strings.HasPrefix(path, "/devstoreaccount1/events/archive/namespace=a/"), path)
+				require.True(t, strings.HasSuffix(path, ".csv"), path)
+				require.Equal(t, "text/csv; charset=utf-8", b.contentType)
+				require.Empty(t, b.contentEncoding)
+				require.Contains(t, b.body, "archived")
+			}
+			require.Equal(t, []string{tt.auth}, f.auth)
+		})
+	}
+}
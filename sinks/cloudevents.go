@@ -0,0 +1,206 @@
+/*
+Copyright 2017 The Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sinks
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/uuid"
+)
+
+// CloudEventsSpecVersion is the CloudEvents version written by the sinks
+const CloudEventsSpecVersion = "1.0"
+
+// cloudEventsContentType is the content type of a structured CloudEvent
+const cloudEventsContentType = "application/cloudevents+json; charset=UTF-8"
+
+// CloudEventsConfig makes the http and kafka sinks write CloudEvents 1.0.
+// In the structured mode the whole CloudEvent is one JSON document, in the
+// binary mode its attributes are carried by headers and the record encoded
+// with outputFormat is the payload.
+type CloudEventsConfig struct {
+	Mode string `mapstructure:"cloudEventsMode" validate:"oneof=off structured binary"`
+
+	// TypePrefix starts the type attribute, which continues with the verb
+	// and the reason of the event, e.g. "io.k8s.event.updated.BackOff"
+	TypePrefix string `mapstructure:"cloudEventsTypePrefix"`
+}
+
+func defaultCloudEventsConfig() CloudEventsConfig {
+	return CloudEventsConfig{Mode: "off", TypePrefix: "io.k8s.event"}
+}
+
+// CloudEvents returns the CloudEvents writer configured by c, nil when they
+// are off
+func (c *CloudEventsConfig) CloudEvents() *CloudEvents {
+	if c.Mode == "" || c.Mode == "off" {
+		return nil
+	}
+	return &CloudEvents{binary: c.Mode == "binary", typePrefix: c.TypePrefix}
+}
+
+// CloudEvents maps EventData to CloudEvents
+type CloudEvents struct {
+	binary     bool
+	typePrefix string
+}
+
+// cloudEvent is a CloudEvent in its structured JSON form
+type cloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject,omitempty"`
+	Time            string          `json:"time,omitempty"`
+	DataContentType string          `json:"datacontenttype"`
+	Data            json.RawMessage `json:"data,omitempty"`
+	DataBase64      []byte          `json:"data_base64,omitempty"`
+
+	// payload is the record encoded by the sink, the data of the binary mode
+	payload []byte
+}
+
+// event maps e, shaped by the eventShaper s of the sink, to a CloudEvent:
+//
+//	id      the UID and the resourceVersion of the event
+//	source  /clusters/<cluster>/components/<reporting component>
+//	type    <prefix>.<verb>.<reason>
+//	subject <kind>/<namespace>/<name> of the involved object
+//	time    the last timestamp of the event
+func (c *CloudEvents) event(s *eventShaper, e *EventData) (*cloudEvent, error) {
+	ce := &cloudEvent{
+		SpecVersion:     CloudEventsSpecVersion,
+		ID:              cloudEventID(e),
+		Source:          cloudEventSource(s.clusterID, e),
+		Type:            c.eventType(e),
+		Subject:         cloudEventSubject(e),
+		DataContentType: recordContentType(s.output(), e),
+	}
+	if t := eventTime(e); !t.IsZero() {
+		ce.Time = t.UTC().Format(time.RFC3339Nano)
+	}
+	var err error
+	if c.binary {
+		ce.payload, err = s.encode(e)
+		return ce, err
+	}
+	if _, ok := s.output().(schemaEncoder); ok {
+		// Binary records are base64 encoded in data_base64
+		ce.DataBase64, err = s.encode(e)
+		return ce, err
+	}
+	if ce.Data, err = s.encodeJSONValue(e); err != nil {
+		return nil, err
+	}
+	if ce.DataContentType != "application/json" {
+		// Data holds the record as a JSON string
+		ce.DataContentType = "text/plain; charset=utf-8"
+	}
+	return ce, nil
+}
+
+// structured returns the JSON document of the structured mode
+func (ce *cloudEvent) structured() ([]byte, error) {
+	return json.Marshal(ce)
+}
+
+// headers returns the attributes of the binary mode as headers named with
+// prefix, "ce-" for HTTP and "ce_" for Kafka. The content type is left to
+// the caller as its header name depends on the protocol.
+func (ce *cloudEvent) headers(prefix string) map[string]string {
+	h := map[string]string{
+		prefix + "specversion": ce.SpecVersion,
+		prefix + "id":          ce.ID,
+		prefix + "source":      ce.Source,
+		prefix + "type":        ce.Type,
+	}
+	if ce.Subject != "" {
+		h[prefix+"subject"] = ce.Subject
+	}
+	if ce.Time != "" {
+		h[prefix+"time"] = ce.Time
+	}
+	return h
+}
+
+func (c *CloudEvents) eventType(e *EventData) string {
+	t := c.typePrefix + "." + strings.ToLower(e.Verb)
+	if e.Event.Reason != "" {
+		t += "." + e.Event.Reason
+	}
+	return t
+}
+
+func cloudEventID(e *EventData) string {
+	if e.Event.UID == "" {
+		return string(uuid.NewUUID())
+	}
+	return string(e.Event.UID) + "." + e.Event.ResourceVersion
+}
+
+func cloudEventSource(clusterID string, e *EventData) string {
+	if clusterID == "" {
+		clusterID = "unknown"
+	}
+	component := e.Event.Source.Component
+	if component == "" {
+		component = e.Event.ReportingController
+	}
+	if component == "" {
+		component = "unknown"
+	}
+	return "/clusters/" + clusterID + "/components/" + component
+}
+
+func cloudEventSubject(e *EventData) string {
+	o := e.Event.InvolvedObject
+	if o.Name == "" {
+		return ""
+	}
+	if o.Namespace == "" {
+		return o.Kind + "/" + o.Name
+	}
+	return o.Kind + "/" + o.Namespace + "/" + o.Name
+}
+
+// eventTime returns the last time the event occurred
+func eventTime(e *EventData) time.Time {
+	switch {
+	case !e.Event.LastTimestamp.IsZero():
+		return e.Event.LastTimestamp.Time
+	case !e.Event.EventTime.IsZero():
+		return e.Event.EventTime.Time
+	case !e.Event.FirstTimestamp.IsZero():
+		return e.Event.FirstTimestamp.Time
+	}
+	return e.Event.CreationTimestamp.Time
+}
+
+// recordContentType is the MIME type of a single record of e encoded by enc
+func recordContentType(enc Encoder, e *EventData) string {
+	if e.transform.IsText() {
+		return "text/plain; charset=utf-8"
+	}
+	ct := enc.ContentType()
+	if strings.HasSuffix(ct, "json") {
+		return "application/json"
+	}
+	return ct
+}
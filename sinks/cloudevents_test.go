@@ -0,0 +1,200 @@
+package sinks
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func cloudEventsTestEvent() *v1.Event {
+	return &v1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Name: "web-1.17b", Namespace: "default", UID: "0b5e", ResourceVersion: "42"},
+		InvolvedObject: v1.ObjectReference{Kind: "Pod", Namespace: "default", Name: "web-1"},
+		Reason:         "BackOff",
+		Source:         v1.EventSource{Component: "kubelet"},
+		LastTimestamp:  metav1.NewTime(time.Date(2024, 3, 7, 10, 0, 0, 0, time.UTC)),
+	}
+}
+
+func newCloudEventsShaper(t *testing.T, mode string, format string, keep ...string) (*eventShaper, *CloudEvents) {
+	c := defaultCloudEventsConfig()
+	c.Mode = mode
+	out := &OutputConfig{Format: format, TransformConfig: TransformConfig{Keep: keep}}
+	require.NoError(t, out.Validate())
+	s := &eventShaper{clusterID: "cluster-1"}
+	s.configure(out)
+	return s, c.CloudEvents()
+}
+
+func TestCloudEvents_event(t *testing.T) {
+	s, ce := newCloudEventsShaper(t, "structured", "json", "event.reason")
+	e := s.eventData(cloudEventsTestEvent(), &v1.Event{})
+	got, err := ce.event(s, &e)
+	require.NoError(t, err)
+	b, err := got.structured()
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"specversion": "1.0",
+		"id": "0b5e.42",
+		"source": "/clusters/cluster-1/components/kubelet",
+		"type": "io.k8s.event.updated.BackOff",
+		"subject": "Pod/default/web-1",
+		"time": "2024-03-07T10:00:00Z",
+		"datacontenttype": "application/json",
+		"data": {"event": {"reason": "BackOff"}}
+	}`, string(b))
+
+	// Text records are JSON strings in the structured mode
+	s, ce = newCloudEventsShaper(t, "structured", "logfmt", "event.reason")
+	e = s.eventData(cloudEventsTestEvent(), nil)
+	got, err = ce.event(s, &e)
+	require.NoError(t, err)
+	require.Equal(t, "text/plain; charset=utf-8", got.DataContentType)
+	require.Equal(t, `"event.reason=BackOff"`, string(got.Data))
+
+	// Events without a UID get a random id
+	s, ce = newCloudEventsShaper(t, "binary", "json")
+	e = s.eventData(&v1.Event{InvolvedObject: v1.ObjectReference{Kind: "Node", Name: "node-1"}}, nil)
+	got, err = ce.event(s, &e)
+	require.NoError(t, err)
+	require.NotEmpty(t, got.ID)
+	require.Equal(t, "/clusters/cluster-1/components/unknown", got.Source)
+	require.Equal(t, "io.k8s.event.added", got.Type)
+	require.Equal(t, "Node/node-1", got.Subject)
+	require.Empty(t, got.Time)
+	require.Equal(t, map[string]string{
+		"ce-specversion": "1.0",
+		"ce-id":          got.ID,
+		"ce-source":      "/clusters/cluster-1/components/unknown",
+		"ce-type":        "io.k8s.event.added",
+		"ce-subject":     "Node/node-1",
+	}, got.headers("ce-"))
+}
+
+func TestHTTPSink_cloudEvents(t *testing.T) {
+	type request struct {
+		header http.Header
+		body   string
+	}
+	requests := make(chan request, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		requests <- request{r.Header, string(b)}
+	}))
+	defer srv.Close()
+
+	newSink := func(mode string) *HTTPSink {
+		c := defaultHTTPSinkConfig()
+		c.Mode = mode
+		c.Keep = []string{"event.reason"}
+		require.NoError(t, c.Validate())
+		require.Equal(t, "json", c.Format)
+		h := NewHTTPSink(srv.URL, true, 10)
+		h.configure(&c.OutputConfig)
+		h.cloudEvents = c.CloudEvents()
+		h.setClusterID("cluster-1")
+		return h
+	}
+
+	h := newSink("binary")
+	h.drainEvents([]EventData{h.eventData(cloudEventsTestEvent(), nil)})
+	got := <-requests
+	require.Equal(t, "application/json", got.header.Get("Content-Type"))
+	require.Equal(t, "1.0", got.header.Get("ce-specversion"))
+	require.Equal(t, "0b5e.42", got.header.Get("ce-id"))
+	require.Equal(t, "io.k8s.event.added.BackOff", got.header.Get("ce-type"))
+	require.Equal(t, "/clusters/cluster-1/components/kubelet", got.header.Get("ce-source"))
+	require.Equal(t, "Pod/default/web-1", got.header.Get("ce-subject"))
+	require.Equal(t, "2024-03-07T10:00:00Z", got.header.Get("ce-time"))
+	require.JSONEq(t, `{"event":{"reason":"BackOff"}}`, got.body)
+
+	// Each event is sent in its own request
+	h = newSink("structured")
+	h.drainEvents([]EventData{h.eventData(cloudEventsTestEvent(), nil), h.eventData(cloudEventsTestEvent(), nil)})
+	for i := 0; i < 2; i++ {
+		got = <-requests
+		require.Equal(t, "application/cloudevents+json; charset=UTF-8", got.header.Get("Content-Type"))
+		require.Empty(t, got.header.Get("ce-id"))
+		var ce map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(got.body), &ce))
+		require.Equal(t, "io.k8s.event.added.BackOff", ce["type"])
+		require.Equal(t, map[string]interface{}{"event": map[string]interface{}{"reason": "BackOff"}}, ce["data"])
+	}
+}
+
+func TestKafkaSink_cloudEvents(t *testing.T) {
+	s, ce := newCloudEventsShaper(t, "binary", "json", "event.reason")
+	ks := &KafkaSink{Topic: "events", eventShaper: *s, cloudEvents: ce}
+	msg, err := ks.message(cloudEventsTestEvent(), nil)
+	require.NoError(t, err)
+	headers := map[string]string{}
+	for _, h := range msg.Headers {
+		headers[string(h.Key)] = string(h.Value)
+	}
+	require.Equal(t, map[string]string{
+		"content-type":   "application/json",
+		"ce_specversion": "1.0",
+		"ce_id":          "0b5e.42",
+		"ce_source":      "/clusters/cluster-1/components/kubelet",
+		"ce_type":        "io.k8s.event.added.BackOff",
+		"ce_subject":     "Pod/default/web-1",
+		"ce_time":        "2024-03-07T10:00:00Z",
+	}, headers)
+	value, err := msg.Value.Encode()
+	require.NoError(t, err)
+	require.JSONEq(t, `{"event":{"reason":"BackOff"}}`, string(value))
+
+	s, ce = newCloudEventsShaper(t, "structured", "json", "event.reason")
+	ks = &KafkaSink{Topic: "events", eventShaper: *s, cloudEvents: ce}
+	msg, err = ks.message(cloudEventsTestEvent(), nil)
+	require.NoError(t, err)
+	require.Len(t, msg.Headers, 1)
+	require.Equal(t, "application/cloudevents+json; charset=UTF-8", string(msg.Headers[0].Value))
+	value, err = msg.Value.Encode()
+	require.NoError(t, err)
+	require.Contains(t, string(value), `"specversion":"1.0"`)
+	require.Contains(t, string(value), `"data":{"event":{"reason":"BackOff"}}`)
+}
+
+func TestNeedsClusterID(t *testing.T) {
+	testCases := []struct {
+		name     string
+		settings map[string]interface{}
+		want     bool
+	}{
+		{"v1", map[string]interface{}{"sink": "glog"}, false},
+		{"v2", map[string]interface{}{"outputEnvelope": "v2"}, true},
+		{"v2 with clusterID", map[string]interface{}{"outputEnvelope": "v2", "clusterID": "prod"}, false},
+		{"cloudEvents", map[string]interface{}{"cloudEventsMode": "binary"}, true},
+		{"cloudEvents off", map[string]interface{}{"cloudEventsMode": "off"}, false},
+		{"cloudEvents in a sink instance", map[string]interface{}{"sinks": map[string]interface{}{
+			"out":    map[string]interface{}{"sink": "stdout"},
+			"events": map[string]interface{}{"sink": "http", "cloudEventsMode": "structured"},
+		}}, true},
+		{"kafka headers in a sink instance", map[string]interface{}{"sinks": map[string]interface{}{
+			"events": map[string]interface{}{"sink": "kafka", "kafkaHeaders": true},
+		}}, true},
+		{"s3 key template", map[string]interface{}{"sink": "s3sink", "s3SinkKeyTemplate": "{{.Dir}}/cluster={{.Cluster}}/{{.UUID}}{{.Ext}}"}, true},
+		{"gcs key template in a sink instance", map[string]interface{}{"sinks": map[string]interface{}{
+			"archive": map[string]interface{}{"sink": "gcs", "gcsSinkKeyTemplate": "{{.Cluster}}/{{.UUID}}{{.Ext}}"},
+		}}, true},
+		{"azure blob default key template", map[string]interface{}{"sink": "azureblob"}, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			v := viper.New()
+			for k, val := range tc.settings {
+				v.Set(k, val)
+			}
+			require.Equal(t, tc.want, NeedsClusterID(v))
+		})
+	}
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2017 The Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sinks
+
+import (
+	"bytes"
+	"compress/gzip"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress"
+)
+
+// compressionExtensions are the file extensions of the compressions of the
+// archive sinks, the compression names their Content-Encoding
+var compressionExtensions = map[string]string{
+	"gzip": ".gz",
+	"zstd": ".zst",
+}
+
+// parquetCodecs compress the columns of parquet files instead of the whole
+// files, which could not be read without being decompressed first
+var parquetCodecs = map[string]compress.Codec{
+	"gzip": &parquet.Gzip,
+	"zstd": &parquet.Zstd,
+}
+
+// zstdEncoder is shared by the sinks, EncodeAll can be called concurrently
+var zstdEncoder = sync.OnceValues(func() (*zstd.Encoder, error) {
+	return zstd.NewWriter(nil)
+})
+
+// compressBody returns body compressed with compression, as is when it is
+// none or empty
+func compressBody(compression string, body []byte) ([]byte, error) {
+	switch compression {
+	case "gzip":
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(body); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case "zstd":
+		enc, err := zstdEncoder()
+		if err != nil {
+			return nil, err
+		}
+		return enc.EncodeAll(body, make([]byte, 0, len(body)/4)), nil
+	}
+	return body, nil
+}
+
+// compressFiles applies compression to the files written by enc. Parquet
+// encoders compress their columns with it instead and return no file
+// compression. rowGroupBytes sizes their row groups.
+func compressFiles(enc Encoder, compression string, rowGroupBytes int) (Encoder, string) {
+	p, ok := enc.(parquetEncoder)
+	if !ok {
+		return enc, compression
+	}
+	if codec, ok := parquetCodecs[compression]; ok {
+		p.codec = codec
+	}
+	p.rowGroupBytes = rowGroupBytes
+	return p, "none"
+}
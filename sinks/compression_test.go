@@ -0,0 +1,52 @@
+package sinks
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/parquet-go/parquet-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompressBody(t *testing.T) {
+	body := bytes.Repeat([]byte(`{"verb":"ADDED"}`+"\n"), 100)
+
+	b, err := compressBody("none", body)
+	require.NoError(t, err)
+	require.Equal(t, body, b)
+
+	b, err = compressBody("gzip", body)
+	require.NoError(t, err)
+	require.Less(t, len(b), len(body))
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	require.NoError(t, err)
+	got, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	require.Equal(t, body, got)
+
+	b, err = compressBody("zstd", body)
+	require.NoError(t, err)
+	require.Less(t, len(b), len(body))
+	dec, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer dec.Close()
+	got, err = dec.DecodeAll(b, nil)
+	require.NoError(t, err)
+	require.Equal(t, body, got)
+}
+
+func TestCompressFiles(t *testing.T) {
+	enc, compression := compressFiles(ndjsonEncoder{}, "zstd", 100)
+	require.Equal(t, ndjsonEncoder{}, enc)
+	require.Equal(t, "zstd", compression)
+
+	enc, compression = compressFiles(parquetEncoder{codec: &parquet.Snappy}, "zstd", 100)
+	require.Equal(t, parquetEncoder{codec: &parquet.Zstd, rowGroupBytes: 100}, enc)
+	require.Equal(t, "none", compression)
+
+	enc, _ = compressFiles(parquetEncoder{codec: &parquet.Snappy}, "none", 0)
+	require.Equal(t, parquetEncoder{codec: &parquet.Snappy}, enc)
+}
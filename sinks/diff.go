@@ -0,0 +1,97 @@
+/*
+Copyright 2017 The Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sinks
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// diffChanges returns the fields differing between the generic JSON
+// documents oldDoc and newDoc, keyed by their dot separated path, with their
+// "old" and "new" values. Lists are compared as a whole. A missing value is
+// reported as nil.
+func diffChanges(oldDoc, newDoc map[string]interface{}) map[string]interface{} {
+	changes := map[string]interface{}{}
+	walkDiff(nil, oldDoc, newDoc, func(path []string, o, n interface{}, _, _ bool) {
+		changes[strings.Join(path, ".")] = map[string]interface{}{"old": o, "new": n}
+	})
+	return changes
+}
+
+// diffPatch returns the RFC 6902 JSON Patch turning oldDoc into newDoc
+func diffPatch(oldDoc, newDoc map[string]interface{}) []interface{} {
+	patch := []interface{}{}
+	walkDiff(nil, oldDoc, newDoc, func(path []string, _, n interface{}, inOld, inNew bool) {
+		op := map[string]interface{}{"path": jsonPointer(path)}
+		switch {
+		case !inOld:
+			op["op"] = "add"
+			op["value"] = n
+		case !inNew:
+			op["op"] = "remove"
+		default:
+			op["op"] = "replace"
+			op["value"] = n
+		}
+		patch = append(patch, op)
+	})
+	return patch
+}
+
+// walkDiff calls fn for every differing leaf of oldDoc and newDoc in the
+// order of their paths, telling whether the leaf exists in each of them.
+// Objects are descended into, other values are leaves.
+func walkDiff(path []string, oldDoc, newDoc map[string]interface{}, fn func(path []string, o, n interface{}, inOld, inNew bool)) {
+	keys := make([]string, 0, len(oldDoc)+len(newDoc))
+	for k := range oldDoc {
+		keys = append(keys, k)
+	}
+	for k := range newDoc {
+		if _, ok := oldDoc[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		o, inOld := oldDoc[k]
+		n, inNew := newDoc[k]
+		p := append(path[:len(path):len(path)], k)
+		oMap, oIsMap := o.(map[string]interface{})
+		nMap, nIsMap := n.(map[string]interface{})
+		if oIsMap && nIsMap {
+			walkDiff(p, oMap, nMap, fn)
+			continue
+		}
+		if inOld != inNew || !reflect.DeepEqual(o, n) {
+			fn(p, o, n, inOld, inNew)
+		}
+	}
+}
+
+var jsonPointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+func jsonPointer(path []string) string {
+	var b strings.Builder
+	for _, p := range path {
+		b.WriteByte('/')
+		b.WriteString(jsonPointerEscaper.Replace(p))
+	}
+	return b.String()
+}
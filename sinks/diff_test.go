@@ -0,0 +1,93 @@
+package sinks
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDiff(t *testing.T) {
+	var oldDoc, newDoc map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"count": 1,
+		"message": "Back-off 10s",
+		"note": null,
+		"metadata": {"name": "pod-1.abc", "labels": {"a/b": "x"}},
+		"series": [1, 2]
+	}`), &oldDoc))
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"count": 2,
+		"message": "Back-off 20s",
+		"metadata": {"name": "pod-1.abc", "labels": {"a/b": "y"}},
+		"series": [1, 2, 3],
+		"reportingInstance": "node-1"
+	}`), &newDoc))
+
+	require.Equal(t, map[string]interface{}{
+		"count":               map[string]interface{}{"old": float64(1), "new": float64(2)},
+		"message":             map[string]interface{}{"old": "Back-off 10s", "new": "Back-off 20s"},
+		"metadata.labels.a/b": map[string]interface{}{"old": "x", "new": "y"},
+		"note":                map[string]interface{}{"old": nil, "new": nil},
+		"reportingInstance":   map[string]interface{}{"old": nil, "new": "node-1"},
+		"series":              map[string]interface{}{"old": []interface{}{float64(1), float64(2)}, "new": []interface{}{float64(1), float64(2), float64(3)}},
+	}, diffChanges(oldDoc, newDoc))
+
+	require.Equal(t, []interface{}{
+		map[string]interface{}{"op": "replace", "path": "/count", "value": float64(2)},
+		map[string]interface{}{"op": "replace", "path": "/message", "value": "Back-off 20s"},
+		map[string]interface{}{"op": "replace", "path": "/metadata/labels/a~1b", "value": "y"},
+		map[string]interface{}{"op": "remove", "path": "/note"},
+		map[string]interface{}{"op": "add", "path": "/reportingInstance", "value": "node-1"},
+		map[string]interface{}{"op": "replace", "path": "/series", "value": []interface{}{float64(1), float64(2), float64(3)}},
+	}, diffPatch(oldDoc, newDoc))
+}
+
+func TestTransform_diff(t *testing.T) {
+	eOld := &v1.Event{ObjectMeta: metav1.ObjectMeta{Name: "pod-1.abc"}, Count: 1, Message: "Back-off 10s"}
+	eNew := &v1.Event{ObjectMeta: metav1.ObjectMeta{Name: "pod-1.abc"}, Count: 2, Message: "Back-off 20s"}
+
+	testCases := []struct {
+		name string
+		cfg  TransformConfig
+		want string
+	}{
+		{
+			"changes",
+			TransformConfig{Diff: "changes", Keep: []string{"verb", "changes", "old_event.count"}},
+			`{"verb":"UPDATED","changes":{"count":{"old":1,"new":2},"message":{"old":"Back-off 10s","new":"Back-off 20s"}}}`,
+		},
+		{
+			"jsonpatch",
+			TransformConfig{Diff: "jsonpatch", Keep: []string{"patch"}},
+			`{"patch":[{"op":"replace","path":"/count","value":2},{"op":"replace","path":"/message","value":"Back-off 20s"}]}`,
+		},
+		{
+			"keep the old event",
+			TransformConfig{Diff: "changes", KeepOldEvent: true, Keep: []string{"changes.count", "old_event.count"}},
+			`{"changes":{"count":{"old":1,"new":2}},"old_event":{"count":1}}`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tr, err := NewTransform(&tc.cfg)
+			require.NoError(t, err)
+			eData := (&eventShaper{transform: tr}).eventData(eNew, eOld)
+			got, err := eData.Marshal()
+			require.NoError(t, err)
+			require.JSONEq(t, tc.want, string(got))
+		})
+	}
+
+	// Added events have nothing to diff
+	tr, err := NewTransform(&TransformConfig{Diff: "changes"})
+	require.NoError(t, err)
+	doc, err := tr.Apply(NewEventData(eNew, nil))
+	require.NoError(t, err)
+	require.NotContains(t, doc, "changes")
+
+	_, err = NewTransform(&TransformConfig{Diff: "full"})
+	require.EqualError(t, err, `transformDiff: invalid value "full", must be one of: changes, jsonpatch`)
+}
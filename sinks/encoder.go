@@ -0,0 +1,446 @@
+/*
+Copyright 2017 The Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sinks
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Encoder serializes EventData in one output format. Sinks writing one
+// message per event use Encode, sinks batching events into a single payload
+// use WriteBatch.
+type Encoder interface {
+	// Encode returns the record of e, without a trailing newline
+	Encode(e *EventData) ([]byte, error)
+
+	// WriteBatch writes the records of events to w as one payload
+	WriteBatch(w io.Writer, events []EventData) error
+
+	// ContentType is the MIME type of the payloads written by WriteBatch
+	ContentType() string
+}
+
+// EncoderFactory creates the Encoder configured by c
+type EncoderFactory func(c *OutputConfig) (Encoder, error)
+
+var encoders = map[string]EncoderFactory{}
+
+// RegisterEncoder makes an output format available to every sink under
+// name. It panics if the name is already taken.
+func RegisterEncoder(name string, f EncoderFactory) {
+	if _, ok := encoders[name]; ok {
+		panic(fmt.Sprintf("sinks: encoder %q registered twice", name))
+	}
+	encoders[name] = f
+}
+
+// Encoders returns the sorted names of the registered output formats
+func Encoders() []string {
+	names := make([]string, 0, len(encoders))
+	for name := range encoders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	RegisterEncoder("json", func(*OutputConfig) (Encoder, error) { return jsonEncoder{}, nil })
+	RegisterEncoder("ndjson", func(*OutputConfig) (Encoder, error) { return ndjsonEncoder{}, nil })
+	RegisterEncoder("flatjson", func(c *OutputConfig) (Encoder, error) {
+		if c.Template != "" {
+			return nil, errors.New("transformTemplate renders text and cannot be used with the flatjson outputFormat")
+		}
+		return flatJSONEncoder{}, nil
+	})
+	RegisterEncoder("rfc5424", func(*OutputConfig) (Encoder, error) { return rfc5424Encoder{}, nil })
+	RegisterEncoder("logfmt", func(*OutputConfig) (Encoder, error) { return logfmtEncoder{}, nil })
+	RegisterEncoder("csv", newCSVEncoder)
+}
+
+// OutputConfig selects how a sink serializes EventData. It is embedded in
+// the configuration of every sink that writes EventData, and embeds the
+// TransformConfig applied before encoding.
+type OutputConfig struct {
+	// Format is the name of a registered encoder, json when empty
+	Format string `mapstructure:"outputFormat"`
+
+	// CSVColumns are the dot separated paths written by the csv format
+	CSVColumns []string `mapstructure:"outputCSVColumns"`
+
+	TransformConfig `mapstructure:",squash"`
+
+	// encoder is the configured encoder, set by Validate
+	encoder Encoder
+}
+
+// Validate implements config.Validator by compiling the transform and
+// creating the encoder
+func (c *OutputConfig) Validate() error {
+	if err := c.TransformConfig.Validate(); err != nil {
+		return err
+	}
+	enc, err := NewEncoder(c)
+	if err != nil {
+		return err
+	}
+	c.encoder = enc
+	return nil
+}
+
+// Encoder returns the configured encoder, nil before Validate
+func (c *OutputConfig) Encoder() Encoder {
+	return c.encoder
+}
+
+// NewEncoder creates the encoder of the output format selected by c
+func NewEncoder(c *OutputConfig) (Encoder, error) {
+	format := c.Format
+	if format == "" {
+		format = "json"
+	}
+	f, ok := encoders[format]
+	if !ok {
+		return nil, fmt.Errorf("outputFormat: invalid value %q, must be one of: %s", format, strings.Join(Encoders(), ", "))
+	}
+	return f(c)
+}
+
+// configure applies the transform and the encoder of c to the sink
+func (s *eventShaper) configure(c *OutputConfig) {
+	s.transform = c.Transform()
+	s.encoder = c.Encoder()
+}
+
+// output returns the encoder of the sink, json when none is set
+func (s *eventShaper) output() Encoder {
+	if s.encoder == nil {
+		return jsonEncoder{}
+	}
+	return s.encoder
+}
+
+// encode returns the record of e in the output format of the sink
+func (s *eventShaper) encode(e *EventData) ([]byte, error) {
+	return s.output().Encode(e)
+}
+
+// recordSizer is implemented by the encoders whose records are a poor
+// estimate of their share of a batch
+type recordSizer interface {
+	recordSize(e *EventData) int
+}
+
+// recordSize estimates the size e adds to a batch of the sink
+func (s *eventShaper) recordSize(e *EventData) (int, error) {
+	if enc, ok := s.output().(recordSizer); ok {
+		return enc.recordSize(e), nil
+	}
+	b, err := s.encode(e)
+	return len(b) + 1, err
+}
+
+// encodeJSONValue is like encode but always returns JSON, records in a text
+// format are encoded as a JSON string and binary records as a base64 JSON
+// string.
+func (s *eventShaper) encodeJSONValue(e *EventData) ([]byte, error) {
+	b, err := s.encode(e)
+	if err != nil {
+		return nil, err
+	}
+	switch s.output().(type) {
+	case schemaEncoder, parquetEncoder:
+		return json.Marshal(b)
+	}
+	if strings.HasSuffix(s.output().ContentType(), "json") && !e.transform.IsText() {
+		return b, nil
+	}
+	return json.Marshal(string(b))
+}
+
+// writeLines writes the record of every event followed by a newline
+func writeLines(w io.Writer, enc Encoder, events []EventData) error {
+	for i := range events {
+		b, err := enc.Encode(&events[i])
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(append(b, '\n')); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// jsonEncoder writes JSON documents, batches are JSON arrays
+type jsonEncoder struct{}
+
+func (jsonEncoder) Encode(e *EventData) ([]byte, error) {
+	return e.Marshal()
+}
+
+func (jsonEncoder) WriteBatch(w io.Writer, events []EventData) error {
+	records := make([]json.RawMessage, 0, len(events))
+	for i := range events {
+		b, err := events[i].marshalJSONValue()
+		if err != nil {
+			return err
+		}
+		records = append(records, b)
+	}
+	b, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+func (jsonEncoder) ContentType() string {
+	return "application/json"
+}
+
+// ndjsonEncoder writes one JSON document per line
+type ndjsonEncoder struct{}
+
+func (ndjsonEncoder) Encode(e *EventData) ([]byte, error) {
+	return e.Marshal()
+}
+
+func (enc ndjsonEncoder) WriteBatch(w io.Writer, events []EventData) error {
+	return writeLines(w, enc, events)
+}
+
+func (ndjsonEncoder) ContentType() string {
+	return "application/x-ndjson"
+}
+
+// flatJSONEncoder writes one flattened JSON object per line, see
+// WriteFlattenedJSON
+type flatJSONEncoder struct{}
+
+func (flatJSONEncoder) Encode(e *EventData) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := e.WriteFlattenedJSON(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (enc flatJSONEncoder) WriteBatch(w io.Writer, events []EventData) error {
+	return writeLines(w, enc, events)
+}
+
+func (flatJSONEncoder) ContentType() string {
+	return "application/x-ndjson"
+}
+
+// rfc5424Encoder writes one RFC5424 syslog frame per line, see WriteRFC5424
+type rfc5424Encoder struct{}
+
+func (rfc5424Encoder) Encode(e *EventData) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := e.WriteRFC5424(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (enc rfc5424Encoder) WriteBatch(w io.Writer, events []EventData) error {
+	return writeLines(w, enc, events)
+}
+
+func (rfc5424Encoder) ContentType() string {
+	return "application/logplex-1"
+}
+
+// logfmtEncoder writes the flattened document as key=value pairs, one record
+// per line. Keys are the dot separated paths of the values, sorted. Rendered
+// text is written as the msg key.
+type logfmtEncoder struct{}
+
+func (logfmtEncoder) Encode(e *EventData) ([]byte, error) {
+	doc, err := e.genericDocument()
+	if err != nil {
+		return nil, err
+	}
+	flat := map[string]interface{}{}
+	if text, ok := doc.(string); ok {
+		flat["msg"] = text
+	} else {
+		flatten("", doc, flat, ".")
+	}
+	keys := make([]string, 0, len(flat))
+	for k := range flat {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	for i, k := range keys {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(k)
+		buf.WriteByte('=')
+		buf.WriteString(logfmtValue(flat[k]))
+	}
+	return buf.Bytes(), nil
+}
+
+func (enc logfmtEncoder) WriteBatch(w io.Writer, events []EventData) error {
+	return writeLines(w, enc, events)
+}
+
+func (logfmtEncoder) ContentType() string {
+	return "text/plain; charset=utf-8"
+}
+
+// logfmtValue formats v, quoting it when it is empty or contains spaces,
+// quotes, equal signs or control characters.
+func logfmtValue(v interface{}) string {
+	s := scalarString(v)
+	if s == "" || strings.ContainsAny(s, " =\"\\") || strings.IndexFunc(s, func(r rune) bool { return r < ' ' }) >= 0 {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// defaultCSVColumns are written by the csv format when outputCSVColumns is
+// not set
+var defaultCSVColumns = []string{
+	"verb",
+	"event.lastTimestamp",
+	"event.type",
+	"event.reason",
+	"event.involvedObject.kind",
+	"event.involvedObject.namespace",
+	"event.involvedObject.name",
+	"event.count",
+	"event.message",
+}
+
+// csvEncoder writes the values at the configured paths as CSV rows. Batches
+// start with a header row of the paths.
+type csvEncoder struct {
+	columns [][]string
+	header  []string
+}
+
+func newCSVEncoder(c *OutputConfig) (Encoder, error) {
+	if c.Template != "" {
+		return nil, errors.New("transformTemplate renders text and cannot be used with the csv outputFormat")
+	}
+	header := c.CSVColumns
+	if len(header) == 0 {
+		header = defaultCSVColumns
+	}
+	enc := &csvEncoder{header: header}
+	for _, col := range header {
+		enc.columns = append(enc.columns, splitPath(col))
+	}
+	return enc, nil
+}
+
+func (enc *csvEncoder) row(e *EventData) ([]string, error) {
+	doc, err := e.genericDocument()
+	if err != nil {
+		return nil, err
+	}
+	row := make([]string, len(enc.columns))
+	for i, path := range enc.columns {
+		if v, ok := getPath(doc, path); ok {
+			row[i] = scalarString(v)
+		}
+	}
+	return row, nil
+}
+
+func (enc *csvEncoder) Encode(e *EventData) ([]byte, error) {
+	row, err := enc.row(e)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.Write(row); err != nil {
+		return nil, err
+	}
+	w.Flush()
+	return bytes.TrimSuffix(buf.Bytes(), []byte{'\n'}), w.Error()
+}
+
+func (enc *csvEncoder) WriteBatch(w io.Writer, events []EventData) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(enc.header); err != nil {
+		return err
+	}
+	for i := range events {
+		row, err := enc.row(&events[i])
+		if err != nil {
+			return err
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func (*csvEncoder) ContentType() string {
+	return "text/csv; charset=utf-8"
+}
+
+// genericDocument returns the document of e decoded into generic JSON
+// values, or the rendered text as a string.
+func (e *EventData) genericDocument() (interface{}, error) {
+	b, err := e.marshalJSONValue()
+	if err != nil {
+		return nil, err
+	}
+	var doc interface{}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("failed to json deserialize event: %w", err)
+	}
+	return doc, nil
+}
+
+// scalarString formats a generic JSON value as text. Objects and lists are
+// written as JSON.
+func scalarString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
@@ -0,0 +1,212 @@
+package sinks
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func encoderTestEvents() []EventData {
+	ts := metav1.NewTime(time.Date(2024, 3, 7, 10, 0, 0, 0, time.UTC))
+	return []EventData{
+		NewEventData(&v1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Name: "web-1.17b", Namespace: "default"},
+			InvolvedObject: v1.ObjectReference{Kind: "Pod", Namespace: "default", Name: "web-1"},
+			Reason:         "BackOff",
+			Message:        `Back-off restarting "web"`,
+			Type:           "Warning",
+			Count:          3,
+			LastTimestamp:  ts,
+		}, nil),
+		NewEventData(&v1.Event{
+			InvolvedObject: v1.ObjectReference{Kind: "Node", Name: "node-1"},
+			Reason:         "Ready",
+			Type:           "Normal",
+			Count:          1,
+			LastTimestamp:  ts,
+		}, nil),
+	}
+}
+
+func TestEncoders(t *testing.T) {
+	testCases := []struct {
+		format      string
+		keep        []string
+		columns     []string
+		wantRecord  string
+		wantBatch   string
+		contentType string
+	}{
+		{
+			format:      "json",
+			keep:        []string{"event.reason", "event.count"},
+			wantRecord:  `{"event":{"count":3,"reason":"BackOff"}}`,
+			wantBatch:   `[{"event":{"count":3,"reason":"BackOff"}},{"event":{"count":1,"reason":"Ready"}}]`,
+			contentType: "application/json",
+		},
+		{
+			format:      "ndjson",
+			keep:        []string{"event.reason"},
+			wantRecord:  `{"event":{"reason":"BackOff"}}`,
+			wantBatch:   "{\"event\":{\"reason\":\"BackOff\"}}\n{\"event\":{\"reason\":\"Ready\"}}\n",
+			contentType: "application/x-ndjson",
+		},
+		{
+			format:      "flatjson",
+			keep:        []string{"event.reason", "event.involvedObject.kind"},
+			wantRecord:  `{"event_involvedObject_kind":"Pod","event_reason":"BackOff"}`,
+			wantBatch:   "{\"event_involvedObject_kind\":\"Pod\",\"event_reason\":\"BackOff\"}\n{\"event_involvedObject_kind\":\"Node\",\"event_reason\":\"Ready\"}\n",
+			contentType: "application/x-ndjson",
+		},
+		{
+			format:      "logfmt",
+			keep:        []string{"verb", "event.message", "event.count"},
+			wantRecord:  `event.count=3 event.message="Back-off restarting \"web\"" verb=ADDED`,
+			wantBatch:   "event.count=3 event.message=\"Back-off restarting \\\"web\\\"\" verb=ADDED\nevent.count=1 verb=ADDED\n",
+			contentType: "text/plain; charset=utf-8",
+		},
+		{
+			format:      "csv",
+			columns:     []string{"event.involvedObject.name", "event.count", "event.message"},
+			wantRecord:  `web-1,3,"Back-off restarting ""web"""`,
+			wantBatch:   "event.involvedObject.name,event.count,event.message\nweb-1,3,\"Back-off restarting \"\"web\"\"\"\nnode-1,1,\n",
+			contentType: "text/csv; charset=utf-8",
+		},
+		{
+			format:      "csv",
+			wantRecord:  `ADDED,2024-03-07T10:00:00Z,Warning,BackOff,Pod,default,web-1,3,"Back-off restarting ""web"""`,
+			wantBatch:   "verb,event.lastTimestamp,event.type,event.reason,event.involvedObject.kind,event.involvedObject.namespace,event.involvedObject.name,event.count,event.message\nADDED,2024-03-07T10:00:00Z,Warning,BackOff,Pod,default,web-1,3,\"Back-off restarting \"\"web\"\"\"\nADDED,2024-03-07T10:00:00Z,Normal,Ready,Node,,node-1,1,\n",
+			contentType: "text/csv; charset=utf-8",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.format, func(t *testing.T) {
+			c := &OutputConfig{Format: tc.format, CSVColumns: tc.columns, TransformConfig: TransformConfig{Keep: tc.keep}}
+			require.NoError(t, c.Validate())
+			s := &eventShaper{}
+			s.configure(c)
+
+			events := encoderTestEvents()
+			for i := range events {
+				events[i] = s.eventData(events[i].Event, nil)
+			}
+			got, err := s.encode(&events[0])
+			require.NoError(t, err)
+			require.Equal(t, tc.wantRecord, string(got))
+
+			var buf bytes.Buffer
+			require.NoError(t, s.output().WriteBatch(&buf, events))
+			require.Equal(t, tc.wantBatch, buf.String())
+			require.Equal(t, tc.contentType, s.output().ContentType())
+		})
+	}
+}
+
+func TestEncoders_rfc5424(t *testing.T) {
+	enc, err := NewEncoder(&OutputConfig{Format: "rfc5424"})
+	require.NoError(t, err)
+	events := encoderTestEvents()
+
+	var buf bytes.Buffer
+	require.NoError(t, enc.WriteBatch(&buf, events))
+	lines := bytes.Split(bytes.TrimSuffix(buf.Bytes(), []byte{'\n'}), []byte{'\n'})
+	require.Len(t, lines, 2)
+
+	got, err := enc.Encode(&events[0])
+	require.NoError(t, err)
+	require.Equal(t, lines[0], got)
+	require.Contains(t, string(got), `"reason":"BackOff"`)
+}
+
+func TestEncoders_text(t *testing.T) {
+	tmpl := TransformConfig{Template: "{{.event.reason}} {{.event.involvedObject.name}}"}
+
+	c := &OutputConfig{Format: "logfmt", TransformConfig: tmpl}
+	require.NoError(t, c.Validate())
+	s := &eventShaper{}
+	s.configure(c)
+	e := s.eventData(encoderTestEvents()[0].Event, nil)
+	got, err := s.encode(&e)
+	require.NoError(t, err)
+	require.Equal(t, `msg="BackOff web-1"`, string(got))
+
+	// Text is quoted for the plugin protocol
+	got, err = s.encodeJSONValue(&e)
+	require.NoError(t, err)
+	require.Equal(t, `"msg=\"BackOff web-1\""`, string(got))
+
+	for _, format := range []string{"flatjson", "csv"} {
+		c = &OutputConfig{Format: format, TransformConfig: tmpl}
+		require.EqualError(t, c.Validate(), "transformTemplate renders text and cannot be used with the "+format+" outputFormat")
+	}
+}
+
+func TestNewEncoder(t *testing.T) {
+	enc, err := NewEncoder(&OutputConfig{})
+	require.NoError(t, err)
+	require.Equal(t, jsonEncoder{}, enc)
+
+	_, err = NewEncoder(&OutputConfig{Format: "xml"})
+	require.EqualError(t, err, `outputFormat: invalid value "xml", must be one of: avro, csv, flatjson, json, logfmt, ndjson, parquet, protobuf, rfc5424`)
+
+	require.Panics(t, func() { RegisterEncoder("json", nil) })
+}
+
+func TestHTTPSink_outputFormat(t *testing.T) {
+	type request struct {
+		contentType string
+		body        string
+	}
+	requests := make(chan request, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		requests <- request{r.Header.Get("Content-Type"), string(b)}
+	}))
+	defer srv.Close()
+
+	c := defaultHTTPSinkConfig()
+	c.Format = "flatjson"
+	c.Keep = []string{"event.reason"}
+	require.NoError(t, c.Validate())
+	h := NewHTTPSink(srv.URL, true, 10)
+	h.configure(&c.OutputConfig)
+
+	events := encoderTestEvents()
+	for i := range events {
+		events[i] = h.eventData(events[i].Event, nil)
+	}
+	h.drainEvents(events)
+	got := <-requests
+	require.Equal(t, "application/x-ndjson", got.contentType)
+	require.Equal(t, "{\"event_reason\":\"BackOff\"}\n{\"event_reason\":\"Ready\"}\n", got.body)
+}
+
+func TestS3Sink_csvHeader(t *testing.T) {
+	s, err := NewS3Sink("accessKeyID", "secretAccessKey", "region", "bucket", "bucketDir", 3600, true, 10, "csv")
+	require.NoError(t, err)
+	uploader := new(MockUploader)
+	s.uploader = uploader
+	var body string
+	uploader.On("Upload", mock.AnythingOfType("*s3manager.UploadInput")).Run(func(args mock.Arguments) {
+		b, _ := io.ReadAll(args.Get(0).(*s3manager.UploadInput).Body)
+		body = string(b)
+	}).Return(&s3manager.UploadOutput{}, nil)
+
+	// Events drained separately share one header in the uploaded file
+	s.lastUploadTimestamp = time.Now().UnixNano()
+	events := encoderTestEvents()
+	s.drainEvents(events[:1])
+	s.drainEvents(events[1:])
+	s.upload()
+	require.Equal(t, 3, bytes.Count([]byte(body), []byte{'\n'}))
+	require.Equal(t, 1, bytes.Count([]byte(body), []byte("verb,")))
+}
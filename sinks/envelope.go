@@ -0,0 +1,193 @@
+/*
+Copyright 2017 The Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sinks
+
+import (
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/kuoss/eventrouter/config"
+	"github.com/spf13/viper"
+	v1 "k8s.io/api/core/v1"
+)
+
+// EnvelopeSchemaVersion is the schema version of the v2 envelope
+const EnvelopeSchemaVersion = "2"
+
+// envelopeConfig holds the output envelope keys applying to every sink
+type envelopeConfig struct {
+	// Version selects the output shape: v1 writes the EventData as is, v2
+	// wraps it in an Envelope
+	Version string `mapstructure:"outputEnvelope" validate:"oneof=v1 v2"`
+
+	// ClusterID identifies the cluster, it is discovered from the UID of the
+	// kube-system namespace when not set
+	ClusterID string `mapstructure:"clusterID"`
+
+	// InstanceID identifies the eventrouter instance, it defaults to the
+	// POD_NAME environment variable or the hostname
+	InstanceID string `mapstructure:"routerInstanceID"`
+}
+
+func defaultEnvelopeConfig() *envelopeConfig {
+	return &envelopeConfig{Version: "v1", InstanceID: podName()}
+}
+
+// podName returns the POD_NAME environment variable or the hostname
+func podName() string {
+	if name := os.Getenv("POD_NAME"); name != "" {
+		return name
+	}
+	name, _ := os.Hostname()
+	return name
+}
+
+// Envelope is the v2 output of the sinks wrapping the EventData, or its
+// transformed form, in Data.
+type Envelope struct {
+	SchemaVersion string      `json:"schemaVersion"`
+	ClusterID     string      `json:"clusterId"`
+	InstanceID    string      `json:"instanceId"`
+	Sequence      uint64      `json:"sequence"`
+	ProcessedAt   time.Time   `json:"processedAt"`
+	Data          interface{} `json:"data"`
+}
+
+// Envelopes numbers the events of one sink. As every sink has its own
+// sequence, the consumers of a sink see a gap only when events were lost.
+type Envelopes struct {
+	clusterID  string
+	instanceID string
+	seq        atomic.Uint64
+	now        func() time.Time
+}
+
+// NewEnvelopes constructs the envelopes of a sink
+func NewEnvelopes(clusterID string, instanceID string) *Envelopes {
+	return &Envelopes{clusterID: clusterID, instanceID: instanceID, now: time.Now}
+}
+
+// wrap returns the generic form of the envelope around data, which is what
+// templates see.
+func (env *Envelope) wrap(data interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"schemaVersion": env.SchemaVersion,
+		"clusterId":     env.ClusterID,
+		"instanceId":    env.InstanceID,
+		"sequence":      env.Sequence,
+		"processedAt":   env.ProcessedAt,
+		"data":          data,
+	}
+}
+
+// next returns the envelope of the next event, without its data
+func (s *Envelopes) next() *Envelope {
+	return &Envelope{
+		SchemaVersion: EnvelopeSchemaVersion,
+		ClusterID:     s.clusterID,
+		InstanceID:    s.instanceID,
+		Sequence:      s.seq.Add(1),
+		ProcessedAt:   s.now().UTC(),
+	}
+}
+
+// loadEnvelopeConfig reads the envelope keys of v
+func loadEnvelopeConfig(v *viper.Viper) (*envelopeConfig, error) {
+	cfg := defaultEnvelopeConfig()
+	if err := config.Decode(v, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// NeedsClusterID reports whether the configuration in v writes the cluster
+// ID, through the v2 envelope, CloudEvents, Kafka headers or S3 keys, without
+// setting clusterID.
+func NeedsClusterID(v *viper.Viper) bool {
+	if v.GetString("clusterID") != "" {
+		return false
+	}
+	if v.GetString("outputEnvelope") == "v2" || writesClusterID(v) {
+		return true
+	}
+	sinks, _ := v.Get("sinks").(map[string]interface{})
+	for name := range sinks {
+		if sub := v.Sub("sinks." + name); sub != nil && writesClusterID(sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// writesClusterID tells whether the sink configured by v writes the cluster
+// ID, in CloudEvents, Kafka record headers or object keys
+func writesClusterID(v *viper.Viper) bool {
+	if cloudEventsEnabled(v) || v.GetBool("kafkaHeaders") {
+		return true
+	}
+	for _, key := range []string{"s3SinkKeyTemplate", "gcsSinkKeyTemplate", "azureBlobSinkKeyTemplate"} {
+		if strings.Contains(v.GetString(key), ".Cluster") {
+			return true
+		}
+	}
+	return false
+}
+
+func cloudEventsEnabled(v *viper.Viper) bool {
+	mode := v.GetString("cloudEventsMode")
+	return mode != "" && mode != "off"
+}
+
+// eventShaper builds the EventData of a sink, with the transform and the
+// envelopes configured for it, and encodes it in the output format of the
+// sink. It is embedded in the sinks writing EventData.
+type eventShaper struct {
+	transform *Transform
+	envelopes *Envelopes
+	encoder   Encoder
+
+	// clusterID identifies the cluster in the CloudEvents source
+	clusterID string
+}
+
+// eventData returns the EventData of an event written by the sink
+func (s *eventShaper) eventData(eNew *v1.Event, eOld *v1.Event) EventData {
+	e := NewEventData(eNew, eOld)
+	e.transform = s.transform
+	if s.envelopes != nil {
+		e.envelope = s.envelopes.next()
+	}
+	return e
+}
+
+// setEnvelopes implements envelopeSetter
+func (s *eventShaper) setEnvelopes(envelopes *Envelopes) {
+	s.envelopes = envelopes
+}
+
+// setClusterID implements envelopeSetter
+func (s *eventShaper) setClusterID(clusterID string) {
+	s.clusterID = clusterID
+}
+
+// envelopeSetter is implemented by the sinks embedding an eventShaper
+type envelopeSetter interface {
+	setEnvelopes(envelopes *Envelopes)
+	setClusterID(clusterID string)
+}
@@ -0,0 +1,73 @@
+package sinks
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestEnvelope_Marshal(t *testing.T) {
+	envelopes := NewEnvelopes("cluster-1", "eventrouter-1")
+	envelopes.now = func() time.Time { return time.Date(2024, 3, 7, 10, 0, 0, 0, time.UTC) }
+	s := &eventShaper{envelopes: envelopes}
+
+	e := s.eventData(&v1.Event{Reason: "BackOff"}, nil)
+	got, err := e.Marshal()
+	require.NoError(t, err)
+
+	var env Envelope
+	require.NoError(t, json.Unmarshal(got, &env))
+	require.Equal(t, "2", env.SchemaVersion)
+	require.Equal(t, "cluster-1", env.ClusterID)
+	require.Equal(t, "eventrouter-1", env.InstanceID)
+	require.Equal(t, uint64(1), env.Sequence)
+	require.Equal(t, "2024-03-07T10:00:00Z", env.ProcessedAt.Format(time.RFC3339))
+	require.Equal(t, "ADDED", env.Data.(map[string]interface{})["verb"])
+
+	e = s.eventData(&v1.Event{Reason: "BackOff"}, nil)
+	require.Equal(t, uint64(2), e.envelope.Sequence)
+
+	// Transforms shape the data and templates see the envelope
+	s.transform, err = NewTransform(&TransformConfig{Keep: []string{"event.reason"}})
+	require.NoError(t, err)
+	e = s.eventData(&v1.Event{Reason: "BackOff"}, nil)
+	got, err = e.Marshal()
+	require.NoError(t, err)
+	require.JSONEq(t, `{"schemaVersion":"2","clusterId":"cluster-1","instanceId":"eventrouter-1","sequence":3,"processedAt":"2024-03-07T10:00:00Z","data":{"event":{"reason":"BackOff"}}}`, string(got))
+
+	var buf bytes.Buffer
+	_, err = e.WriteFlattenedJSON(&buf)
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), `"data_event_reason":"BackOff"`)
+
+	s.transform, err = NewTransform(&TransformConfig{Template: `{{.clusterId}}#{{.sequence}} {{.data.event.reason}}`})
+	require.NoError(t, err)
+	e = s.eventData(&v1.Event{Reason: "BackOff"}, nil)
+	got, err = e.Marshal()
+	require.NoError(t, err)
+	require.Equal(t, "cluster-1#4 BackOff", string(got))
+}
+
+func TestManufactureSink_envelope(t *testing.T) {
+	defer viper.Set("outputEnvelope", nil)
+	defer viper.Set("clusterID", nil)
+	viper.Set("sink", "glog")
+
+	viper.Set("outputEnvelope", "v3")
+	_, err := ManufactureSink()
+	require.EqualError(t, err, `outputEnvelope: invalid value "v3", must be one of: v1, v2`)
+
+	viper.Set("outputEnvelope", "v2")
+	viper.Set("clusterID", "cluster-1")
+	sink, err := ManufactureSink()
+	require.NoError(t, err)
+	glogSink, ok := sink.(*GlogSink)
+	require.True(t, ok, "Expected GlogSink")
+	require.NotNil(t, glogSink.envelopes)
+	require.Equal(t, "cluster-1", glogSink.envelopes.clusterID)
+}
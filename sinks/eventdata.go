@@ -32,6 +32,10 @@ type EventData struct {
 	Verb     string    `json:"verb"`
 	Event    *v1.Event `json:"event"`
 	OldEvent *v1.Event `json:"old_event,omitempty"`
+
+	// transform and envelope shape the serialized form, see Marshal
+	transform *Transform
+	envelope  *Envelope
 }
 
 // NewEventData constructs an EventData struct from an old and new event,
@@ -54,12 +58,52 @@ func NewEventData(eNew *v1.Event, eOld *v1.Event) EventData {
 	return eData
 }
 
+// document returns what a sink writes for e: e itself or its transformed
+// form, wrapped in the v2 envelope when one is set.
+func (e *EventData) document() (interface{}, error) {
+	var data interface{} = EventData{Verb: e.Verb, Event: e.Event, OldEvent: e.OldEvent}
+	if e.transform != nil {
+		doc, err := e.transform.Apply(*e)
+		if err != nil {
+			return nil, err
+		}
+		data = doc
+	}
+	if e.envelope != nil {
+		return e.envelope.wrap(data), nil
+	}
+	return data, nil
+}
+
+// Marshal serializes the event data as JSON, or as text when the transform
+// of the sink which created it renders a template.
+func (e *EventData) Marshal() ([]byte, error) {
+	doc, err := e.document()
+	if err != nil {
+		return nil, err
+	}
+	if e.transform.IsText() {
+		return e.transform.render(doc)
+	}
+	return json.Marshal(doc)
+}
+
+// marshalJSONValue is like Marshal but always returns JSON, rendered text is
+// encoded as a JSON string.
+func (e *EventData) marshalJSONValue() ([]byte, error) {
+	b, err := e.Marshal()
+	if err != nil || !e.transform.IsText() {
+		return b, err
+	}
+	return json.Marshal(string(b))
+}
+
 // WriteRFC5424 writes the current event data to the given io.Writer using
 // RFC5424 (syslog over TCP) syntax.
 func (e *EventData) WriteRFC5424(w io.Writer) (int64, error) {
 	var eJSONBytes []byte
 	var err error
-	if eJSONBytes, err = json.Marshal(e); err != nil {
+	if eJSONBytes, err = e.Marshal(); err != nil {
 		return 0, fmt.Errorf("failed to json serialize event: %v", err)
 	}
 	// Each message should look like an RFC5424 syslog message:
@@ -86,7 +130,7 @@ func (e *EventData) WriteRFC5424(w io.Writer) (int64, error) {
 // 2) Convert the json into snake format
 // Eg: {"event_involved_object_kind":"pod", "event_metadata_namespace":"kube-system"}
 func (e *EventData) WriteFlattenedJSON(w io.Writer) (int64, error) {
-	eJSONBytes, err := json.Marshal(e)
+	eJSONBytes, err := e.marshalJSONValue()
 	if err != nil {
 		return 0, fmt.Errorf("failed to marshal event to JSON: %v", err)
 	}
@@ -2,7 +2,6 @@ package sinks
 
 import (
 	"context"
-	"encoding/json"
 
 	eventhub "github.com/Azure/azure-event-hubs-go/v3"
 	"github.com/eapache/channels"
@@ -10,6 +9,23 @@ import (
 	v1 "k8s.io/api/core/v1"
 )
 
+func init() {
+	Register("eventhub", SinkFactory{
+		NewConfig: func() interface{} { return defaultEventHubSinkConfig() },
+		New: func(name string, cfg interface{}) (EventSinkInterface, error) {
+			c := cfg.(*EventHubSinkConfig)
+			eh, err := NewEventHubSink(c.ConnectionString, c.DiscardMessages, c.BufferSize)
+			if err != nil {
+				return nil, err
+			}
+			eh.name = name
+			eh.configure(&c.OutputConfig)
+			runUntilShutdown(eh.Run)
+			return eh, nil
+		},
+	})
+}
+
 const maxMessageSize = 1046528
 
 // Define an interface that includes only the methods you use from eventhub.Hub.
@@ -21,6 +37,30 @@ type EventHubClient interface {
 type EventHubSink struct {
 	hub     EventHubClient // *eventhub.Hub
 	eventCh channels.Channel
+
+	// name identifies the sink in the health reports and metrics
+	name string
+
+	eventShaper
+}
+
+// EventHubSinkConfig is the configuration of the eventhub sink
+type EventHubSinkConfig struct {
+	ConnectionString string `mapstructure:"eventHubConnectionString" validate:"required"`
+
+	// By default we buffer up to 1500 events, and drop messages if more than
+	// 1500 have come in without getting consumed
+	BufferSize      int  `mapstructure:"eventHubSinkBufferSize"`
+	DiscardMessages bool `mapstructure:"eventHubSinkDiscardMessages"`
+
+	OutputConfig `mapstructure:",squash"`
+}
+
+func defaultEventHubSinkConfig() *EventHubSinkConfig {
+	return &EventHubSinkConfig{
+		BufferSize:      1500,
+		DiscardMessages: true,
+	}
 }
 
 // NewEventHubSink constructs a new EventHubSink given a event hub connection string
@@ -57,7 +97,7 @@ func NewEventHubSink(connString string, overflow bool, bufferSize int) (*EventHu
 		eventCh = channels.NewNativeChannel(channels.BufferCap(bufferSize))
 	}
 
-	return &EventHubSink{hub: hub, eventCh: eventCh}, nil
+	return &EventHubSink{hub: hub, eventCh: eventCh, name: "eventhub"}, nil
 }
 
 // UpdateEvents implements the EventSinkInterface. It really just writes the
@@ -65,13 +105,14 @@ func NewEventHubSink(connString string, overflow bool, bufferSize int) (*EventHu
 // Messages that are buffered beyond the bufferSize specified for this EventHubSink
 // are discarded.
 func (h *EventHubSink) UpdateEvents(eNew *v1.Event, eOld *v1.Event) {
-	h.eventCh.In() <- NewEventData(eNew, eOld)
+	bufferEvent(h.name, h.eventCh, h.eventData(eNew, eOld))
 }
 
 // Run sits in a loop, waiting for data to come in through h.eventCh,
 // and forwarding them to the event hub sink. If multiple events have happened
 // between loop iterations, it puts all of them in one request instead of
-// making a single request per event.
+// making a single request per event. The buffered events are sent before
+// Run returns once stopCh is closed.
 func (h *EventHubSink) Run(stopCh <-chan bool) {
 loop:
 	for {
@@ -102,6 +143,9 @@ loop:
 
 			h.drainEvents(arr)
 		case <-stopCh:
+			if arr := takeBuffered(h.eventCh); len(arr) > 0 {
+				h.drainEvents(arr)
+			}
 			break loop
 		}
 	}
@@ -112,9 +156,9 @@ func (h *EventHubSink) drainEvents(events []EventData) {
 	var messageSize int
 	var evts []*eventhub.Event
 	for _, evt := range events {
-		eJSONBytes, err := json.Marshal(evt)
+		eJSONBytes, err := h.encode(&evt)
 		if err != nil {
-			glog.Warningf("Failed to flatten json: %v", err)
+			glog.Warningf("Failed to serialize event: %v", err)
 			return
 		}
 		glog.V(4).Infof("%s", string(eJSONBytes))
@@ -132,5 +176,8 @@ func (h *EventHubSink) drainEvents(events []EventData) {
 func (h *EventHubSink) sendBatch(evts []*eventhub.Event) {
 	if err := h.hub.SendBatch(context.Background(), eventhub.NewEventBatchIterator(evts...)); err != nil {
 		glog.Errorf("Failed to send batch of %d: %v", len(evts), err)
+		reportFailure(h.name, err)
+		return
 	}
+	reportSuccess(h.name)
 }
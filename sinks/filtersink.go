@@ -0,0 +1,115 @@
+/*
+Copyright 2017 The Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sinks
+
+import (
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/kuoss/eventrouter/filter"
+	"github.com/prometheus/client_golang/prometheus"
+	v1 "k8s.io/api/core/v1"
+)
+
+// FilterErrorsTotal counts the events an expression could not be evaluated
+// against, by where the expression is used: "filter" or "route"
+var FilterErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "eventrouter_filter_errors_total",
+	Help: "Total number of events the filter or a route expression failed to evaluate against",
+}, []string{"expression"})
+
+// filterErrorLogInterval is the minimum interval between two warnings about
+// evaluation errors of the same kind of expression
+const filterErrorLogInterval = time.Minute
+
+// FilterSink forwards to another sink only the events selected by a CEL
+// expression, see package filter for the variables and helpers available.
+// Events the expression fails to evaluate against are forwarded or dropped
+// according to the error policy.
+type FilterSink struct {
+	expr        *filter.Expression
+	dropOnError bool
+	errors      *evalErrors
+	sink        EventSinkInterface
+}
+
+// filterConfig holds the "filter" keys applying to every sink
+type filterConfig struct {
+	Filter string `mapstructure:"filter"`
+	// ErrorPolicy is what happens to the events the filter fails to
+	// evaluate against: pass or drop
+	ErrorPolicy string `mapstructure:"filterErrorPolicy" validate:"oneof=pass drop"`
+}
+
+func defaultFilterConfig() *filterConfig {
+	return &filterConfig{ErrorPolicy: "pass"}
+}
+
+// NewFilterSink constructs a FilterSink in front of sink. errorPolicy is
+// "drop" to drop the events the expression fails to evaluate against, they
+// are forwarded otherwise.
+func NewFilterSink(expr *filter.Expression, errorPolicy string, sink EventSinkInterface) *FilterSink {
+	return &FilterSink{
+		expr:        expr,
+		dropOnError: errorPolicy == "drop",
+		errors:      newEvalErrors("filter"),
+		sink:        sink,
+	}
+}
+
+// UpdateEvents implements the EventSinkInterface
+func (f *FilterSink) UpdateEvents(eNew *v1.Event, eOld *v1.Event) {
+	in := filter.NewInput(NewEventData(eNew, eOld).Verb, eNew, eOld)
+	ok, err := f.expr.Match(in)
+	if err != nil {
+		f.errors.report(eNew, err)
+		ok = !f.dropOnError
+	}
+	if ok {
+		f.sink.UpdateEvents(eNew, eOld)
+	}
+}
+
+// evalErrors counts the evaluation errors of a kind of expression and logs
+// them at most once per filterErrorLogInterval, so that an expression
+// failing on every event does not flood the logs.
+type evalErrors struct {
+	kind       string
+	mu         sync.Mutex
+	lastLog    time.Time
+	suppressed int
+}
+
+func newEvalErrors(kind string) *evalErrors {
+	return &evalErrors{kind: kind}
+}
+
+func (r *evalErrors) report(e *v1.Event, err error) {
+	FilterErrorsTotal.WithLabelValues(r.kind).Inc()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	if now.Sub(r.lastLog) < filterErrorLogInterval {
+		r.suppressed++
+		return
+	}
+	glog.Warningf("Failed to evaluate the %s expression against event %s/%s (%d more errors since the last warning): %v",
+		r.kind, e.Namespace, e.Name, r.suppressed, err)
+	r.lastLog, r.suppressed = now, 0
+}
@@ -0,0 +1,88 @@
+package sinks
+
+import (
+	"testing"
+
+	"github.com/kuoss/eventrouter/filter"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestFilterSink_UpdateEvents(t *testing.T) {
+	expr, err := filter.Compile(`event.type == "Warning" && event.count > 3`)
+	require.NoError(t, err)
+	inner := &testSink{}
+	sink := NewFilterSink(expr, "pass", inner)
+
+	warning := &v1.Event{Type: "Warning", Count: 4}
+	sink.UpdateEvents(warning, nil)
+	sink.UpdateEvents(&v1.Event{Type: "Warning", Count: 1}, nil)
+	sink.UpdateEvents(&v1.Event{Type: "Normal", Count: 9}, nil)
+	require.Equal(t, []*v1.Event{warning}, inner.events)
+}
+
+func TestFilterSink_negatedWithoutCount(t *testing.T) {
+	expr, err := filter.Compile(`!(event.reason == "BackOff" && event.count > 3)`)
+	require.NoError(t, err)
+	inner := &testSink{}
+	sink := NewFilterSink(expr, "drop", inner)
+
+	noCount := &v1.Event{Reason: "BackOff"}
+	sink.UpdateEvents(noCount, nil)
+	sink.UpdateEvents(&v1.Event{Reason: "BackOff", Count: 4}, nil)
+	require.Equal(t, []*v1.Event{noCount}, inner.events)
+}
+
+func TestFilterSink_errorPolicy(t *testing.T) {
+	expr, err := filter.Compile(`event.metadata.labels["app"] == "web"`)
+	require.NoError(t, err)
+	unlabeled := &v1.Event{}
+
+	for policy, want := range map[string][]*v1.Event{"pass": {unlabeled}, "drop": nil} {
+		t.Run(policy, func(t *testing.T) {
+			inner := &testSink{}
+			before := testutil.ToFloat64(FilterErrorsTotal.WithLabelValues("filter"))
+			NewFilterSink(expr, policy, inner).UpdateEvents(unlabeled, nil)
+			require.Equal(t, want, inner.events)
+			require.Equal(t, before+1, testutil.ToFloat64(FilterErrorsTotal.WithLabelValues("filter")))
+		})
+	}
+}
+
+func TestManufactureSink_filter(t *testing.T) {
+	defer viper.Set("filter", "")
+	viper.Set("sink", "glog")
+
+	viper.Set("filter", `event.type`)
+	_, err := ManufactureSink()
+	require.EqualError(t, err, `filter: invalid expression "event.type": must evaluate to bool, not string`)
+
+	viper.Set("filter", `event.type == "Warning"`)
+	sink, err := ManufactureSink()
+	require.NoError(t, err)
+	f, ok := sink.(*FilterSink)
+	require.True(t, ok, "Expected FilterSink")
+	require.Equal(t, &GlogSink{eventShaper{encoder: jsonEncoder{}}}, f.sink)
+	require.False(t, f.dropOnError)
+
+	defer viper.Set("filterErrorPolicy", "pass")
+	viper.Set("filterErrorPolicy", "drop")
+	sink, err = ManufactureSink()
+	require.NoError(t, err)
+	require.True(t, sink.(*FilterSink).dropOnError)
+
+	viper.Set("filterErrorPolicy", "ignore")
+	_, err = ManufactureSink()
+	require.ErrorContains(t, err, "filterErrorPolicy")
+}
+
+func TestValidateConfig_filter(t *testing.T) {
+	v := viper.New()
+	v.Set("filter", `event.count >`)
+	v.Set("sink", "http")
+	err := ValidateConfig(v)
+	require.ErrorContains(t, err, `filter: invalid expression "event.count >": ERROR: <input>:1:14: Syntax error`)
+	require.ErrorContains(t, err, "sink http: httpSinkUrl: required but not set")
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2017 The Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sinks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/option"
+)
+
+func init() {
+	Register("gcs", SinkFactory{
+		NewConfig: func() interface{} { return defaultGCSSinkConfig() },
+		New: func(name string, cfg interface{}) (EventSinkInterface, error) {
+			c := cfg.(*GCSSinkConfig)
+			s, err := newGCSSink(name, c)
+			if err != nil {
+				return nil, err
+			}
+			s.configure(&c.OutputConfig)
+			s.start()
+			return s, nil
+		},
+	})
+}
+
+// GCSSink is the sink that uploads the kubernetes events to files in a
+// Google Cloud Storage bucket, batched like the files of the S3Sink
+type GCSSink struct {
+	// bucket is the bucket where the events data would be stored
+	bucket *storage.BucketHandle
+
+	archive
+}
+
+// GCSSinkConfig is the configuration of the gcs sink
+type GCSSinkConfig struct {
+	Bucket    string `mapstructure:"gcsSinkBucket" validate:"required"`
+	BucketDir string `mapstructure:"gcsSinkBucketDir" validate:"required"`
+
+	// CredentialsFile is a service account key or another credentials file.
+	// When it is not set the Application Default Credentials are used, such as
+	// GKE Workload Identity.
+	CredentialsFile string `mapstructure:"gcsSinkCredentialsFile"`
+
+	// Endpoint is the URL of the JSON API, such as the one of
+	// fake-gcs-server, which usually needs WithoutAuthentication.
+	// STORAGE_EMULATOR_HOST is honoured as well.
+	Endpoint              string `mapstructure:"gcsSinkEndpoint"`
+	WithoutAuthentication bool   `mapstructure:"gcsSinkWithoutAuthentication"`
+
+	// The keys below behave like their s3Sink counterpart
+	BufferSize      int    `mapstructure:"gcsSinkBufferSize"`
+	DiscardMessages bool   `mapstructure:"gcsSinkDiscardMessages"`
+	UploadInterval  int    `mapstructure:"gcsSinkUploadInterval"`
+	UploadBytes     int    `mapstructure:"gcsSinkUploadBytes"`
+	RetainBytes     int    `mapstructure:"gcsSinkRetainBytes"`
+	Compression     string `mapstructure:"gcsSinkCompression" validate:"oneof=none gzip zstd"`
+	KeyTemplate     string `mapstructure:"gcsSinkKeyTemplate"`
+	SplitBatch      bool   `mapstructure:"gcsSinkSplitBatch"`
+
+	// options are the archive options set by Validate
+	options archiveOptions
+
+	OutputConfig `mapstructure:",squash"`
+}
+
+// Validate implements config.Validator
+func (c *GCSSinkConfig) Validate() error {
+	c.options = archiveOptions{
+		prefix:          "gcsSink",
+		dir:             c.BucketDir,
+		bufferSize:      c.BufferSize,
+		discardMessages: c.DiscardMessages,
+		uploadInterval:  c.UploadInterval,
+		uploadBytes:     c.UploadBytes,
+		retainBytes:     c.RetainBytes,
+		compression:     c.Compression,
+		keyTemplate:     c.KeyTemplate,
+		splitBatch:      c.SplitBatch,
+	}
+	errs := c.options.validate(&c.OutputConfig)
+	if c.Endpoint != "" {
+		if u, err := url.Parse(c.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("gcsSinkEndpoint: invalid URL %q", c.Endpoint))
+		}
+	}
+	if c.WithoutAuthentication && c.CredentialsFile != "" {
+		errs = append(errs, errors.New("gcsSinkWithoutAuthentication cannot be used with gcsSinkCredentialsFile"))
+	}
+	return errors.Join(errs...)
+}
+
+func defaultGCSSinkConfig() *GCSSinkConfig {
+	return &GCSSinkConfig{
+		BufferSize:      1500,
+		DiscardMessages: true,
+		UploadInterval:  120,
+		RetainBytes:     defaultRetainBytes,
+		Compression:     "none",
+		KeyTemplate:     defaultArchiveKeyTemplate,
+		OutputConfig:    OutputConfig{Format: "ndjson"},
+	}
+}
+
+// newGCSSink returns the sink named name of c, which has been validated
+func newGCSSink(name string, c *GCSSinkConfig) (*GCSSink, error) {
+	var opts []option.ClientOption
+	if c.CredentialsFile != "" {
+		opts = append(opts, option.WithCredentialsFile(c.CredentialsFile))
+	}
+	if c.Endpoint != "" {
+		opts = append(opts, option.WithEndpoint(c.Endpoint))
+	}
+	if c.WithoutAuthentication {
+		opts = append(opts, option.WithoutAuthentication())
+	}
+	client, err := storage.NewClient(context.Background(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the GCS client: %w", err)
+	}
+
+	s := &GCSSink{bucket: client.Bucket(c.Bucket)}
+	s.archive = newArchive(name, s, &c.options)
+	return s, nil
+}
+
+// put implements objectStore, the files are written in a single request
+func (s *GCSSink) put(key string, body []byte, contentType string, contentEncoding string) error {
+	w := s.bucket.Object(key).NewWriter(context.Background())
+	w.ChunkSize = 0
+	w.ContentType = contentType
+	w.ContentEncoding = contentEncoding
+	if _, err := w.Write(body); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
@@ -0,0 +1,119 @@
+package sinks
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// gcsObject is an object kept by fakeGCS
+type gcsObject struct {
+	contentType, contentEncoding string
+	body                         string
+}
+
+// fakeGCS is an in-process JSON API keeping the objects of multipart
+// uploads by bucket and name, like fake-gcs-server
+type fakeGCS struct {
+	mu      sync.Mutex
+	objects map[string]gcsObject
+	fail    bool
+}
+
+func (f *fakeGCS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, ok := strings.CutPrefix(r.URL.Path, "/upload/storage/v1/b/")
+	if r.Method != http.MethodPost || !ok || r.URL.Query().Get("uploadType") != "multipart" {
+		http.Error(w, "not implemented", http.StatusNotImplemented)
+		return
+	}
+	bucket = strings.TrimSuffix(bucket, "/o")
+	if f.fail {
+		http.Error(w, "unavailable", http.StatusForbidden)
+		return
+	}
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	mr := multipart.NewReader(r.Body, params["boundary"])
+	var attrs struct {
+		Name            string `json:"name"`
+		ContentType     string `json:"contentType"`
+		ContentEncoding string `json:"contentEncoding"`
+	}
+	part, err := mr.NextPart()
+	if err == nil {
+		err = json.NewDecoder(part).Decode(&attrs)
+	}
+	if err == nil {
+		part, err = mr.NextPart()
+	}
+	var body []byte
+	if err == nil {
+		body, err = io.ReadAll(part)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.objects == nil {
+		f.objects = map[string]gcsObject{}
+	}
+	f.objects[bucket+"/"+attrs.Name] = gcsObject{contentType: attrs.ContentType, contentEncoding: attrs.ContentEncoding, body: string(body)}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"bucket": bucket, "name": attrs.Name, "size": strconv.Itoa(len(body))})
+}
+
+func TestGCSSink(t *testing.T) {
+	f := &fakeGCS{}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	c := defaultGCSSinkConfig()
+	c.Bucket, c.BucketDir = "events", "archive"
+	c.Endpoint, c.WithoutAuthentication = srv.URL+"/storage/v1/", true
+	c.Compression = "gzip"
+	require.NoError(t, c.Validate())
+	s, err := newGCSSink("gcs", c)
+	require.NoError(t, err)
+	s.configure(&c.OutputConfig)
+	s.hostname = "eventrouter-0"
+
+	f.fail = true
+	last := metav1.NewTime(time.Date(2024, 3, 5, 10, 0, 0, 0, time.UTC))
+	s.drainEvents([]EventData{NewEventData(&v1.Event{Message: "archived", LastTimestamp: last}, nil)})
+	require.Len(t, s.retained, 1)
+	require.Empty(t, f.objects)
+
+	f.fail = false
+	s.flush()
+	require.Empty(t, s.retained)
+	require.Len(t, f.objects, 1)
+	for name, o := range f.objects {
+		require.True(t, strings.HasPrefix(name, "events/archive/2024/03/05/eventrouter-0-"), name)
+		require.True(t, strings.HasSuffix(name, ".ndjson.gz"), name)
+		require.Equal(t, "application/x-ndjson", o.contentType)
+		require.Equal(t, "gzip", o.contentEncoding)
+		zr, err := gzip.NewReader(strings.NewReader(o.body))
+		require.NoError(t, err)
+		b, err := io.ReadAll(zr)
+		require.NoError(t, err)
+		require.Contains(t, string(b), `"message":"archived"`)
+	}
+}
@@ -17,16 +17,31 @@ limitations under the License.
 package sinks
 
 import (
-	"encoding/json"
-
 	"github.com/golang/glog"
 	v1 "k8s.io/api/core/v1"
 )
 
+func init() {
+	Register("glog", SinkFactory{
+		NewConfig: func() interface{} { return &GlogSinkConfig{} },
+		New: func(_ string, cfg interface{}) (EventSinkInterface, error) {
+			gs := &GlogSink{}
+			gs.configure(&cfg.(*GlogSinkConfig).OutputConfig)
+			return gs, nil
+		},
+	})
+}
+
 // GlogSink is the most basic sink
 // Useful when you already have ELK/EFK Stack
 type GlogSink struct {
 	// TODO: create a channel and buffer for scaling
+	eventShaper
+}
+
+// GlogSinkConfig is the configuration of the glog sink
+type GlogSinkConfig struct {
+	OutputConfig `mapstructure:",squash"`
 }
 
 // NewGlogSink will create a new
@@ -36,11 +51,11 @@ func NewGlogSink() EventSinkInterface {
 
 // UpdateEvents implements the EventSinkInterface
 func (gs *GlogSink) UpdateEvents(eNew *v1.Event, eOld *v1.Event) {
-	eData := NewEventData(eNew, eOld)
+	eData := gs.eventData(eNew, eOld)
 
-	if eJSONBytes, err := json.Marshal(eData); err == nil {
-		glog.Info(string(eJSONBytes))
+	if b, err := gs.encode(&eData); err == nil {
+		glog.Info(string(b))
 	} else {
-		glog.Warningf("Failed to json serialize event: %v", err)
+		glog.Warningf("Failed to serialize event: %v", err)
 	}
 }
@@ -0,0 +1,118 @@
+/*
+Copyright 2017 The Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sinks
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/eapache/channels"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// HealthReporter is told about the delivery outcomes of the sinks, so that
+// problems can be surfaced beyond the logs. Sinks are identified by their
+// instance name under "sinks", or by their type name for the single "sink".
+type HealthReporter interface {
+	// SinkFailed is called every time a sink fails to deliver events
+	SinkFailed(sink string, err error)
+
+	// SinkSucceeded is called every time a sink delivers events
+	SinkSucceeded(sink string)
+
+	// BufferFull is called when an event arrives while the buffer of a sink
+	// is full, so it is dropped or delayed
+	BufferFull(sink string)
+}
+
+var (
+	healthMu       sync.RWMutex
+	healthReporter HealthReporter
+
+	// droppedEvents counts the events discarded by full buffers since the
+	// last call to TakeDroppedEvents
+	droppedEvents atomic.Uint64
+)
+
+// EventsDroppedTotal counts the events discarded by full sink buffers
+var EventsDroppedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "eventrouter_events_dropped_total",
+	Help: "Total number of events discarded because the buffer of a sink was full",
+}, []string{"sink"})
+
+// TakeDroppedEvents returns the number of events discarded by full buffers
+// since its previous call.
+func TakeDroppedEvents() uint64 {
+	return droppedEvents.Swap(0)
+}
+
+// SetHealthReporter installs r to receive the delivery outcomes of every
+// sink, nil disables reporting.
+func SetHealthReporter(r HealthReporter) {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+	healthReporter = r
+}
+
+func currentHealthReporter() HealthReporter {
+	healthMu.RLock()
+	defer healthMu.RUnlock()
+	return healthReporter
+}
+
+func reportFailure(sink string, err error) {
+	if r := currentHealthReporter(); r != nil {
+		r.SinkFailed(sink, err)
+	}
+}
+
+func reportSuccess(sink string) {
+	if r := currentHealthReporter(); r != nil {
+		r.SinkSucceeded(sink)
+	}
+}
+
+// discardEvents counts n events a sink could not hold and dropped
+func discardEvents(sink string, n int) {
+	droppedEvents.Add(uint64(n))
+	EventsDroppedTotal.WithLabelValues(sink).Add(float64(n))
+}
+
+// bufferEvent writes evt to the buffer of a sink, reporting when it is full.
+// An OverflowingChannel then discards evt, other channels block.
+func bufferEvent(sink string, ch channels.Channel, evt EventData) {
+	if c := ch.Cap(); c > 0 && ch.Len() >= int(c) {
+		if _, ok := ch.(*channels.OverflowingChannel); ok {
+			discardEvents(sink, 1)
+		}
+		if r := currentHealthReporter(); r != nil {
+			r.BufferFull(sink)
+		}
+	}
+	ch.In() <- evt
+}
+
+// takeBuffered returns the events buffered in ch without waiting for more
+func takeBuffered(ch channels.Channel) []EventData {
+	var events []EventData
+	for n := ch.Len(); n > 0; n-- {
+		if evt, ok := (<-ch.Out()).(EventData); ok {
+			events = append(events, evt)
+		}
+	}
+	return events
+}
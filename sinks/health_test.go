@@ -0,0 +1,115 @@
+package sinks
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eapache/channels"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+)
+
+type testHealthReporter struct {
+	mu         sync.Mutex
+	failures   []string
+	successes  []string
+	bufferFull []string
+}
+
+func (r *testHealthReporter) SinkFailed(sink string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failures = append(r.failures, sink+": "+err.Error())
+}
+
+func (r *testHealthReporter) SinkSucceeded(sink string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.successes = append(r.successes, sink)
+}
+
+func (r *testHealthReporter) BufferFull(sink string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.bufferFull = append(r.bufferFull, sink)
+}
+
+func TestBufferEvent(t *testing.T) {
+	r := &testHealthReporter{}
+	SetHealthReporter(r)
+	defer SetHealthReporter(nil)
+
+	ch := channels.NewOverflowingChannel(channels.BufferCap(2))
+	bufferEvent("test", ch, EventData{})
+	bufferEvent("test", ch, EventData{})
+	require.Eventually(t, func() bool { return ch.Len() == 2 }, time.Second, time.Millisecond)
+	require.Empty(t, r.bufferFull)
+	TakeDroppedEvents()
+	bufferEvent("test", ch, EventData{})
+	require.Equal(t, []string{"test"}, r.bufferFull)
+	require.Equal(t, uint64(1), TakeDroppedEvents())
+	require.Equal(t, uint64(0), TakeDroppedEvents())
+
+	// Unbuffered and infinite channels are never full
+	bufferEvent("test", channels.NewInfiniteChannel(), EventData{})
+	require.Len(t, r.bufferFull, 1)
+}
+
+func TestHTTPSink_reportsHealth(t *testing.T) {
+	r := &testHealthReporter{}
+	SetHealthReporter(r)
+	defer SetHealthReporter(nil)
+
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	h := NewHTTPSink(srv.URL, true, 10)
+	h.drainEvents([]EventData{newTestEventData()})
+	status = http.StatusBadRequest
+	h.drainEvents([]EventData{newTestEventData()})
+
+	require.Equal(t, []string{"http"}, r.successes)
+	require.Equal(t, []string{"http: got HTTP code 400 from " + srv.URL}, r.failures)
+}
+
+func TestRouterSink_reportsHealthByInstance(t *testing.T) {
+	r := &testHealthReporter{}
+	SetHealthReporter(r)
+	defer SetHealthReporter(nil)
+
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	defer ok.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer failing.Close()
+
+	v := viper.New()
+	v.SetConfigType("json")
+	require.NoError(t, v.ReadConfig(bytes.NewBufferString(`{
+		"sinks": {
+			"alerting": {"sink": "http", "httpSinkUrl": "`+ok.URL+`"},
+			"audit": {"sink": "http", "httpSinkUrl": "`+failing.URL+`"}
+		},
+		"routes": [{"sinks": ["alerting", "audit"]}]
+	}`)))
+	sink, err := manufactureRouter(v, nil)
+	require.NoError(t, err)
+
+	sink.UpdateEvents(&v1.Event{Message: "routed"}, nil)
+	require.Eventually(t, func() bool {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return len(r.successes) == 1 && len(r.failures) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, []string{"alerting"}, r.successes)
+	require.Equal(t, []string{"audit: got HTTP code 400 from " + failing.URL}, r.failures)
+}
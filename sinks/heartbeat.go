@@ -0,0 +1,63 @@
+/*
+Copyright 2017 The Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sinks
+
+import (
+	v1 "k8s.io/api/core/v1"
+)
+
+// HeartbeatReason is the reason of the heartbeat records sent by eventrouter
+const HeartbeatReason = "Heartbeat"
+
+// heartbeatForwarder is implemented by the sinks wrapping other sinks. They
+// pass heartbeats to every wrapped sink, regardless of their filters and
+// routes, so consumers can rely on receiving them.
+type heartbeatForwarder interface {
+	Heartbeat(e *v1.Event)
+}
+
+// SendHeartbeat writes the heartbeat record e to sink and, through the
+// filtering and routing sinks, to every sink behind it.
+func SendHeartbeat(sink EventSinkInterface, e *v1.Event) {
+	if f, ok := sink.(heartbeatForwarder); ok {
+		f.Heartbeat(e)
+		return
+	}
+	sink.UpdateEvents(e, nil)
+}
+
+// Heartbeat implements heartbeatForwarder
+func (f *FilterSink) Heartbeat(e *v1.Event) {
+	SendHeartbeat(f.sink, e)
+}
+
+// Heartbeat implements heartbeatForwarder
+func (r *RedactSink) Heartbeat(e *v1.Event) {
+	SendHeartbeat(r.sink, e)
+}
+
+// Heartbeat implements heartbeatForwarder
+func (s *SelfSink) Heartbeat(e *v1.Event) {
+	SendHeartbeat(s.sink, e)
+}
+
+// Heartbeat implements heartbeatForwarder
+func (r *RouterSink) Heartbeat(e *v1.Event) {
+	for _, s := range r.sinks {
+		SendHeartbeat(s, e)
+	}
+}
@@ -0,0 +1,39 @@
+package sinks
+
+import (
+	"testing"
+
+	"github.com/kuoss/eventrouter/filter"
+	"github.com/kuoss/eventrouter/redact"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestSendHeartbeat(t *testing.T) {
+	alerting, archive := &testSink{}, &testSink{}
+	router, err := NewRouterSink(map[string]EventSinkInterface{"alerting": alerting, "archive": archive}, []Route{
+		{Match: RouteMatch{Types: []string{"Warning"}}, Sinks: []string{"alerting"}},
+	})
+	require.NoError(t, err)
+	expr, err := filter.Compile(`event.type == "Warning"`)
+	require.NoError(t, err)
+	self, err := NewSelfSink("drop", "eventrouter-1", "kube-system", nil, NewFilterSink(expr, "pass", router))
+	require.NoError(t, err)
+	redactor, err := redact.New(redact.Detectors(), nil, "")
+	require.NoError(t, err)
+	sink := NewRedactSink(redactor, self)
+
+	// Neither the filter, the routes nor the self event policy stop heartbeats
+	hb := &v1.Event{
+		Type:           "Normal",
+		Reason:         HeartbeatReason,
+		InvolvedObject: v1.ObjectReference{Kind: "Pod", Namespace: "kube-system", Name: "eventrouter-1"},
+	}
+	SendHeartbeat(sink, hb)
+	require.Equal(t, []*v1.Event{hb}, alerting.events)
+	require.Equal(t, []*v1.Event{hb}, archive.events)
+
+	plain := &testSink{}
+	SendHeartbeat(plain, hb)
+	require.Equal(t, []*v1.Event{hb}, plain.events)
+}
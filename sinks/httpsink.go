@@ -18,6 +18,7 @@ package sinks
 
 import (
 	"bytes"
+	"fmt"
 	"net/http"
 
 	"github.com/eapache/channels"
@@ -43,21 +44,82 @@ can send them, if not, a single HTTP request is made for each event.
 
 But with the payload of the messages being a serialized JSON object
 containing the kubernetes v1.Event.
+
+The RFC5424 framing is the default, outputFormat selects any other encoder.
+With cloudEventsMode set, every event is sent in its own request as a
+CloudEvent instead, in the structured or the binary HTTP content mode.
 */
 
+func init() {
+	Register("http", SinkFactory{
+		NewConfig: func() interface{} { return defaultHTTPSinkConfig() },
+		New: func(name string, cfg interface{}) (EventSinkInterface, error) {
+			c := cfg.(*HTTPSinkConfig)
+			h := NewHTTPSink(c.URL, c.DiscardMessages, c.BufferSize)
+			h.name = name
+			h.configure(&c.OutputConfig)
+			h.cloudEvents = c.CloudEvents()
+			runUntilShutdown(h.Run)
+			return h, nil
+		},
+	})
+}
+
 // HTTPSink wraps an HTTP endpoint that messages should be sent to.
 type HTTPSink struct {
 	SinkURL string
 
+	// name identifies the sink in the health reports and metrics
+	name string
+
 	eventCh    channels.Channel
 	httpClient *resty.Client
 	bodyBuf    *bytes.Buffer
+
+	// cloudEvents, when set, sends each event as a CloudEvent
+	cloudEvents *CloudEvents
+	eventShaper
+}
+
+// HTTPSinkConfig is the configuration of the http sink
+type HTTPSinkConfig struct {
+	URL string `mapstructure:"httpSinkUrl" validate:"required"`
+
+	// By default we buffer up to 1500 events, and drop messages if more than
+	// 1500 have come in without getting consumed
+	BufferSize      int  `mapstructure:"httpSinkBufferSize"`
+	DiscardMessages bool `mapstructure:"httpSinkDiscardMessages"`
+
+	CloudEventsConfig `mapstructure:",squash"`
+	OutputConfig      `mapstructure:",squash"`
+}
+
+func defaultHTTPSinkConfig() *HTTPSinkConfig {
+	return &HTTPSinkConfig{
+		BufferSize:        1500,
+		DiscardMessages:   true,
+		CloudEventsConfig: defaultCloudEventsConfig(),
+	}
+}
+
+// Validate implements config.Validator. The output format defaults to
+// rfc5424, or to json for the data of CloudEvents.
+func (c *HTTPSinkConfig) Validate() error {
+	if c.Format == "" {
+		c.Format = "rfc5424"
+		if c.CloudEvents() != nil {
+			c.Format = "json"
+		}
+	}
+	return c.OutputConfig.Validate()
 }
 
 // NewHTTPSink constructs a new HTTPSink given a sink URL and buffer size
 func NewHTTPSink(sinkURL string, overflow bool, bufferSize int) *HTTPSink {
 	h := &HTTPSink{
-		SinkURL: sinkURL,
+		SinkURL:     sinkURL,
+		name:        "http",
+		eventShaper: eventShaper{encoder: rfc5424Encoder{}},
 	}
 
 	if overflow {
@@ -85,13 +147,14 @@ func NewHTTPSink(sinkURL string, overflow bool, bufferSize int) *HTTPSink {
 // Messages that are buffered beyond the bufferSize specified for this HTTPSink
 // are discarded.
 func (h *HTTPSink) UpdateEvents(eNew *v1.Event, eOld *v1.Event) {
-	h.eventCh.In() <- NewEventData(eNew, eOld)
+	bufferEvent(h.name, h.eventCh, h.eventData(eNew, eOld))
 }
 
 // Run sits in a loop, waiting for data to come in through h.eventCh,
 // and forwarding them to the HTTP sink. If multiple events have happened
 // between loop iterations, it puts all of them in one request instead of
-// making a single request per event.
+// making a single request per event. The buffered events are sent before
+// Run returns once stopCh is closed.
 func (h *HTTPSink) Run(stopCh <-chan bool) {
 loop:
 	for {
@@ -121,6 +184,9 @@ loop:
 
 			h.drainEvents(arr)
 		case <-stopCh:
+			if arr := takeBuffered(h.eventCh); len(arr) > 0 {
+				h.drainEvents(arr)
+			}
 			break loop
 		}
 	}
@@ -130,31 +196,61 @@ loop:
 // server. This function is *NOT* re-entrant: it re-uses the same body buffer
 // for each call, truncating it each time to avoid extra memory allocations.
 func (h *HTTPSink) drainEvents(events []EventData) {
+	if h.cloudEvents != nil {
+		for i := range events {
+			h.sendCloudEvent(&events[i])
+		}
+		return
+	}
+
 	// Reuse the body buffer for each request
 	h.bodyBuf.Truncate(0)
 
-	var written int64
-	for _, evt := range events {
-		w, err := evt.WriteRFC5424(h.bodyBuf)
-		written += w
-		if err != nil {
-			glog.Warningf("Could not write to event request body (wrote %v bytes): %v", written, err)
-			return
-		}
+	enc := h.output()
+	if err := enc.WriteBatch(h.bodyBuf, events); err != nil {
+		glog.Warningf("Could not write to event request body (wrote %v bytes): %v", h.bodyBuf.Len(), err)
+		return
+	}
+	h.post(map[string]string{"Content-Type": enc.ContentType()}, h.bodyBuf.String())
+}
 
-		h.bodyBuf.Write([]byte{'\n'})
-		written++
+// sendCloudEvent posts e as a CloudEvent in the configured content mode
+func (h *HTTPSink) sendCloudEvent(e *EventData) {
+	ce, err := h.cloudEvents.event(&h.eventShaper, e)
+	if err != nil {
+		glog.Warningf("Could not build CloudEvent: %v", err)
+		return
+	}
+	if h.cloudEvents.binary {
+		headers := ce.headers("ce-")
+		headers["Content-Type"] = ce.DataContentType
+		h.post(headers, string(ce.payload))
+		return
+	}
+	body, err := ce.structured()
+	if err != nil {
+		glog.Warningf("Could not serialize CloudEvent: %v", err)
+		return
 	}
+	h.post(map[string]string{"Content-Type": cloudEventsContentType}, string(body))
+}
 
+// post sends body with headers to the sink URL and reports the outcome
+func (h *HTTPSink) post(headers map[string]string, body string) {
 	resp, err := h.httpClient.R().
-		SetBody(h.bodyBuf.String()).
+		SetHeaders(headers).
+		SetBody(body).
 		Post(h.SinkURL)
 	if err != nil {
 		glog.Warningf(err.Error())
+		reportFailure(h.name, err)
 		return
 	}
 
 	if resp.StatusCode() < 200 || resp.StatusCode() > 299 {
 		glog.Warningf("Got HTTP code %v from %v", resp.StatusCode(), h.SinkURL)
+		reportFailure(h.name, fmt.Errorf("got HTTP code %v from %v", resp.StatusCode(), h.SinkURL))
+		return
 	}
+	reportSuccess(h.name)
 }
@@ -17,19 +17,33 @@ limitations under the License.
 package sinks
 
 import (
-	"context"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/fnv"
+	"net/http"
 	"strings"
-	"sync"
+	"time"
 
 	"github.com/golang/glog"
 	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api"
 	"github.com/influxdata/influxdb-client-go/v2/api/write"
 	v1 "k8s.io/api/core/v1"
 )
 
+func init() {
+	Register("influxdb", SinkFactory{
+		NewConfig: func() interface{} { return defaultInfluxdbConfig() },
+		New: func(name string, cfg interface{}) (EventSinkInterface, error) {
+			sink := newInfluxdbSink(name, *cfg.(*InfluxdbConfig))
+			onShutdown(sink.close)
+			return sink, nil
+		},
+	})
+}
+
 var (
 	LabelPodId = LabelDescriptor{
 		Key:         "pod_id",
@@ -54,12 +68,13 @@ var (
 
 const (
 	eventMeasurementName = "k8s_events"
+	// Measurement of the counter metrics, and its field
+	eventCountMeasurementName = "k8s_event_count"
+	countField                = "count"
 	// Event special tags
 	eventUID = "uid"
 	// Value Field name
 	valueField = "value"
-	// Event special tags
-	dbNotFoundError = "database not found"
 )
 
 type LabelDescriptor struct {
@@ -75,50 +90,189 @@ type InfluxDBSinkInterface interface {
 	sendData(points []*write.Point)
 }
 
+// InfluxDBSink writes the events as points through the non-blocking write
+// API of the client, which batches them in the background and keeps the
+// failed batches to retry them.
 type InfluxDBSink struct {
 	config InfluxdbConfig
 	client influxdb2.Client
-	sync.RWMutex
-	dbExists bool
+
+	// name identifies the sink in the health reports and metrics
+	name string
+
+	// writers send their batches concurrently, the points of an event always
+	// go to the same writer so its updates are written in order
+	writers []*api.WriteAPIImpl
 }
 
 type InfluxdbConfig struct {
-	User                  string
-	Password              string
-	Secure                bool
-	Host                  string
-	DbName                string
-	WithFields            bool
-	InsecureSsl           bool
-	RetentionPolicy       string
-	ClusterName           string
-	DisableCounterMetrics bool
-	Concurrency           int
+	// Token, Org and Bucket are the API token, organization and bucket of
+	// InfluxDB 2 and 3. Without a token the sink authenticates with User and
+	// Password and writes to DbName through the v1 compatibility API.
+	Token  string `mapstructure:"influxdbToken"`
+	Org    string `mapstructure:"influxdbOrg"`
+	Bucket string `mapstructure:"influxdbBucket"`
+
+	User        string `mapstructure:"influxdbUsername"`
+	Password    string `mapstructure:"influxdbPassword"`
+	Secure      bool   `mapstructure:"influxdbSecure"`
+	Host        string `mapstructure:"influxdbHost" validate:"required"`
+	DbName      string `mapstructure:"influxdbName"`
+	WithFields  bool   `mapstructure:"influxdbWithFields"`
+	InsecureSsl bool   `mapstructure:"influxdbInsecureSsl"`
+	ClusterName string `mapstructure:"influxdbClusterName"`
+
+	// DisableCounterMetrics stops writing the count of every event to the
+	// series of its reason, kind and namespace
+	DisableCounterMetrics bool `mapstructure:"influxdbDisableCounterMetrics"`
+
+	// RetentionPolicy is the retention policy of DbName the points are
+	// written to, the default policy of the database when empty or "0". It
+	// is not used with Bucket.
+	RetentionPolicy string `mapstructure:"influxdbRetentionPolicy"`
+
+	// Concurrency is the number of batches written at the same time
+	Concurrency int `mapstructure:"influxdbConcurrency"`
+
+	// BatchSize points are written in one request, at least every
+	// FlushInterval. Failed batches are retried up to MaxRetries times,
+	// the oldest are dropped when they hold more than RetryBufferLimit points.
+	BatchSize        int           `mapstructure:"influxdbBatchSize"`
+	FlushInterval    time.Duration `mapstructure:"influxdbFlushInterval"`
+	MaxRetries       int           `mapstructure:"influxdbMaxRetries"`
+	RetryBufferLimit int           `mapstructure:"influxdbRetryBufferLimit"`
+
+	// TransformConfig only declares the transform keys so that Validate
+	// rejects them: the sink writes points built from the event, not the
+	// EventData the transforms reshape
+	TransformConfig `mapstructure:",squash"`
+}
+
+func defaultInfluxdbConfig() *InfluxdbConfig {
+	return &InfluxdbConfig{
+		DbName:           "k8s",
+		ClusterName:      "default",
+		Concurrency:      1,
+		BatchSize:        1000,
+		FlushInterval:    time.Second,
+		MaxRetries:       5,
+		RetryBufferLimit: 50000,
+	}
+}
+
+// Validate implements config.Validator
+func (c *InfluxdbConfig) Validate() error {
+	var errs []error
+	if c.Token == "" {
+		if c.User == "" || c.Password == "" {
+			errs = append(errs, errors.New("influxdbToken, or influxdbUsername and influxdbPassword, must be set"))
+		}
+		if c.Org != "" || c.Bucket != "" {
+			errs = append(errs, errors.New("influxdbOrg and influxdbBucket need influxdbToken"))
+		}
+	} else if c.User != "" || c.Password != "" {
+		errs = append(errs, errors.New("influxdbToken cannot be used with influxdbUsername and influxdbPassword"))
+	}
+	if c.Bucket != "" && c.RetentionPolicy != "" && c.RetentionPolicy != "0" {
+		errs = append(errs, errors.New("influxdbRetentionPolicy cannot be used with influxdbBucket"))
+	}
+	if c.Concurrency < 1 {
+		errs = append(errs, errors.New("influxdbConcurrency must be positive"))
+	}
+	if c.BatchSize < 1 || c.RetryBufferLimit < c.BatchSize {
+		errs = append(errs, errors.New("influxdbBatchSize must be positive and at most influxdbRetryBufferLimit"))
+	}
+	if c.FlushInterval < time.Millisecond {
+		errs = append(errs, errors.New("influxdbFlushInterval must be at least 1ms"))
+	}
+	if c.MaxRetries < 0 {
+		errs = append(errs, errors.New("influxdbMaxRetries must not be negative"))
+	}
+	if !c.TransformConfig.empty() {
+		errs = append(errs, errors.New("the influxdb sink does not support the transform keys"))
+	}
+	return errors.Join(errs...)
+}
+
+// bucket returns Bucket, or the bucket of the database and retention policy,
+// mapped to db/rp by the v1 compatibility API
+func (c *InfluxdbConfig) bucket() string {
+	if c.Bucket != "" {
+		return c.Bucket
+	}
+	if c.RetentionPolicy == "" || c.RetentionPolicy == "0" {
+		return c.DbName
+	}
+	return c.DbName + "/" + c.RetentionPolicy
 }
 
 // Returns a thread-safe implementation of EventSinkInterface for InfluxDB.
 func NewInfluxdbSink(cfg InfluxdbConfig) (InfluxDBSinkInterface, error) {
+	return newInfluxdbSink("influxdb", cfg), nil
+}
+
+func newInfluxdbSink(name string, cfg InfluxdbConfig) *InfluxDBSink {
 	protocol := "http"
 	if cfg.Secure {
 		protocol = "https"
 	}
 
 	serverURL := fmt.Sprintf("%s://%s", protocol, cfg.Host)
-	authToken := fmt.Sprintf("%s:%s", cfg.User, cfg.Password)
-	client := influxdb2.NewClientWithOptions(serverURL, authToken,
-		influxdb2.DefaultOptions().SetTLSConfig(&tls.Config{InsecureSkipVerify: cfg.InsecureSsl}),
-	)
+	authToken := cfg.Token
+	if authToken == "" {
+		authToken = fmt.Sprintf("%s:%s", cfg.User, cfg.Password)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: cfg.InsecureSsl}
+	options := influxdb2.DefaultOptions().
+		SetHTTPClient(&http.Client{Transport: influxdbTransport{transport, name}, Timeout: 20 * time.Second})
+	if cfg.BatchSize > 0 {
+		options.SetBatchSize(uint(cfg.BatchSize))
+	}
+	if cfg.FlushInterval > 0 {
+		options.SetFlushInterval(uint(cfg.FlushInterval.Milliseconds()))
+	}
+	if cfg.RetryBufferLimit > 0 {
+		options.SetRetryBufferLimit(uint(cfg.RetryBufferLimit))
+	}
+	options.SetMaxRetries(uint(cfg.MaxRetries))
+	client := influxdb2.NewClientWithOptions(serverURL, authToken, options)
 
-	return &InfluxDBSink{
-		config: cfg,
-		client: client,
-	}, nil
+	sink := &InfluxDBSink{
+		config:  cfg,
+		client:  client,
+		name:    name,
+		writers: make([]*api.WriteAPIImpl, max(cfg.Concurrency, 1)),
+	}
+	for i := range sink.writers {
+		w := api.NewWriteAPI(cfg.Org, cfg.bucket(), client.HTTPService(), client.Options().WriteOptions())
+		go func(errs <-chan error) {
+			for err := range errs {
+				glog.Errorf("InfluxDB write failed: %v", err)
+				reportFailure(name, err)
+			}
+		}(w.Errors())
+		sink.writers[i] = w
+	}
+	return sink
 }
 
-func (sink *InfluxDBSink) UpdateEvents(eNew *v1.Event, eOld *v1.Event) {
-	sink.Lock()
-	defer sink.Unlock()
+// influxdbTransport reports the successful writes, the client only tells
+// about the failed ones
+type influxdbTransport struct {
+	http.RoundTripper
+	sink string
+}
 
+func (t influxdbTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.RoundTripper.RoundTrip(req)
+	if err == nil && resp.StatusCode < http.StatusMultipleChoices && strings.HasSuffix(req.URL.Path, "/write") {
+		reportSuccess(t.sink)
+	}
+	return resp, err
+}
+
+func (sink *InfluxDBSink) UpdateEvents(eNew *v1.Event, eOld *v1.Event) {
 	var point *write.Point
 	var err error
 	if sink.config.WithFields {
@@ -128,10 +282,18 @@ func (sink *InfluxDBSink) UpdateEvents(eNew *v1.Event, eOld *v1.Event) {
 	}
 	if err != nil {
 		glog.Warningf("Failed to convert event to point: %v", err)
+		discardEvents(sink.name, 1)
+		return
 	}
 
-	point.AddTag("cluster_name", sink.config.ClusterName)
-	sink.sendData([]*write.Point{point})
+	points := []*write.Point{point}
+	if !sink.config.DisableCounterMetrics {
+		points = append(points, eventToCounterPoint(eNew))
+	}
+	for _, p := range points {
+		p.AddTag("cluster_name", sink.config.ClusterName)
+	}
+	sink.sendData(points)
 }
 
 func getEventValue(event *v1.Event) (string, error) {
@@ -145,7 +307,6 @@ func getEventValue(event *v1.Event) (string, error) {
 func eventToPointWithFields(event *v1.Event) (*write.Point, error) {
 	tags := map[string]string{
 		eventUID:               string(event.UID),
-		"message":              event.Message,
 		"object_name":          event.InvolvedObject.Name,
 		"type":                 event.Type,
 		"kind":                 event.InvolvedObject.Kind,
@@ -157,7 +318,10 @@ func eventToPointWithFields(event *v1.Event) (*write.Point, error) {
 	if event.InvolvedObject.Kind == "Pod" {
 		tags[LabelPodId.Key] = string(event.InvolvedObject.UID)
 	}
-	fields := map[string]interface{}{}
+	// A point needs a field to be written
+	fields := map[string]interface{}{
+		"message": event.Message,
+	}
 	ts := event.LastTimestamp.UTC()
 	return influxdb2.NewPoint("events", tags, fields, ts), nil
 }
@@ -186,15 +350,56 @@ func eventToPoint(event *v1.Event) (*write.Point, error) {
 	return point, nil
 }
 
+// eventToCounterPoint returns the count of event, which can be charted by
+// reason, kind and namespace without parsing values. The count is the total
+// of the event so far: the uid tag gives every event its own series, so the
+// events sharing the other tags and second do not overwrite each other, and
+// a later update of the same second only replaces a lower count.
+func eventToCounterPoint(event *v1.Event) *write.Point {
+	tags := map[string]string{
+		eventUID:               string(event.UID),
+		"reason":               event.Reason,
+		"kind":                 event.InvolvedObject.Kind,
+		"type":                 event.Type,
+		LabelNamespaceName.Key: event.Namespace,
+	}
+	fields := map[string]interface{}{
+		countField: event.Count,
+	}
+	ts := event.LastTimestamp.UTC()
+	return influxdb2.NewPoint(eventCountMeasurementName, tags, fields, ts)
+}
+
+// sendData hands the points of an event to its writer, which writes them in
+// the background
 func (sink *InfluxDBSink) sendData(points []*write.Point) {
-	writeAPI := sink.client.WriteAPIBlocking("", sink.config.DbName)
-
-	// Attempt to write the points
-	if err := writeAPI.WritePoint(context.Background(), points...); err != nil {
-		glog.Errorf("InfluxDB write failed: %v", err)
-		// Handle potential connection issues
-		if strings.Contains(err.Error(), dbNotFoundError) {
-			sink.dbExists = false
+	if len(points) == 0 {
+		return
+	}
+	w := sink.writer(points[0])
+	for _, point := range points {
+		w.WritePoint(point)
+	}
+}
+
+// writer returns the writer of the event of point, found by its uid tag
+func (sink *InfluxDBSink) writer(point *write.Point) *api.WriteAPIImpl {
+	if len(sink.writers) == 1 {
+		return sink.writers[0]
+	}
+	h := fnv.New32a()
+	for _, tag := range point.TagList() {
+		if tag.Key == eventUID {
+			h.Write([]byte(tag.Value))
 		}
 	}
+	return sink.writers[h.Sum32()%uint32(len(sink.writers))]
+}
+
+// close writes the buffered points and closes the client
+func (sink *InfluxDBSink) close() {
+	for _, w := range sink.writers {
+		w.Close()
+	}
+	sink.client.Close()
 }
@@ -1,12 +1,19 @@
 package sinks
 
 import (
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/influxdata/influxdb-client-go/v2/api"
 	"github.com/influxdata/influxdb-client-go/v2/api/write"
 	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/types"
 )
 
 // Mock server for InfluxDB
@@ -39,6 +46,7 @@ func TestEventToPointWithFields(t *testing.T) {
 	require.NoError(t, err)
 	require.NotNil(t, point)
 	require.Equal(t, "events", point.Name())
+	require.Equal(t, "message", point.FieldList()[0].Key)
 }
 
 // Test event data successfully sent to InfluxDB
@@ -70,3 +78,148 @@ func TestServerConnectionError(t *testing.T) {
 		sink.sendData([]*write.Point{point})
 	}()
 }
+
+func TestInfluxdbConfig_bucket(t *testing.T) {
+	testCases := []struct {
+		bucket, retentionPolicy string
+		want                    string
+	}{
+		{"", "", "k8s"},
+		{"", "0", "k8s"},
+		{"", "autogen", "k8s/autogen"},
+		{"events", "0", "events"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.want, func(t *testing.T) {
+			c := defaultInfluxdbConfig()
+			c.Bucket, c.RetentionPolicy = tc.bucket, tc.retentionPolicy
+			require.Equal(t, tc.want, c.bucket())
+		})
+	}
+}
+
+// influxdbWrites is an InfluxDB write endpoint recording the buckets, the
+// organizations, the authorizations and the lines of the batches it receives
+type influxdbWrites struct {
+	mu      sync.Mutex
+	buckets []string
+	orgs    []string
+	auth    []string
+	batches [][]string
+}
+
+func (f *influxdbWrites) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b, _ := io.ReadAll(r.Body)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.buckets = append(f.buckets, r.URL.Query().Get("bucket"))
+	f.orgs = append(f.orgs, r.URL.Query().Get("org"))
+	f.auth = append(f.auth, r.Header.Get("Authorization"))
+	f.batches = append(f.batches, strings.Split(strings.TrimSpace(string(b)), "\n"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func TestInfluxDBSink_batches(t *testing.T) {
+	r := &testHealthReporter{}
+	SetHealthReporter(r)
+	defer SetHealthReporter(nil)
+
+	f := &influxdbWrites{}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	c := defaultInfluxdbConfig()
+	c.Host = strings.TrimPrefix(srv.URL, "http://")
+	c.User, c.Password = "user", "password"
+	c.RetentionPolicy = "autogen"
+	c.DisableCounterMetrics = true
+	c.BatchSize = 2
+	c.FlushInterval = time.Hour
+	require.NoError(t, c.Validate())
+	sink := newInfluxdbSink("influxdb", *c)
+	for _, name := range []string{"a", "b", "c"} {
+		sink.UpdateEvents(createTestEvent(name, "", nil, nil), nil)
+	}
+	require.Eventually(t, func() bool {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		return len(f.batches) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Closing writes the last, partial, batch
+	sink.close()
+	require.Equal(t, []string{"k8s/autogen", "k8s/autogen"}, f.buckets)
+	require.Equal(t, "Token user:password", f.auth[0])
+	require.Len(t, f.batches[0], 2)
+	require.Len(t, f.batches[1], 1)
+	require.Contains(t, f.batches[1][0], "cluster_name=default")
+	require.Equal(t, []string{"influxdb", "influxdb"}, r.successes)
+}
+
+func TestInfluxDBSink_concurrency(t *testing.T) {
+	c := defaultInfluxdbConfig()
+	c.Host = "influxdb:8086"
+	c.Concurrency = 4
+	sink := newInfluxdbSink("influxdb", *c)
+	defer sink.close()
+	require.Len(t, sink.writers, 4)
+
+	// The points of an event are written by the same writer
+	used := map[*api.WriteAPIImpl]bool{}
+	for i := 0; i < 32; i++ {
+		event := createTestEvent(fmt.Sprintf("event-%d", i), "", nil, nil)
+		event.UID = types.UID(fmt.Sprintf("uid-%d", i))
+		a, err := eventToPoint(event)
+		require.NoError(t, err)
+		b, err := eventToPointWithFields(event)
+		require.NoError(t, err)
+		require.Same(t, sink.writer(a), sink.writer(b))
+		used[sink.writer(a)] = true
+	}
+	require.Len(t, used, 4)
+}
+
+func TestInfluxDBSink_v2(t *testing.T) {
+	f := &influxdbWrites{}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	c := defaultInfluxdbConfig()
+	c.Host = strings.TrimPrefix(srv.URL, "http://")
+	c.Token, c.Org, c.Bucket = "api-token", "platform", "events"
+	c.ClusterName = "prod"
+	require.NoError(t, c.Validate())
+	sink := newInfluxdbSink("influxdb", *c)
+	event := createTestEvent("backoff", "BackOff", nil, nil)
+	event.Count = 7
+	sink.UpdateEvents(event, nil)
+	sink.close()
+
+	require.Equal(t, []string{"events"}, f.buckets)
+	require.Equal(t, []string{"platform"}, f.orgs)
+	require.Equal(t, []string{"Token api-token"}, f.auth)
+	require.Len(t, f.batches[0], 2)
+	require.True(t, strings.HasPrefix(f.batches[0][0], eventMeasurementName+","), f.batches[0][0])
+	require.Equal(t, fmt.Sprintf("k8s_event_count,kind=Pod,namespace_name=default,reason=BackOff,type=Normal,uid=%s,cluster_name=prod count=7i %d", event.UID, event.LastTimestamp.UnixNano()), f.batches[0][1])
+}
+
+func TestInfluxDBSink_counterSeries(t *testing.T) {
+	// Two events sharing the tags and the second of their last timestamp
+	a := createTestEvent("backoff-a", "BackOff", nil, nil)
+	a.UID, a.Count = "uid-a", 3
+	b := a.DeepCopy()
+	b.Name, b.UID, b.Count = "backoff-b", "uid-b", 1
+
+	pa, pb := eventToCounterPoint(a), eventToCounterPoint(b)
+	require.Equal(t, pa.Time(), pb.Time())
+	require.NotEqual(t, write.PointToLineProtocol(pa, time.Nanosecond), write.PointToLineProtocol(pb, time.Nanosecond))
+	require.Contains(t, write.PointToLineProtocol(pa, time.Nanosecond), "uid=uid-a count=3i")
+	require.Contains(t, write.PointToLineProtocol(pb, time.Nanosecond), "uid=uid-b count=1i")
+
+	// An update of the same second replaces the count of its own series
+	a2 := a.DeepCopy()
+	a2.Count = 4
+	pa2 := eventToCounterPoint(a2)
+	require.Equal(t, pa.TagList(), pa2.TagList())
+	require.Contains(t, write.PointToLineProtocol(pa2, time.Nanosecond), "uid=uid-a count=4i")
+}
@@ -18,8 +18,12 @@ package sinks
 
 import (
 	"errors"
+	"fmt"
+	"strings"
 
 	"github.com/golang/glog"
+	"github.com/kuoss/eventrouter/config"
+	"github.com/kuoss/eventrouter/filter"
 	"github.com/spf13/viper"
 	v1 "k8s.io/api/core/v1"
 )
@@ -29,187 +33,132 @@ type EventSinkInterface interface {
 	UpdateEvents(eNew *v1.Event, eOld *v1.Event)
 }
 
-// ManufactureSink will manufacture a sink according to viper configs
-// TODO: Determine if it should return an array of sinks
-//
-// TODO: remove gocyclo:ignore
-//
-//gocyclo:ignore
-func ManufactureSink() (e EventSinkInterface) {
-	s := viper.GetString("sink")
-	glog.Infof("Sink is [%v]", s)
-	switch s {
-	case "glog":
-		e = NewGlogSink()
-	case "stdout":
-		viper.SetDefault("stdoutJSONNamespace", "")
-		stdoutNamespace := viper.GetString("stdoutJSONNamespace")
-		e = NewStdoutSink(stdoutNamespace)
-	case "http":
-		url := viper.GetString("httpSinkUrl")
-		if url == "" {
-			panic("http sink specified but no httpSinkUrl")
-		}
-
-		// By default we buffer up to 1500 events, and drop messages if more than
-		// 1500 have come in without getting consumed
-		viper.SetDefault("httpSinkBufferSize", 1500)
-		viper.SetDefault("httpSinkDiscardMessages", true)
-
-		bufferSize := viper.GetInt("httpSinkBufferSize")
-		overflow := viper.GetBool("httpSinkDiscardMessages")
-
-		h := NewHTTPSink(url, overflow, bufferSize)
-		go h.Run(make(chan bool))
-		return h
-	case "kafka":
-		viper.SetDefault("kafkaBrokers", []string{"kafka:9092"})
-		viper.SetDefault("kafkaTopic", "eventrouter")
-		viper.SetDefault("kafkaAsync", true)
-		viper.SetDefault("kafkaRetryMax", 5)
-		viper.SetDefault("kafkaSaslUser", "")
-		viper.SetDefault("kafkaSaslPwd", "")
-
-		brokers := viper.GetStringSlice("kafkaBrokers")
-		topic := viper.GetString("kafkaTopic")
-		async := viper.GetBool("kakfkaAsync")
-		retryMax := viper.GetInt("kafkaRetryMax")
-		saslUser := viper.GetString("kafkaSaslUser")
-		saslPwd := viper.GetString("kafkaSaslPwd")
-
-		e, err := NewKafkaSink(brokers, topic, async, retryMax, saslUser, saslPwd)
-		if err != nil {
-			panic(err.Error())
-		}
-		return e
-	case "s3sink":
-		accessKeyID := viper.GetString("s3SinkAccessKeyID")
-		if accessKeyID == "" {
-			panic("s3 sink specified but s3SinkAccessKeyID not specified")
-		}
-
-		secretAccessKey := viper.GetString("s3SinkSecretAccessKey")
-		if secretAccessKey == "" {
-			panic("s3 sink specified but s3SinkSecretAccessKey not specified")
-		}
-
-		region := viper.GetString("s3SinkRegion")
-		if region == "" {
-			panic("s3 sink specified but s3SinkRegion not specified")
-		}
-
-		bucket := viper.GetString("s3SinkBucket")
-		if bucket == "" {
-			panic("s3 sink specified but s3SinkBucket not specified")
-		}
-
-		bucketDir := viper.GetString("s3SinkBucketDir")
-		if bucketDir == "" {
-			panic("s3 sink specified but s3SinkBucketDir not specified")
-		}
-
-		// By default the json is pushed to s3 in not flatenned rfc5424 write format
-		// The option to write to s3 is in the flattened json format which will help in
-		// using the data in redshift with least effort
-		viper.SetDefault("s3SinkOutputFormat", "rfc5424")
-		outputFormat := viper.GetString("s3SinkOutputFormat")
-		if outputFormat != "rfc5424" && outputFormat != "flatjson" {
-			panic("s3 sink specified, but incorrect s3SinkOutputFormat specified. Supported formats are: rfc5424 (default) and flatjson")
-		}
-
-		// By default we buffer up to 1500 events, and drop messages if more than
-		// 1500 have come in without getting consumed
-		viper.SetDefault("s3SinkBufferSize", 1500)
-		viper.SetDefault("s3SinkDiscardMessages", true)
-
-		viper.SetDefault("s3SinkUploadInterval", 120)
-		uploadInterval := viper.GetInt("s3SinkUploadInterval")
-
-		bufferSize := viper.GetInt("s3SinkBufferSize")
-		overflow := viper.GetBool("s3SinkDiscardMessages")
-
-		s, err := NewS3Sink(accessKeyID, secretAccessKey, region, bucket, bucketDir, uploadInterval, overflow, bufferSize, outputFormat)
-		if err != nil {
-			panic(err.Error())
-		}
-
-		go s.Run(make(chan bool))
-		return s
-	case "influxdb":
-		host := viper.GetString("influxdbHost")
-		if host == "" {
-			panic("influxdb sink specified but influxdbHost not specified")
-		}
+// ConfigKeys returns every configuration key understood by the sinks,
+// including "sink" itself, the "sinks" and "routes" of the RouterSink and the
+// "filter", loop prevention and redaction applied in front of them.
+func ConfigKeys() []string {
+	keys := []string{"sink", "sinks", "routes"}
+	keys = append(keys, config.Keys(&filterConfig{})...)
+	keys = append(keys, config.Keys(&redactConfig{})...)
+	keys = append(keys, config.Keys(&selfConfig{})...)
+	keys = append(keys, config.Keys(&envelopeConfig{})...)
+	for _, name := range Registered() {
+		f, _ := lookupFactory(name)
+		keys = append(keys, config.Keys(f.NewConfig())...)
+	}
+	return keys
+}
 
-		username := viper.GetString("influxdbUsername")
-		if username == "" {
-			panic("influxdb sink specified but influxdbUsername not specified")
-		}
+// loadSinkConfig decodes the configuration of the sink selected by the "sink"
+// key of v.
+func loadSinkConfig(v *viper.Viper) (sinkSpec, error) {
+	s := v.GetString("sink")
+	f, ok := lookupFactory(s)
+	if !ok {
+		return sinkSpec{}, fmt.Errorf("sink: invalid Sink Specified %q, must be one of: %s", s, strings.Join(Registered(), ", "))
+	}
 
-		password := viper.GetString("influxdbPassword")
-		if password == "" {
-			panic("influxdb sink specified but influxdbPassword not specified")
-		}
+	spec := sinkSpec{typeName: s, factory: f, cfg: f.NewConfig()}
+	if spec.cfg == nil {
+		return spec, nil
+	}
+	if err := config.Decode(v, spec.cfg); err != nil {
+		return sinkSpec{}, prefixErrors("sink "+s, err)
+	}
+	return spec, nil
+}
 
-		viper.SetDefault("influxdbName", "k8s")
-		viper.SetDefault("influxdbSecure", false)
-		viper.SetDefault("influxdbWithFields", false)
-		viper.SetDefault("influxdbInsecureSsl", false)
-		viper.SetDefault("influxdbRetentionPolicy", "0")
-		viper.SetDefault("influxdbClusterName", "default")
-		viper.SetDefault("influxdbDisableCounterMetrics", false)
-		viper.SetDefault("influxdbConcurrency", 1)
+// ValidateConfig checks the configuration of the selected sink, or of every
+// sink instance and route, without building them, so no connection to any
+// sink is made.
+func ValidateConfig(v *viper.Viper) error {
+	_, _, filterErr := loadFilter(v)
+	_, redactErr := loadRedactor(v)
+	_, selfErr := loadSelfConfig(v)
+	_, envelopeErr := loadEnvelopeConfig(v)
+	var err error
+	if routingEnabled(v) {
+		_, _, err = loadRouting(v)
+	} else {
+		_, err = loadSinkConfig(v)
+	}
+	return errors.Join(filterErr, redactErr, selfErr, envelopeErr, err)
+}
 
-		dbName := viper.GetString("influxdbName")
-		secure := viper.GetBool("influxdbSecure")
-		withFields := viper.GetBool("influxdbWithFields")
-		insecureSsl := viper.GetBool("influxdbInsecureSsl")
-		retentionPolicy := viper.GetString("influxdbRetentionPolicy")
-		cluterName := viper.GetString("influxdbClusterName")
-		disableCounterMetrics := viper.GetBool("influxdbDisableCounterMetrics")
-		concurrency := viper.GetInt("influxdbConcurrency")
+// loadFilter compiles the "filter" expression of v and returns it with the
+// error policy, the expression is nil when no filter is configured.
+func loadFilter(v *viper.Viper) (*filter.Expression, string, error) {
+	cfg := defaultFilterConfig()
+	if err := config.Decode(v, cfg); err != nil {
+		return nil, "", err
+	}
+	if cfg.Filter == "" {
+		return nil, cfg.ErrorPolicy, nil
+	}
+	expr, err := filter.Compile(cfg.Filter)
+	if err != nil {
+		return nil, "", fmt.Errorf("filter: %w", err)
+	}
+	return expr, cfg.ErrorPolicy, nil
+}
 
-		cfg := InfluxdbConfig{
-			User:                  username,
-			Password:              password,
-			Secure:                secure,
-			Host:                  host,
-			DbName:                dbName,
-			WithFields:            withFields,
-			InsecureSsl:           insecureSsl,
-			RetentionPolicy:       retentionPolicy,
-			ClusterName:           cluterName,
-			DisableCounterMetrics: disableCounterMetrics,
-			Concurrency:           concurrency,
-		}
+// ManufactureSink will manufacture a sink according to viper configs, using
+// the factory registered under the name given by the "sink" key. When sink
+// instances and routes are configured it returns a RouterSink instead.
+// A configured "filter" wraps the result in a FilterSink, the loop prevention
+// policy in a SelfSink, and redaction rules in a RedactSink in front of
+// everything else.
+func ManufactureSink() (EventSinkInterface, error) {
+	v := viper.GetViper()
+	expr, errorPolicy, err := loadFilter(v)
+	if err != nil {
+		return nil, err
+	}
+	redactor, err := loadRedactor(v)
+	if err != nil {
+		return nil, err
+	}
+	self, err := loadSelfConfig(v)
+	if err != nil {
+		return nil, err
+	}
+	env, err := loadEnvelopeConfig(v)
+	if err != nil {
+		return nil, err
+	}
+	if env.Version == "v2" {
+		glog.Infof("Writing v2 envelopes for cluster %q and instance %q", env.ClusterID, env.InstanceID)
+	}
 
-		influx, err := NewInfluxdbSink(cfg)
-		if err != nil {
-			panic(err.Error())
+	var sink EventSinkInterface
+	if routingEnabled(v) {
+		sink, err = manufactureRouter(v, env)
+	} else {
+		var spec sinkSpec
+		spec, err = loadSinkConfig(v)
+		if err == nil {
+			glog.Infof("Sink is [%v]", spec.typeName)
+			sink, err = spec.build(spec.typeName, env)
 		}
-		return influx
-	case "eventhub":
-		connString := viper.GetString("eventHubConnectionString")
-		if connString == "" {
-			panic("eventhub sink specified but eventHubConnectionString not specified")
-		}
-		// By default we buffer up to 1500 events, and drop messages if more than
-		// 1500 have come in without getting consumed
-		viper.SetDefault("eventHubSinkBufferSize", 1500)
-		viper.SetDefault("eventHubSinkDiscardMessages", true)
+	}
+	if err != nil {
+		return nil, err
+	}
 
-		bufferSize := viper.GetInt("eventHubSinkBufferSize")
-		overflow := viper.GetBool("eventHubSinkDiscardMessages")
-		eh, err := NewEventHubSink(connString, overflow, bufferSize)
+	if expr != nil {
+		glog.Infof("Filtering events with %q, the events it fails to evaluate against are handled with the %q policy", expr, errorPolicy)
+		sink = NewFilterSink(expr, errorPolicy, sink)
+	}
+	if self != nil {
+		glog.Infof("Applying the %q policy to events about %s/%s and workloads %v", self.Policy, self.PodNamespace, self.PodName, self.Workloads)
+		sink, err = NewSelfSink(self.Policy, self.PodName, self.PodNamespace, self.Workloads, sink)
 		if err != nil {
-			panic(err.Error())
+			return nil, err
 		}
-		go eh.Run(make(chan bool))
-		return eh
-	// case "logfile"
-	default:
-		err := errors.New("invalid Sink Specified")
-		panic(err.Error())
-	}
-	return e
+	}
+	if redactor != nil {
+		glog.Infof("Redacting event messages and annotations")
+		sink = NewRedactSink(redactor, sink)
+	}
+	return sink, nil
 }
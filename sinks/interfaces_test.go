@@ -10,7 +10,8 @@ import (
 func TestManufactureSink(t *testing.T) {
 	t.Run("GlogSink", func(t *testing.T) {
 		viper.Set("sink", "glog")
-		sink := ManufactureSink()
+		sink, err := ManufactureSink()
+		require.NoError(t, err)
 		require.NotNil(t, sink)
 		_, ok := sink.(*GlogSink)
 		require.True(t, ok, "Expected GlogSink")
@@ -19,7 +20,8 @@ func TestManufactureSink(t *testing.T) {
 	t.Run("StdoutSink", func(t *testing.T) {
 		viper.Set("sink", "stdout")
 		viper.Set("stdoutJSONNamespace", "testnamespace")
-		sink := ManufactureSink()
+		sink, err := ManufactureSink()
+		require.NoError(t, err)
 		require.NotNil(t, sink)
 		stdoutSink, ok := sink.(*StdoutSink)
 		require.True(t, ok, "Expected StdoutSink")
@@ -32,7 +34,8 @@ func TestManufactureSink(t *testing.T) {
 		viper.Set("httpSinkBufferSize", 1500)
 		viper.Set("httpSinkDiscardMessages", true)
 
-		sink := ManufactureSink()
+		sink, err := ManufactureSink()
+		require.NoError(t, err)
 		require.NotNil(t, sink)
 		httpSink, ok := sink.(*HTTPSink)
 		require.True(t, ok, "Expected HTTPSink")
@@ -42,17 +45,213 @@ func TestManufactureSink(t *testing.T) {
 		require.Equal(t, "http://localhost", httpSink.SinkURL)
 	})
 
+	t.Run("HTTPSink_missing_url", func(t *testing.T) {
+		viper.Set("sink", "http")
+		viper.Set("httpSinkUrl", "")
+
+		sink, err := ManufactureSink()
+		require.EqualError(t, err, "sink http: httpSinkUrl: required but not set")
+		require.Nil(t, sink)
+	})
+
 	t.Run("InvalidSink", func(t *testing.T) {
 		viper.Set("sink", "invalid")
 
-		defer func() {
-			if r := recover(); r != nil {
-				require.Contains(t, r, "invalid Sink Specified")
-			}
-		}()
-
-		ManufactureSink()
+		sink, err := ManufactureSink()
+		require.ErrorContains(t, err, "invalid Sink Specified")
+		require.Nil(t, sink)
 	})
 
 	// Additional tests for each sink type can be added below
 }
+
+func TestValidateConfig(t *testing.T) {
+	testCases := []struct {
+		name      string
+		settings  map[string]interface{}
+		wantError string
+	}{
+		{
+			"glog",
+			map[string]interface{}{"sink": "glog"},
+			"",
+		},
+		{
+			"kafkaAsync is read",
+			map[string]interface{}{"sink": "kafka", "kafkaAsync": "maybe"},
+			"sink kafka: kafkaAsync: invalid value maybe (string), expected bool",
+		},
+		{
+			"every problem is reported",
+			map[string]interface{}{"sink": "s3sink", "s3SinkRegion": "ap-south-1", "s3SinkUploadInterval": "soon", "s3SinkOutputFormat": "xml"},
+			"sink s3sink: s3SinkBucket: required but not set\n" +
+				"sink s3sink: s3SinkBucketDir: required but not set\n" +
+				"sink s3sink: s3SinkOutputFormat: invalid value \"xml\", must be one of: rfc5424, flatjson\n" +
+				"sink s3sink: s3SinkUploadInterval: invalid value soon (string), expected integer",
+		},
+		{
+			"kafka sasl",
+			map[string]interface{}{"sink": "kafka", "kafkaSaslUser": "user"},
+			"sink kafka: kafkaSaslUser and kafkaSaslPwd must be set together",
+		},
+		{
+			"kafka sasl mechanism",
+			map[string]interface{}{"sink": "kafka", "kafkaSaslMechanism": "GSSAPI"},
+			"sink kafka: kafkaSaslMechanism: invalid value \"GSSAPI\", must be one of: PLAIN, SCRAM-SHA-256, SCRAM-SHA-512, OAUTHBEARER",
+		},
+		{
+			"kafka scram and oauthbearer",
+			map[string]interface{}{"sink": "kafka", "kafkaSaslMechanism": "SCRAM-SHA-512", "kafkaSaslTokenFile": "/var/run/token"},
+			"sink kafka: kafkaSaslMechanism SCRAM-SHA-512 needs kafkaSaslUser and kafkaSaslPwd\n" +
+				"sink kafka: kafkaSaslTokenFile needs the OAUTHBEARER kafkaSaslMechanism",
+		},
+		{
+			"kafka tls",
+			map[string]interface{}{"sink": "kafka", "kafkaTLSCertFile": "tls.crt", "kafkaVersion": "latest"},
+			"sink kafka: kafkaTLSCertFile and kafkaTLSKeyFile must be set together\n" +
+				"sink kafka: kafkaTLS keys need kafkaTLS to be enabled\n" +
+				"sink kafka: kafkaVersion: invalid version `latest`",
+		},
+		{
+			"s3 sizes",
+			map[string]interface{}{"sink": "s3sink", "s3SinkAccessKeyID": "id", "s3SinkSecretAccessKey": "secret", "s3SinkRegion": "us-west-2", "s3SinkBucket": "b", "s3SinkBucketDir": "d", "s3SinkUploadBytes": -1},
+			"sink s3sink: s3SinkUploadBytes and s3SinkRetainBytes must not be negative",
+		},
+		{
+			"s3 credentials and endpoint",
+			map[string]interface{}{"sink": "s3sink", "s3SinkAccessKeyID": "id", "s3SinkRegion": "us-west-2", "s3SinkBucket": "b", "s3SinkBucketDir": "d", "s3SinkRoleExternalID": "x", "s3SinkEndpoint": "minio:9000", "s3SinkTLSKeyFile": "tls.key"},
+			"sink s3sink: s3SinkAccessKeyID and s3SinkSecretAccessKey must be set together\n" +
+				"sink s3sink: s3SinkRoleExternalID and s3SinkRoleSessionName need s3SinkRoleARN\n" +
+				"sink s3sink: s3SinkEndpoint: invalid URL \"minio:9000\"\n" +
+				"sink s3sink: s3SinkTLSCertFile and s3SinkTLSKeyFile must be set together",
+		},
+		{
+			"s3 key template",
+			map[string]interface{}{"sink": "s3sink", "s3SinkRegion": "us-west-2", "s3SinkBucket": "b", "s3SinkBucketDir": "d", "s3SinkKeyTemplate": "{{.Dir}}/{{.Bucket}}"},
+			"sink s3sink: s3SinkKeyTemplate: template: s3SinkKeyTemplate:1:11: executing \"s3SinkKeyTemplate\" at <.Bucket>: can't evaluate field Bucket in type sinks.objectKey",
+		},
+		{
+			"s3 compression",
+			map[string]interface{}{"sink": "s3sink", "s3SinkRegion": "us-west-2", "s3SinkBucket": "b", "s3SinkBucketDir": "d", "s3SinkCompression": "bzip2"},
+			"sink s3sink: s3SinkCompression: invalid value \"bzip2\", must be one of: none, gzip, zstd",
+		},
+		{
+			"s3 split batch",
+			map[string]interface{}{"sink": "s3sink", "s3SinkRegion": "us-west-2", "s3SinkBucket": "b", "s3SinkBucketDir": "d", "s3SinkSplitBatch": true},
+			"sink s3sink: s3SinkSplitBatch needs s3SinkKeyTemplate",
+		},
+		{
+			"gcs",
+			map[string]interface{}{"sink": "gcs", "gcsSinkBucketDir": "d", "gcsSinkUploadBytes": -1, "gcsSinkEndpoint": "fake-gcs:4443", "gcsSinkCredentialsFile": "key.json", "gcsSinkWithoutAuthentication": true},
+			"sink gcs: gcsSinkBucket: required but not set",
+		},
+		{
+			"gcs options",
+			map[string]interface{}{"sink": "gcs", "gcsSinkBucket": "b", "gcsSinkBucketDir": "d", "gcsSinkUploadBytes": -1, "gcsSinkKeyTemplate": "", "gcsSinkSplitBatch": true, "gcsSinkEndpoint": "fake-gcs:4443", "gcsSinkCredentialsFile": "key.json", "gcsSinkWithoutAuthentication": true},
+			"sink gcs: gcsSinkUploadBytes and gcsSinkRetainBytes must not be negative\n" +
+				"sink gcs: gcsSinkSplitBatch needs gcsSinkKeyTemplate\n" +
+				"sink gcs: gcsSinkEndpoint: invalid URL \"fake-gcs:4443\"\n" +
+				"sink gcs: gcsSinkWithoutAuthentication cannot be used with gcsSinkCredentialsFile",
+		},
+		{
+			"azure blob options",
+			map[string]interface{}{"sink": "azureblob", "azureBlobSinkServiceURL": "account.blob.core.windows.net", "azureBlobSinkContainer": "c", "azureBlobSinkContainerDir": "d", "azureBlobSinkAccountName": "account", "azureBlobSinkCompression": "lz4", "outputFormat": "parquet"},
+			"sink azureblob: azureBlobSinkCompression: invalid value \"lz4\", must be one of: none, gzip, zstd",
+		},
+		{
+			"azure blob credentials",
+			map[string]interface{}{"sink": "azureblob", "azureBlobSinkServiceURL": "account.blob.core.windows.net", "azureBlobSinkContainer": "c", "azureBlobSinkContainerDir": "d", "azureBlobSinkAccountName": "account", "azureBlobSinkKeyTemplate": "{{.Container}}"},
+			"sink azureblob: azureBlobSinkKeyTemplate: template: azureBlobSinkKeyTemplate:1:2: executing \"azureBlobSinkKeyTemplate\" at <.Container>: can't evaluate field Container in type sinks.objectKey\n" +
+				"sink azureblob: azureBlobSinkServiceURL: invalid URL \"account.blob.core.windows.net\"\n" +
+				"sink azureblob: azureBlobSinkAccountName and azureBlobSinkAccountKey must be set together",
+		},
+		{
+			"influxdb batching",
+			map[string]interface{}{"sink": "influxdb", "influxdbUsername": "u", "influxdbPassword": "p", "influxdbHost": "influxdb:8086", "influxdbConcurrency": 0, "influxdbBatchSize": 100, "influxdbRetryBufferLimit": 10, "influxdbFlushInterval": "0s", "influxdbMaxRetries": -1},
+			"sink influxdb: influxdbConcurrency must be positive\n" +
+				"sink influxdb: influxdbBatchSize must be positive and at most influxdbRetryBufferLimit\n" +
+				"sink influxdb: influxdbFlushInterval must be at least 1ms\n" +
+				"sink influxdb: influxdbMaxRetries must not be negative",
+		},
+		{
+			"influxdb v1 credentials",
+			map[string]interface{}{"sink": "influxdb", "influxdbUsername": "u", "influxdbHost": "influxdb:8086", "influxdbOrg": "platform"},
+			"sink influxdb: influxdbToken, or influxdbUsername and influxdbPassword, must be set\n" +
+				"sink influxdb: influxdbOrg and influxdbBucket need influxdbToken",
+		},
+		{
+			"influxdb v2 credentials",
+			map[string]interface{}{"sink": "influxdb", "influxdbToken": "t", "influxdbPassword": "p", "influxdbHost": "influxdb:8086", "influxdbBucket": "events", "influxdbRetentionPolicy": "autogen"},
+			"sink influxdb: influxdbToken cannot be used with influxdbUsername and influxdbPassword\n" +
+				"sink influxdb: influxdbRetentionPolicy cannot be used with influxdbBucket",
+		},
+		{
+			"influxdb transform",
+			map[string]interface{}{"sink": "influxdb", "influxdbToken": "t", "influxdbHost": "influxdb:8086", "transformDrop": []string{"event.message"}},
+			"sink influxdb: the influxdb sink does not support the transform keys",
+		},
+		{
+			"kafka idempotent",
+			map[string]interface{}{"sink": "kafka", "kafkaIdempotent": true, "kafkaRequiredAcks": "local", "kafkaMaxInFlight": 5, "kafkaRetryMax": 0, "kafkaAsync": false, "kafkaDeadLetterTopic": "dead"},
+			"sink kafka: kafkaIdempotent needs kafkaRequiredAcks all\n" +
+				"sink kafka: kafkaIdempotent needs kafkaMaxInFlight 1\n" +
+				"sink kafka: kafkaIdempotent needs kafkaRetryMax to be positive\n" +
+				"sink kafka: kafkaDeadLetterTopic needs kafkaAsync",
+		},
+		{
+			"kafka compression",
+			map[string]interface{}{"sink": "kafka", "kafkaCompression": "brotli"},
+			"sink kafka: kafkaCompression: invalid value \"brotli\", must be one of: none, gzip, snappy, lz4, zstd",
+		},
+		{
+			"kafka templates",
+			map[string]interface{}{"sink": "kafka", "kafkaTopic": "events.{{.Namespace", "kafkaKeyTemplate": "{{.Pod}}"},
+			"sink kafka: kafkaTopic: template: kafkaTopic:1: unclosed action\n" +
+				"sink kafka: kafkaKeyTemplate: template: kafkaKeyTemplate:1:2: executing \"kafkaKeyTemplate\" at <.Pod>: can't evaluate field Pod in type *v1.Event",
+		},
+		{
+			"kafka topic template with schema registry",
+			map[string]interface{}{"sink": "kafka", "kafkaTopic": "events.{{.Namespace}}", "outputFormat": "avro", "schemaRegistryUrl": "http://registry:8081"},
+			"sink kafka: a kafkaTopic template needs schemaRegistrySubject",
+		},
+		{
+			"transform",
+			map[string]interface{}{"sink": "kafka", "kafkaSaslUser": "user", "kafkaSaslPwd": "pwd", "transformRename": []string{"event.reason"}},
+			"sink kafka: transformRename: invalid rename \"event.reason\", expected from=to",
+		},
+		{
+			"transform template with flatjson",
+			map[string]interface{}{"sink": "s3sink", "s3SinkAccessKeyID": "id", "s3SinkSecretAccessKey": "secret", "s3SinkRegion": "us-west-2", "s3SinkBucket": "b", "s3SinkBucketDir": "d", "s3SinkOutputFormat": "flatjson", "transformTemplate": "{{.verb}}"},
+			"sink s3sink: transformTemplate renders text and cannot be used with the flatjson outputFormat",
+		},
+		{
+			"output format",
+			map[string]interface{}{"sink": "http", "httpSinkUrl": "http://localhost", "outputFormat": "xml"},
+			"sink http: outputFormat: invalid value \"xml\", must be one of: avro, csv, flatjson, json, logfmt, ndjson, parquet, protobuf, rfc5424",
+		},
+		{
+			"cloud events mode",
+			map[string]interface{}{"sink": "kafka", "cloudEventsMode": "on"},
+			"sink kafka: cloudEventsMode: invalid value \"on\", must be one of: off, structured, binary",
+		},
+		{
+			"output format overrides s3SinkOutputFormat",
+			map[string]interface{}{"sink": "s3sink", "s3SinkAccessKeyID": "id", "s3SinkSecretAccessKey": "secret", "s3SinkRegion": "us-west-2", "s3SinkBucket": "b", "s3SinkBucketDir": "d", "s3SinkOutputFormat": "flatjson", "outputFormat": "csv", "transformTemplate": "{{.verb}}"},
+			"sink s3sink: transformTemplate renders text and cannot be used with the csv outputFormat",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			v := viper.New()
+			for k, val := range tc.settings {
+				v.Set(k, val)
+			}
+			err := ValidateConfig(v)
+			if tc.wantError == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tc.wantError)
+			}
+		})
+	}
+}
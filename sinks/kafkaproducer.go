@@ -0,0 +1,176 @@
+/*
+Copyright 2017 The Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sinks
+
+import (
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// KafkaMessagesTotal counts the outcomes of the messages of the async kafka
+// sink: delivered, requeued, dead_lettered or dropped
+var KafkaMessagesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "eventrouter_kafka_messages_total",
+	Help: "Total number of messages produced by the async kafka sink, by outcome",
+}, []string{"result"})
+
+// kafkaDelivery is the metadata of a message sent by kafkaAsync
+type kafkaDelivery struct {
+	// attempts counts the times the message was given to the producer
+	attempts int
+
+	// deadLetter is set on messages sent to the dead letter topic, which are
+	// dropped when they fail
+	deadLetter bool
+}
+
+// kafkaAsync sends the messages of the kafka sink with an async producer.
+// Goroutines drain its successes and errors. Failed messages are requeued
+// up to requeueMax times and then written to deadLetterTopic, or dropped
+// when it is empty.
+type kafkaAsync struct {
+	// sink is the name of the sink in the health reports
+	sink            string
+	producer        sarama.AsyncProducer
+	requeueMax      int
+	requeueBackoff  time.Duration
+	deadLetterTopic string
+
+	// mu guards closed and sending, sending on the input of a closed
+	// producer panics. closing is closed with closed set, to release the
+	// sends waiting for the producer.
+	mu      sync.RWMutex
+	closed  bool
+	closing chan struct{}
+	sending sync.WaitGroup
+
+	// drained is done once the successes and errors are drained
+	drained sync.WaitGroup
+}
+
+func newKafkaAsync(sink string, p sarama.AsyncProducer, requeueMax int, requeueBackoff time.Duration, deadLetterTopic string) *kafkaAsync {
+	a := &kafkaAsync{
+		sink:            sink,
+		producer:        p,
+		requeueMax:      requeueMax,
+		requeueBackoff:  requeueBackoff,
+		deadLetterTopic: deadLetterTopic,
+		closing:         make(chan struct{}),
+	}
+	a.drained.Add(2)
+	go a.drainSuccesses()
+	go a.drainErrors()
+	return a
+}
+
+// send gives msg to the producer, it blocks while the producer is busy
+// until close is called
+func (a *kafkaAsync) send(msg *sarama.ProducerMessage) {
+	a.mu.RLock()
+	if a.closed {
+		a.mu.RUnlock()
+		a.drop(msg)
+		return
+	}
+	a.sending.Add(1)
+	a.mu.RUnlock()
+	defer a.sending.Done()
+
+	d, _ := msg.Metadata.(*kafkaDelivery)
+	if d == nil {
+		d = &kafkaDelivery{}
+		msg.Metadata = d
+	}
+	d.attempts++
+	select {
+	case a.producer.Input() <- msg:
+	case <-a.closing:
+		a.drop(msg)
+	}
+}
+
+// drop counts msg, which is not sent because the producer is closed
+func (a *kafkaAsync) drop(msg *sarama.ProducerMessage) {
+	KafkaMessagesTotal.WithLabelValues("dropped").Inc()
+	glog.Warningf("Dropping Kafka message to %s, the producer is closed", msg.Topic)
+}
+
+func (a *kafkaAsync) drainSuccesses() {
+	defer a.drained.Done()
+	for range a.producer.Successes() {
+		KafkaMessagesTotal.WithLabelValues("delivered").Inc()
+		reportSuccess(a.sink)
+	}
+}
+
+func (a *kafkaAsync) drainErrors() {
+	defer a.drained.Done()
+	for pErr := range a.producer.Errors() {
+		glog.Errorf("Failed to produce message to %s: %v", pErr.Msg.Topic, pErr.Err)
+		reportFailure(a.sink, pErr.Err)
+		a.retry(pErr.Msg)
+	}
+}
+
+// retry requeues or dead-letters a failed message. It does not wait for the
+// input of the producer, which may be blocked on the errors being drained.
+func (a *kafkaAsync) retry(msg *sarama.ProducerMessage) {
+	d, _ := msg.Metadata.(*kafkaDelivery)
+	if d == nil {
+		d = &kafkaDelivery{attempts: 1}
+	}
+	switch {
+	case d.deadLetter:
+		KafkaMessagesTotal.WithLabelValues("dropped").Inc()
+	case d.attempts <= a.requeueMax:
+		KafkaMessagesTotal.WithLabelValues("requeued").Inc()
+		time.AfterFunc(a.requeueBackoff, func() { a.send(msg) })
+	case a.deadLetterTopic != "":
+		KafkaMessagesTotal.WithLabelValues("dead_lettered").Inc()
+		dead := &sarama.ProducerMessage{
+			Topic:    a.deadLetterTopic,
+			Key:      msg.Key,
+			Value:    msg.Value,
+			Headers:  append(msg.Headers, sarama.RecordHeader{Key: []byte("original-topic"), Value: []byte(msg.Topic)}),
+			Metadata: &kafkaDelivery{deadLetter: true},
+		}
+		go a.send(dead)
+	default:
+		KafkaMessagesTotal.WithLabelValues("dropped").Inc()
+	}
+}
+
+// close flushes the buffered messages and closes the producer. Messages
+// waiting to be requeued, or for the producer to accept them, are dropped.
+func (a *kafkaAsync) close() {
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return
+	}
+	a.closed = true
+	close(a.closing)
+	a.mu.Unlock()
+
+	a.sending.Wait()
+	a.producer.AsyncClose()
+	a.drained.Wait()
+}
@@ -0,0 +1,74 @@
+package sinks
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xdg-go/scram"
+)
+
+func TestSCRAMClient(t *testing.T) {
+	for name, hash := range map[string]scram.HashGeneratorFcn{"SHA-256": scram.SHA256, "SHA-512": scram.SHA512} {
+		t.Run(name, func(t *testing.T) {
+			client, err := hash.NewClient("eventrouter", "secret", "")
+			require.NoError(t, err)
+			credentials := client.GetStoredCredentials(scram.KeyFactors{Salt: "salt", Iters: 4096})
+			server, err := hash.NewServer(func(user string) (scram.StoredCredentials, error) {
+				require.Equal(t, "eventrouter", user)
+				return credentials, nil
+			})
+			require.NoError(t, err)
+
+			// Play the conversation of the broker against the client sarama uses
+			c := newSCRAMClientGenerator(hash)()
+			require.NoError(t, c.Begin("eventrouter", "secret", ""))
+			serverConv := server.NewConversation()
+			msg, err := c.Step("")
+			require.NoError(t, err)
+			for !c.Done() {
+				challenge, err := serverConv.Step(msg)
+				require.NoError(t, err)
+				msg, err = c.Step(challenge)
+				require.NoError(t, err)
+			}
+			require.True(t, serverConv.Valid())
+
+			// A wrong password fails the proof
+			require.NoError(t, c.Begin("eventrouter", "wrong", ""))
+			serverConv = server.NewConversation()
+			msg, err = c.Step("")
+			require.NoError(t, err)
+			challenge, err := serverConv.Step(msg)
+			require.NoError(t, err)
+			msg, err = c.Step(challenge)
+			require.NoError(t, err)
+			_, err = serverConv.Step(msg)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestTokenFileProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	p := tokenFileProvider{path: path}
+
+	_, err := p.Token()
+	require.ErrorContains(t, err, "failed to read kafkaSaslTokenFile")
+
+	require.NoError(t, os.WriteFile(path, []byte("token-1\n"), 0o600))
+	token, err := p.Token()
+	require.NoError(t, err)
+	require.Equal(t, "token-1", token.Token)
+
+	// Rotated tokens are read again
+	require.NoError(t, os.WriteFile(path, []byte("token-2"), 0o600))
+	token, err = p.Token()
+	require.NoError(t, err)
+	require.Equal(t, "token-2", token.Token)
+
+	require.NoError(t, os.WriteFile(path, nil, 0o600))
+	_, err = p.Token()
+	require.EqualError(t, err, "kafkaSaslTokenFile "+path+" is empty")
+}
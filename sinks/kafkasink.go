@@ -17,50 +17,332 @@ limitations under the License.
 package sinks
 
 import (
-	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
 
 	"github.com/IBM/sarama"
 	"github.com/golang/glog"
+	"github.com/xdg-go/scram"
 	v1 "k8s.io/api/core/v1"
 )
 
+func init() {
+	Register("kafka", SinkFactory{
+		NewConfig: func() interface{} { return defaultKafkaSinkConfig() },
+		New: func(name string, cfg interface{}) (EventSinkInterface, error) {
+			c := cfg.(*KafkaSinkConfig)
+			ks, err := newKafkaSink(name, c)
+			if err != nil {
+				return nil, err
+			}
+			onShutdown(ks.close)
+			ks.topic, ks.key, ks.headers = c.topic, c.key, c.Headers
+			ks.configure(&c.OutputConfig)
+			ks.cloudEvents = c.CloudEvents()
+			if c.SchemaRegistryConfig.URL != "" {
+				enc := c.Encoder().(schemaEncoder)
+				id, err := c.schemaID(enc, c.Topic+"-value")
+				if err != nil {
+					return nil, err
+				}
+				glog.Infof("Writing Kafka records with schema %d", id)
+				ks.schemaID, ks.schemaEnc = id, enc
+			}
+			return ks, nil
+		},
+	})
+}
+
 // KafkaSink implements the EventSinkInterface
 type KafkaSink struct {
 	Topic    string
 	producer interface{}
+
+	// name identifies the sink in the health reports and metrics
+	name string
+
+	// topic and key, when set, render the topic and the key of the message
+	// of an event, otherwise Topic and the name of the involved object are
+	// used
+	topic *template.Template
+	key   *template.Template
+
+	// headers adds the type, reason, namespace and cluster of the event as
+	// record headers
+	headers bool
+
+	// cloudEvents, when set, writes CloudEvents with the Kafka protocol
+	// binding
+	cloudEvents *CloudEvents
+
+	// schemaEnc, when set, frames records in the Confluent wire format with
+	// the registered schemaID
+	schemaEnc schemaEncoder
+	schemaID  int
+
+	eventShaper
+}
+
+// KafkaSinkConfig is the configuration of the kafka sink
+type KafkaSinkConfig struct {
+	Brokers []string `mapstructure:"kafkaBrokers" validate:"required"`
+
+	// Topic and KeyTemplate are text/template templates executed on the
+	// v1.Event, e.g. events.{{.Namespace}}.{{.Type}}
+	Topic       string `mapstructure:"kafkaTopic" validate:"required"`
+	KeyTemplate string `mapstructure:"kafkaKeyTemplate"`
+
+	// Headers adds the type, reason, namespace and cluster of the event as
+	// record headers
+	Headers bool `mapstructure:"kafkaHeaders"`
+
+	// Compression is the codec of the record batches
+	Compression string `mapstructure:"kafkaCompression" validate:"oneof=none gzip snappy lz4 zstd"`
+
+	Async    bool `mapstructure:"kafkaAsync"`
+	RetryMax int  `mapstructure:"kafkaRetryMax"`
+
+	// RequiredAcks is all, local (the leader only) or none
+	RequiredAcks string `mapstructure:"kafkaRequiredAcks" validate:"oneof=all local none"`
+	Idempotent   bool   `mapstructure:"kafkaIdempotent"`
+
+	// MaxInFlight is the number of unacknowledged requests per broker
+	// connection, 5 when 0, or 1 with Idempotent
+	MaxInFlight int `mapstructure:"kafkaMaxInFlight"`
+
+	// FlushFrequency and FlushBytes trigger sending the batched messages of
+	// the producer, whichever comes first
+	FlushFrequency time.Duration `mapstructure:"kafkaFlushFrequency"`
+	FlushBytes     int           `mapstructure:"kafkaFlushBytes"`
+
+	// RequeueMax is the number of times the async producer gives a failed
+	// message another try, after sarama exhausted its retries. Messages still
+	// failing are written to DeadLetterTopic, or dropped when it is empty.
+	RequeueMax      int    `mapstructure:"kafkaRequeueMax"`
+	DeadLetterTopic string `mapstructure:"kafkaDeadLetterTopic"`
+
+	SaslUser string `mapstructure:"kafkaSaslUser"`
+	SaslPwd  string `mapstructure:"kafkaSaslPwd"`
+
+	// SaslMechanism authenticates with kafkaSaslUser and kafkaSaslPwd, or
+	// with the token in SaslTokenFile for OAUTHBEARER
+	SaslMechanism string `mapstructure:"kafkaSaslMechanism" validate:"oneof=PLAIN SCRAM-SHA-256 SCRAM-SHA-512 OAUTHBEARER"`
+	SaslTokenFile string `mapstructure:"kafkaSaslTokenFile"`
+
+	// TLS connects to the brokers with TLS, verified with the CA bundle in
+	// TLSCAFile or the system roots. TLSCertFile and TLSKeyFile hold the
+	// client certificate for mutual TLS.
+	TLS                   bool   `mapstructure:"kafkaTLS"`
+	TLSCAFile             string `mapstructure:"kafkaTLSCAFile"`
+	TLSCertFile           string `mapstructure:"kafkaTLSCertFile"`
+	TLSKeyFile            string `mapstructure:"kafkaTLSKeyFile"`
+	TLSServerName         string `mapstructure:"kafkaTLSServerName"`
+	TLSInsecureSkipVerify bool   `mapstructure:"kafkaTLSInsecureSkipVerify"`
+
+	// Version is the Kafka protocol version to speak, e.g. 3.6.0, the
+	// default of sarama when empty
+	Version string `mapstructure:"kafkaVersion"`
+
+	CloudEventsConfig    `mapstructure:",squash"`
+	SchemaRegistryConfig `mapstructure:",squash"`
+	OutputConfig         `mapstructure:",squash"`
+
+	// topic and key are the parsed templates, set by Validate
+	topic *template.Template
+	key   *template.Template
+}
+
+func defaultKafkaSinkConfig() *KafkaSinkConfig {
+	return &KafkaSinkConfig{
+		Brokers:  []string{"kafka:9092"},
+		Topic:    "eventrouter",
+		Async:    true,
+		RetryMax: 5,
+
+		RequiredAcks:  "all",
+		RequeueMax:    2,
+		Compression:   "none",
+		SaslMechanism: sarama.SASLTypePlaintext,
+
+		CloudEventsConfig:    defaultCloudEventsConfig(),
+		SchemaRegistryConfig: SchemaRegistryConfig{AutoRegister: true},
+	}
+}
+
+// Validate implements config.Validator
+func (c *KafkaSinkConfig) Validate() error {
+	var errs []error
+	if (c.SaslUser == "") != (c.SaslPwd == "") {
+		errs = append(errs, errors.New("kafkaSaslUser and kafkaSaslPwd must be set together"))
+	}
+	switch c.SaslMechanism {
+	case sarama.SASLTypeOAuth:
+		if c.SaslTokenFile == "" {
+			errs = append(errs, errors.New("kafkaSaslMechanism OAUTHBEARER needs kafkaSaslTokenFile"))
+		}
+	case sarama.SASLTypeSCRAMSHA256, sarama.SASLTypeSCRAMSHA512:
+		if c.SaslUser == "" {
+			errs = append(errs, fmt.Errorf("kafkaSaslMechanism %s needs kafkaSaslUser and kafkaSaslPwd", c.SaslMechanism))
+		}
+	}
+	if c.SaslTokenFile != "" && c.SaslMechanism != sarama.SASLTypeOAuth {
+		errs = append(errs, errors.New("kafkaSaslTokenFile needs the OAUTHBEARER kafkaSaslMechanism"))
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		errs = append(errs, errors.New("kafkaTLSCertFile and kafkaTLSKeyFile must be set together"))
+	}
+	if !c.TLS && (c.TLSCAFile != "" || c.TLSCertFile != "" || c.TLSServerName != "" || c.TLSInsecureSkipVerify) {
+		errs = append(errs, errors.New("kafkaTLS keys need kafkaTLS to be enabled"))
+	}
+	if c.Version != "" {
+		if _, err := sarama.ParseKafkaVersion(c.Version); err != nil {
+			errs = append(errs, fmt.Errorf("kafkaVersion: %w", err))
+		}
+	}
+	if c.Idempotent {
+		if c.RequiredAcks != "all" {
+			errs = append(errs, errors.New("kafkaIdempotent needs kafkaRequiredAcks all"))
+		}
+		if c.MaxInFlight > 1 {
+			errs = append(errs, errors.New("kafkaIdempotent needs kafkaMaxInFlight 1"))
+		}
+		if c.RetryMax < 1 {
+			errs = append(errs, errors.New("kafkaIdempotent needs kafkaRetryMax to be positive"))
+		}
+	}
+	if c.MaxInFlight < 0 || c.FlushFrequency < 0 || c.FlushBytes < 0 || c.RequeueMax < 0 {
+		errs = append(errs, errors.New("kafkaMaxInFlight, kafkaFlushFrequency, kafkaFlushBytes and kafkaRequeueMax must not be negative"))
+	}
+	if !c.Async && c.DeadLetterTopic != "" {
+		errs = append(errs, errors.New("kafkaDeadLetterTopic needs kafkaAsync"))
+	}
+	var err error
+	if c.topic, err = parseKafkaTemplate("kafkaTopic", c.Topic); err != nil {
+		errs = append(errs, err)
+	} else if c.SchemaRegistryConfig.URL != "" && c.Subject == "" && strings.Contains(c.Topic, "{{") {
+		errs = append(errs, errors.New("a kafkaTopic template needs schemaRegistrySubject"))
+	}
+	if c.KeyTemplate != "" {
+		if c.key, err = parseKafkaTemplate("kafkaKeyTemplate", c.KeyTemplate); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := c.OutputConfig.Validate(); err != nil {
+		errs = append(errs, err)
+	} else {
+		errs = append(errs, c.SchemaRegistryConfig.validate(c.Encoder()))
+	}
+	return errors.Join(errs...)
 }
 
 // NewKafkaSinkSink will create a new KafkaSink with default options, returned as an EventSinkInterface
 func NewKafkaSink(brokers []string, topic string, async bool, retryMax int, saslUser string, saslPwd string) (EventSinkInterface, error) {
+	c := defaultKafkaSinkConfig()
+	c.Brokers, c.Topic, c.Async, c.RetryMax = brokers, topic, async, retryMax
+	c.SaslUser, c.SaslPwd = saslUser, saslPwd
+	return newKafkaSink("kafka", c)
+}
 
-	p, err := sinkFactory(brokers, async, retryMax, saslUser, saslPwd)
-
+// newKafkaSink creates the KafkaSink named name producing to the brokers of c
+func newKafkaSink(name string, c *KafkaSinkConfig) (*KafkaSink, error) {
+	config, err := c.saramaConfig()
 	if err != nil {
 		return nil, err
 	}
-
+	p, err := sinkFactory(c.Brokers, c.Async, config)
+	if err != nil {
+		return nil, err
+	}
+	if async, ok := p.(sarama.AsyncProducer); ok {
+		p = newKafkaAsync(name, async, c.RequeueMax, config.Producer.Retry.Backoff, c.DeadLetterTopic)
+	}
 	return &KafkaSink{
-		Topic:    topic,
+		Topic:    c.Topic,
 		producer: p,
-	}, err
+		name:     name,
+	}, nil
+}
+
+// requiredAcks maps the values of kafkaRequiredAcks
+var requiredAcks = map[string]sarama.RequiredAcks{
+	"all":   sarama.WaitForAll,
+	"local": sarama.WaitForLocal,
+	"none":  sarama.NoResponse,
 }
 
-func sinkFactory(brokers []string, async bool, retryMax int, saslUser string, saslPwd string) (interface{}, error) {
+// saramaConfig returns the producer configuration of c, with the protocol
+// version, TLS and SASL settings applied
+func (c *KafkaSinkConfig) saramaConfig() (*sarama.Config, error) {
 	config := sarama.NewConfig()
-	config.Producer.Retry.Max = retryMax
-	config.Producer.RequiredAcks = sarama.WaitForAll
+	config.Producer.Retry.Max = c.RetryMax
+	config.Producer.RequiredAcks = requiredAcks[c.RequiredAcks]
+	config.Producer.Idempotent = c.Idempotent
+	if c.Idempotent {
+		config.Net.MaxOpenRequests = 1
+	}
+	if c.MaxInFlight > 0 {
+		config.Net.MaxOpenRequests = c.MaxInFlight
+	}
+	config.Producer.Flush.Frequency = c.FlushFrequency
+	config.Producer.Flush.Bytes = c.FlushBytes
+	// The async producer counts the delivered messages
+	config.Producer.Return.Successes = true
+	if err := config.Producer.Compression.UnmarshalText([]byte(c.Compression)); err != nil {
+		return nil, fmt.Errorf("kafkaCompression: %w", err)
+	}
+
+	if c.Version != "" {
+		version, err := sarama.ParseKafkaVersion(c.Version)
+		if err != nil {
+			return nil, err
+		}
+		config.Version = version
+	}
 
-	if saslUser != "" && saslPwd != "" {
+	if c.TLS {
+		tlsConfig, err := newTLSConfig(c.TLSCAFile, c.TLSCertFile, c.TLSKeyFile, c.TLSServerName, c.TLSInsecureSkipVerify)
+		if err != nil {
+			return nil, err
+		}
+		config.Net.TLS.Enable = true
+		config.Net.TLS.Config = tlsConfig
+	}
+
+	switch c.SaslMechanism {
+	case sarama.SASLTypeOAuth:
+		config.Net.SASL.Enable = true
+		config.Net.SASL.Mechanism = sarama.SASLTypeOAuth
+		config.Net.SASL.TokenProvider = tokenFileProvider{path: c.SaslTokenFile}
+	case sarama.SASLTypeSCRAMSHA256, sarama.SASLTypeSCRAMSHA512:
 		config.Net.SASL.Enable = true
-		config.Net.SASL.User = saslUser
-		config.Net.SASL.Password = saslPwd
+		config.Net.SASL.Mechanism = sarama.SASLMechanism(c.SaslMechanism)
+		config.Net.SASL.User = c.SaslUser
+		config.Net.SASL.Password = c.SaslPwd
+		hash := scram.SHA256
+		if c.SaslMechanism == sarama.SASLTypeSCRAMSHA512 {
+			hash = scram.SHA512
+		}
+		config.Net.SASL.SCRAMClientGeneratorFunc = newSCRAMClientGenerator(hash)
+	default:
+		if c.SaslUser != "" && c.SaslPwd != "" {
+			config.Net.SASL.Enable = true
+			config.Net.SASL.User = c.SaslUser
+			config.Net.SASL.Password = c.SaslPwd
+		}
 	}
+	return config, nil
+}
 
+func sinkFactory(brokers []string, async bool, config *sarama.Config) (interface{}, error) {
 	if async {
 		return sarama.NewAsyncProducer(brokers, config)
 	}
 
-	config.Producer.Return.Successes = true
 	return sarama.NewSyncProducer(brokers, config)
 
 }
@@ -68,18 +350,11 @@ func sinkFactory(brokers []string, async bool, retryMax int, saslUser string, sa
 // UpdateEvents implements EventSinkInterface.UpdateEvents
 func (ks *KafkaSink) UpdateEvents(eNew *v1.Event, eOld *v1.Event) {
 
-	eData := NewEventData(eNew, eOld)
-
-	eJSONBytes, err := json.Marshal(eData)
+	msg, err := ks.message(eNew, eOld)
 	if err != nil {
-		glog.Errorf("Failed to json serialize event: %v", err)
+		glog.Errorf("Failed to serialize event: %v", err)
 		return
 	}
-	msg := &sarama.ProducerMessage{
-		Topic: ks.Topic,
-		Key:   sarama.StringEncoder(eNew.InvolvedObject.Name),
-		Value: sarama.ByteEncoder(eJSONBytes),
-	}
 
 	switch p := ks.producer.(type) {
 	case sarama.SyncProducer:
@@ -87,17 +362,166 @@ func (ks *KafkaSink) UpdateEvents(eNew *v1.Event, eOld *v1.Event) {
 		if err != nil {
 			glog.Errorf("Failed to send to: topic(%s)/partition(%d)/offset(%d)\n",
 				ks.Topic, partition, offset)
+			reportFailure(ks.name, err)
+		} else {
+			reportSuccess(ks.name)
 		}
 
-	case sarama.AsyncProducer:
-		select {
-		case p.Input() <- msg:
-		case err := <-p.Errors():
-			glog.Errorf("Failed to produce message: %v", err)
-		}
+	case *kafkaAsync:
+		p.send(msg)
 
 	default:
 		glog.Errorf("Unhandled producer type: %s", p)
 	}
 
 }
+
+// close flushes the messages buffered by the producer and closes it
+func (ks *KafkaSink) close() {
+	switch p := ks.producer.(type) {
+	case sarama.SyncProducer:
+		if err := p.Close(); err != nil {
+			glog.Errorf("Failed to close the Kafka producer: %v", err)
+		}
+	case *kafkaAsync:
+		p.close()
+	}
+}
+
+// message builds the Kafka message of an event. CloudEvents are written in
+// the structured or the binary content mode of the Kafka protocol binding.
+func (ks *KafkaSink) message(eNew *v1.Event, eOld *v1.Event) (*sarama.ProducerMessage, error) {
+	eData := ks.eventData(eNew, eOld)
+	topic, err := ks.topicOf(eNew)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ks.keyOf(eNew)
+	if err != nil {
+		return nil, err
+	}
+	msg := &sarama.ProducerMessage{Topic: topic, Key: key}
+	if ks.cloudEvents == nil {
+		value, err := ks.encode(&eData)
+		if err != nil {
+			return nil, err
+		}
+		msg.Value = sarama.ByteEncoder(ks.frame(value))
+		msg.Headers = ks.eventHeaders(eNew)
+		return msg, nil
+	}
+
+	ce, err := ks.cloudEvents.event(&ks.eventShaper, &eData)
+	if err != nil {
+		return nil, err
+	}
+	if !ks.cloudEvents.binary {
+		value, err := ce.structured()
+		if err != nil {
+			return nil, err
+		}
+		msg.Value = sarama.ByteEncoder(value)
+		msg.Headers = []sarama.RecordHeader{{Key: []byte("content-type"), Value: []byte(cloudEventsContentType)}}
+		msg.Headers = append(msg.Headers, ks.eventHeaders(eNew)...)
+		return msg, nil
+	}
+
+	msg.Value = sarama.ByteEncoder(ks.frame(ce.payload))
+	headers := ce.headers("ce_")
+	headers["content-type"] = ce.DataContentType
+	keys := make([]string, 0, len(headers))
+	for k := range headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		msg.Headers = append(msg.Headers, sarama.RecordHeader{Key: []byte(k), Value: []byte(headers[k])})
+	}
+	msg.Headers = append(msg.Headers, ks.eventHeaders(eNew)...)
+	return msg, nil
+}
+
+// parseKafkaTemplate parses the template of the config key name and checks
+// it can be executed on an event
+func parseKafkaTemplate(name string, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if err := tmpl.Execute(io.Discard, &v1.Event{}); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return tmpl, nil
+}
+
+// maxTopicLength is the longest topic name Kafka accepts
+const maxTopicLength = 249
+
+// topicOf returns the topic of the message of e. Characters Kafka does not
+// allow in topic names are replaced with underscores.
+func (ks *KafkaSink) topicOf(e *v1.Event) (string, error) {
+	if ks.topic == nil {
+		return ks.Topic, nil
+	}
+	var b strings.Builder
+	if err := ks.topic.Execute(&b, e); err != nil {
+		return "", fmt.Errorf("failed to render kafkaTopic: %w", err)
+	}
+	topic := strings.Map(func(r rune) rune {
+		if r == '.' || r == '_' || r == '-' || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') {
+			return r
+		}
+		return '_'
+	}, b.String())
+	if topic == "" || topic == "." || topic == ".." {
+		return "", fmt.Errorf("kafkaTopic rendered the invalid topic %q", topic)
+	}
+	if len(topic) > maxTopicLength {
+		topic = topic[:maxTopicLength]
+	}
+	return topic, nil
+}
+
+// keyOf returns the key of the message of e, no key when it renders empty
+func (ks *KafkaSink) keyOf(e *v1.Event) (sarama.Encoder, error) {
+	if ks.key == nil {
+		return sarama.StringEncoder(e.InvolvedObject.Name), nil
+	}
+	var b strings.Builder
+	if err := ks.key.Execute(&b, e); err != nil {
+		return nil, fmt.Errorf("failed to render kafkaKeyTemplate: %w", err)
+	}
+	if b.Len() == 0 {
+		return nil, nil
+	}
+	return sarama.StringEncoder(b.String()), nil
+}
+
+// eventHeaders returns the record headers describing e when they are
+// enabled, leaving out empty values
+func (ks *KafkaSink) eventHeaders(e *v1.Event) []sarama.RecordHeader {
+	if !ks.headers {
+		return nil
+	}
+	var headers []sarama.RecordHeader
+	for _, h := range [][2]string{
+		{"type", e.Type},
+		{"reason", e.Reason},
+		{"namespace", e.Namespace},
+		{"cluster", ks.clusterID},
+	} {
+		if h[1] != "" {
+			headers = append(headers, sarama.RecordHeader{Key: []byte(h[0]), Value: []byte(h[1])})
+		}
+	}
+	return headers
+}
+
+// frame returns record in the Confluent wire format when a schema registry
+// is configured
+func (ks *KafkaSink) frame(record []byte) []byte {
+	if ks.schemaEnc == nil {
+		return record
+	}
+	return confluentWireFormat(ks.schemaID, ks.schemaEnc, record)
+}
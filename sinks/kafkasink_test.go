@@ -1,12 +1,22 @@
 package sinks
 
 import (
+	"crypto/tls"
 	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/IBM/sarama"
 	"github.com/IBM/sarama/mocks"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
 	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 func TestKafkaSink_UpdateEvents_SyncProducer(t *testing.T) {
@@ -48,15 +58,13 @@ func TestKafkaSink_UpdateEvents_SyncProducer(t *testing.T) {
 func TestKafkaSink_UpdateEvents_AsyncProducer(t *testing.T) {
 	// Set up the mock async producer
 	mockProducer := mocks.NewAsyncProducer(t, nil)
-	defer func() {
-		require.NoError(t, mockProducer.Close(), "mockProducer.Close() error")
-	}()
 
 	// Create a KafkaSink with the mock producer
 	kafkaSink := &KafkaSink{
 		Topic:    "test-topic",
-		producer: mockProducer,
+		producer: newKafkaAsync("kafka", mockProducer, 0, 0, ""),
 	}
+	defer kafkaSink.close()
 
 	// Define events
 	eNew := &v1.Event{
@@ -80,3 +88,375 @@ func TestKafkaSink_UpdateEvents_AsyncProducer(t *testing.T) {
 	// Call the method under test
 	kafkaSink.UpdateEvents(eNew, eOld)
 }
+
+// newTestKafkaBroker starts a sarama mock broker on listener, leading the
+// partition of topic and accepting SASL authentication with mechanism
+func newTestKafkaBroker(t *testing.T, listener net.Listener, topic string, mechanism string) *sarama.MockBroker {
+	b := sarama.NewMockBrokerListener(t, 1, listener)
+	t.Cleanup(b.Close)
+	b.SetHandlerByMap(map[string]sarama.MockResponse{
+		"ApiVersionsRequest": sarama.NewMockApiVersionsResponse(t),
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(b.Addr(), b.BrokerID()).
+			SetLeader(topic, 0, b.BrokerID()),
+		"InitProducerIDRequest":   sarama.NewMockInitProducerIDResponse(t),
+		"ProduceRequest":          sarama.NewMockProduceResponse(t),
+		"SaslHandshakeRequest":    sarama.NewMockSaslHandshakeResponse(t).SetEnabledMechanisms([]string{mechanism}),
+		"SaslAuthenticateRequest": sarama.NewMockSaslAuthenticateResponse(t),
+	})
+	return b
+}
+
+func TestKafkaSink_mockBroker(t *testing.T) {
+	certs := newTestCerts(t, "kafka.test")
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("token-1\n"), 0o600))
+
+	tests := []struct {
+		name      string
+		tls       bool
+		configure func(c *KafkaSinkConfig)
+		mechanism string
+		auth      string
+	}{
+		{
+			name:      "plaintext",
+			configure: func(c *KafkaSinkConfig) {},
+		},
+		{
+			name: "sasl plain",
+			configure: func(c *KafkaSinkConfig) {
+				c.SaslUser, c.SaslPwd = "user", "pwd"
+			},
+			mechanism: sarama.SASLTypePlaintext,
+			auth:      "\x00user\x00pwd",
+		},
+		{
+			name: "oauthbearer",
+			configure: func(c *KafkaSinkConfig) {
+				c.SaslMechanism, c.SaslTokenFile = sarama.SASLTypeOAuth, tokenFile
+			},
+			mechanism: sarama.SASLTypeOAuth,
+			auth:      "n,,\x01auth=Bearer token-1\x01\x01",
+		},
+		{
+			name: "mutual tls",
+			tls:  true,
+			configure: func(c *KafkaSinkConfig) {
+				c.TLS = true
+				c.TLSCAFile, c.TLSCertFile, c.TLSKeyFile = certs.caFile, certs.certFile, certs.keyFile
+				c.TLSServerName = "kafka.test"
+			},
+		},
+		{
+			name: "tls with sasl plain",
+			tls:  true,
+			configure: func(c *KafkaSinkConfig) {
+				c.TLS = true
+				c.TLSCAFile, c.TLSCertFile, c.TLSKeyFile = certs.caFile, certs.certFile, certs.keyFile
+				c.TLSServerName = "kafka.test"
+				c.SaslUser, c.SaslPwd = "user", "pwd"
+			},
+			mechanism: sarama.SASLTypePlaintext,
+			auth:      "\x00user\x00pwd",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var listener net.Listener
+			var err error
+			if tt.tls {
+				listener, err = tls.Listen("tcp", "127.0.0.1:0", certs.serverConfig())
+			} else {
+				listener, err = net.Listen("tcp", "127.0.0.1:0")
+			}
+			require.NoError(t, err)
+			broker := newTestKafkaBroker(t, listener, "events", tt.mechanism)
+
+			c := defaultKafkaSinkConfig()
+			c.Brokers, c.Topic, c.Async = []string{broker.Addr()}, "events", false
+			tt.configure(c)
+			require.NoError(t, c.Validate())
+			ks, err := newKafkaSink("kafka", c)
+			require.NoError(t, err)
+			defer func() {
+				require.NoError(t, ks.producer.(sarama.SyncProducer).Close())
+			}()
+			ks.UpdateEvents(&v1.Event{InvolvedObject: v1.ObjectReference{Name: "web-1"}}, nil)
+
+			var auth []string
+			produced := 0
+			for _, rr := range broker.History() {
+				switch req := rr.Request.(type) {
+				case *sarama.SaslAuthenticateRequest:
+					auth = append(auth, string(req.SaslAuthBytes))
+				case *sarama.ProduceRequest:
+					produced++
+				}
+			}
+			require.Equal(t, 1, produced)
+			if tt.auth == "" {
+				require.Empty(t, auth)
+			} else {
+				require.NotEmpty(t, auth)
+				for _, a := range auth {
+					require.Equal(t, tt.auth, a)
+				}
+			}
+		})
+	}
+}
+
+func TestKafkaSink_tlsUntrusted(t *testing.T) {
+	certs := newTestCerts(t, "kafka.test")
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", certs.serverConfig())
+	require.NoError(t, err)
+	broker := newTestKafkaBroker(t, listener, "events", "")
+
+	// Without the CA bundle the broker certificate is not trusted
+	c := defaultKafkaSinkConfig()
+	c.Brokers, c.Topic, c.Async, c.RetryMax = []string{broker.Addr()}, "events", false, 0
+	c.TLS, c.TLSServerName = true, "kafka.test"
+	c.TLSCertFile, c.TLSKeyFile = certs.certFile, certs.keyFile
+	_, err = newKafkaSink("kafka", c)
+	require.Error(t, err)
+}
+
+func TestKafkaSinkConfig_saramaConfig(t *testing.T) {
+	c := defaultKafkaSinkConfig()
+	c.Version = "3.6.0"
+	c.SaslMechanism, c.SaslUser, c.SaslPwd = sarama.SASLTypeSCRAMSHA512, "user", "pwd"
+	c.Compression = "zstd"
+	require.NoError(t, c.Validate())
+	config, err := c.saramaConfig()
+	require.NoError(t, err)
+	require.Equal(t, sarama.V3_6_0_0, config.Version)
+	require.Equal(t, sarama.CompressionZSTD, config.Producer.Compression)
+	require.True(t, config.Net.SASL.Enable)
+	require.Equal(t, sarama.SASLMechanism(sarama.SASLTypeSCRAMSHA512), config.Net.SASL.Mechanism)
+	require.IsType(t, &scramClient{}, config.Net.SASL.SCRAMClientGeneratorFunc())
+	require.NoError(t, config.Validate())
+}
+
+func TestKafkaSink_message(t *testing.T) {
+	event := &v1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Name: "web-1.17b", Namespace: "shop"},
+		InvolvedObject: v1.ObjectReference{Kind: "Pod", Namespace: "shop", Name: "web-1"},
+		Reason:         "BackOff",
+		Type:           "Warning",
+	}
+	tests := []struct {
+		name    string
+		config  func(c *KafkaSinkConfig)
+		event   *v1.Event
+		topic   string
+		key     sarama.Encoder
+		headers map[string]string
+	}{
+		{
+			name:   "defaults",
+			config: func(c *KafkaSinkConfig) {},
+			event:  event,
+			topic:  "eventrouter",
+			key:    sarama.StringEncoder("web-1"),
+		},
+		{
+			name: "templates",
+			config: func(c *KafkaSinkConfig) {
+				c.Topic = "events.{{.Namespace}}.{{.Type | lower}}"
+				c.KeyTemplate = "{{.InvolvedObject.Namespace}}/{{.InvolvedObject.Name}}"
+			},
+			event: event,
+			topic: "events.shop.warning",
+			key:   sarama.StringEncoder("shop/web-1"),
+		},
+		{
+			name: "invalid topic characters",
+			config: func(c *KafkaSinkConfig) {
+				c.Topic = "events/{{.InvolvedObject.Kind}}:{{.Reason}}"
+				c.KeyTemplate = "{{.Source.Host}}"
+			},
+			event: event,
+			topic: "events_Pod_BackOff",
+		},
+		{
+			name: "headers",
+			config: func(c *KafkaSinkConfig) {
+				c.Headers = true
+			},
+			event:   event,
+			topic:   "eventrouter",
+			key:     sarama.StringEncoder("web-1"),
+			headers: map[string]string{"type": "Warning", "reason": "BackOff", "namespace": "shop", "cluster": "prod"},
+		},
+		{
+			name: "headers with cloud events",
+			config: func(c *KafkaSinkConfig) {
+				c.Headers = true
+				c.Mode = "binary"
+			},
+			event: &v1.Event{InvolvedObject: v1.ObjectReference{Name: "node-1"}, Reason: "NodeReady", Type: "Normal"},
+			topic: "eventrouter",
+			key:   sarama.StringEncoder("node-1"),
+			headers: map[string]string{
+				"type": "Normal", "reason": "NodeReady", "cluster": "prod",
+				"content-type": "application/json", "ce_specversion": "1.0", "ce_type": "io.k8s.event.added.NodeReady",
+				"ce_source": "/clusters/prod/components/unknown",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := defaultKafkaSinkConfig()
+			tt.config(c)
+			require.NoError(t, c.Validate())
+			ks := &KafkaSink{Topic: c.Topic, topic: c.topic, key: c.key, headers: c.Headers, cloudEvents: c.CloudEvents()}
+			ks.configure(&c.OutputConfig)
+			ks.setClusterID("prod")
+
+			msg, err := ks.message(tt.event, nil)
+			require.NoError(t, err)
+			require.Equal(t, tt.topic, msg.Topic)
+			require.Equal(t, tt.key, msg.Key)
+			headers := map[string]string{}
+			for _, h := range msg.Headers {
+				headers[string(h.Key)] = string(h.Value)
+			}
+			for k, v := range tt.headers {
+				require.Equal(t, v, headers[k], k)
+			}
+			if tt.headers == nil {
+				require.Empty(t, msg.Headers)
+			}
+		})
+	}
+}
+
+func TestKafkaSink_topicTooLong(t *testing.T) {
+	ks := &KafkaSink{}
+	var err error
+	ks.topic, err = parseKafkaTemplate("kafkaTopic", "{{.Message}}")
+	require.NoError(t, err)
+
+	topic, err := ks.topicOf(&v1.Event{Message: strings.Repeat("a", 300)})
+	require.NoError(t, err)
+	require.Len(t, topic, maxTopicLength)
+
+	_, err = ks.topicOf(&v1.Event{Message: ".."})
+	require.EqualError(t, err, `kafkaTopic rendered the invalid topic ".."`)
+}
+
+func TestKafkaAsync_requeueAndDeadLetter(t *testing.T) {
+	config := mocks.NewTestConfig()
+	config.Producer.Return.Successes = true
+	mockProducer := mocks.NewAsyncProducer(t, config)
+	errBroker := errors.New("broker down")
+	mockProducer.ExpectInputAndFail(errBroker)
+	mockProducer.ExpectInputAndFail(errBroker)
+	mockProducer.ExpectInputWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		require.Equal(t, "events-dead", msg.Topic)
+		require.Equal(t, []sarama.RecordHeader{{Key: []byte("original-topic"), Value: []byte("events")}}, msg.Headers)
+		return nil
+	})
+	mockProducer.ExpectInputAndSucceed()
+
+	requeued := testutil.ToFloat64(KafkaMessagesTotal.WithLabelValues("requeued"))
+	deadLettered := testutil.ToFloat64(KafkaMessagesTotal.WithLabelValues("dead_lettered"))
+	delivered := testutil.ToFloat64(KafkaMessagesTotal.WithLabelValues("delivered"))
+
+	a := newKafkaAsync("kafka", mockProducer, 1, time.Millisecond, "events-dead")
+	a.send(&sarama.ProducerMessage{Topic: "events", Value: sarama.StringEncoder("1")})
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(KafkaMessagesTotal.WithLabelValues("delivered")) == delivered+1
+	}, time.Second, time.Millisecond)
+	a.send(&sarama.ProducerMessage{Topic: "events", Value: sarama.StringEncoder("2")})
+	a.close()
+
+	require.Equal(t, requeued+1, testutil.ToFloat64(KafkaMessagesTotal.WithLabelValues("requeued")))
+	require.Equal(t, deadLettered+1, testutil.ToFloat64(KafkaMessagesTotal.WithLabelValues("dead_lettered")))
+	require.Equal(t, delivered+2, testutil.ToFloat64(KafkaMessagesTotal.WithLabelValues("delivered")))
+
+	// Messages sent after close are dropped instead of panicking
+	dropped := testutil.ToFloat64(KafkaMessagesTotal.WithLabelValues("dropped"))
+	a.send(&sarama.ProducerMessage{Topic: "events"})
+	require.Equal(t, dropped+1, testutil.ToFloat64(KafkaMessagesTotal.WithLabelValues("dropped")))
+}
+
+// stuckProducer never accepts a message
+type stuckProducer struct {
+	sarama.AsyncProducer
+	successes chan *sarama.ProducerMessage
+	errors    chan *sarama.ProducerError
+}
+
+func (p *stuckProducer) Input() chan<- *sarama.ProducerMessage { return nil }
+
+func (p *stuckProducer) Successes() <-chan *sarama.ProducerMessage { return p.successes }
+
+func (p *stuckProducer) Errors() <-chan *sarama.ProducerError { return p.errors }
+
+func (p *stuckProducer) AsyncClose() {
+	close(p.successes)
+	close(p.errors)
+}
+
+func TestKafkaAsync_closeReleasesBlockedSend(t *testing.T) {
+	p := &stuckProducer{
+		successes: make(chan *sarama.ProducerMessage),
+		errors:    make(chan *sarama.ProducerError),
+	}
+	a := newKafkaAsync("kafka", p, 0, 0, "")
+
+	dropped := testutil.ToFloat64(KafkaMessagesTotal.WithLabelValues("dropped"))
+	sent := make(chan struct{})
+	go func() {
+		a.send(&sarama.ProducerMessage{Topic: "events"})
+		close(sent)
+	}()
+	require.Never(t, func() bool {
+		select {
+		case <-sent:
+			return true
+		default:
+			return false
+		}
+	}, 50*time.Millisecond, time.Millisecond)
+
+	a.close()
+	<-sent
+	require.Equal(t, dropped+1, testutil.ToFloat64(KafkaMessagesTotal.WithLabelValues("dropped")))
+}
+
+func TestKafkaSink_flushOnClose(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	broker := newTestKafkaBroker(t, listener, "events", "")
+
+	// Batches are sent when they hold a megabyte or every 500ms
+	c := defaultKafkaSinkConfig()
+	c.Brokers, c.Topic = []string{broker.Addr()}, "events"
+	c.Idempotent, c.RequiredAcks = true, "all"
+	c.FlushFrequency, c.FlushBytes = 500*time.Millisecond, 1<<20
+	require.NoError(t, c.Validate())
+	ks, err := newKafkaSink("kafka", c)
+	require.NoError(t, err)
+	config, err := c.saramaConfig()
+	require.NoError(t, err)
+	require.Equal(t, 1, config.Net.MaxOpenRequests)
+
+	delivered := testutil.ToFloat64(KafkaMessagesTotal.WithLabelValues("delivered"))
+	for i := 0; i < 3; i++ {
+		ks.UpdateEvents(&v1.Event{InvolvedObject: v1.ObjectReference{Name: "web-1"}}, nil)
+	}
+	ks.close()
+
+	// Closing waited for the batch holding every record
+	var produced int
+	for _, rr := range broker.History() {
+		if _, ok := rr.Request.(*sarama.ProduceRequest); ok {
+			produced++
+		}
+	}
+	require.Equal(t, 1, produced)
+	require.Equal(t, delivered+3, testutil.ToFloat64(KafkaMessagesTotal.WithLabelValues("delivered")))
+}
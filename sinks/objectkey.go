@@ -0,0 +1,148 @@
+/*
+Copyright 2017 The Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sinks
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// objectKey is the data of the templates naming the objects written by the
+// archive sinks. The time fields are those of the event, in UTC, and are
+// zero-padded so the keys sort in time order.
+type objectKey struct {
+	// Dir is the configured directory of the objects
+	Dir string
+
+	Year  string
+	Month string
+	Day   string
+	Hour  string
+	Time  time.Time
+
+	// Namespace is the namespace of the involved object
+	Namespace string
+
+	// Cluster is the cluster ID, Hostname the pod name of eventrouter and UUID
+	// is random for every upload, they avoid collisions between replicas
+	Cluster  string
+	Hostname string
+	UUID     string
+
+	// Ext is the file extension of the output format, with its dot
+	Ext string
+}
+
+// withEvent returns k with the time and the namespace of e
+func (k objectKey) withEvent(e *EventData) objectKey {
+	t := eventTime(e).UTC()
+	k.Year, k.Month, k.Day, k.Hour = t.Format("2006"), t.Format("01"), t.Format("02"), t.Format("15")
+	k.Time = t
+	k.Namespace = e.Event.InvolvedObject.Namespace
+	if k.Namespace == "" {
+		k.Namespace = e.Event.Namespace
+	}
+	return k
+}
+
+// keyTemplateUUID is the UUID of the dry run of the key templates
+const keyTemplateUUID = "00000000-0000-0000-0000-000000000000"
+
+// parseKeyTemplate parses the object key template of the configuration key
+// name. The keys must hold the UUID, or every upload would overwrite the
+// previous one with the same key.
+func parseKeyTemplate(name string, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, objectKey{UUID: keyTemplateUUID}); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if !strings.Contains(b.String(), keyTemplateUUID) {
+		return nil, fmt.Errorf("%s must use {{.UUID}} so the uploads do not overwrite each other", name)
+	}
+	return tmpl, nil
+}
+
+// objectBatch holds the events written to the object named key
+type objectBatch struct {
+	key    string
+	events []EventData
+}
+
+// splitObjects names the objects holding events with tmpl. The key of the
+// first event names the whole batch, unless split is set: then every event
+// goes to the object named by its own key, in the order they first appear.
+func splitObjects(tmpl *template.Template, base objectKey, events []EventData, split bool) ([]objectBatch, error) {
+	var batches []objectBatch
+	index := map[string]int{}
+	for i := range events {
+		if !split && i > 0 {
+			batches[0].events = events
+			break
+		}
+		key, err := renderKey(tmpl, base.withEvent(&events[i]))
+		if err != nil {
+			return nil, err
+		}
+		if j, ok := index[key]; ok {
+			batches[j].events = append(batches[j].events, events[i])
+			continue
+		}
+		index[key] = len(batches)
+		batches = append(batches, objectBatch{key: key, events: []EventData{events[i]}})
+	}
+	return batches, nil
+}
+
+func renderKey(tmpl *template.Template, k objectKey) (string, error) {
+	var b strings.Builder
+	if err := tmpl.Execute(&b, k); err != nil {
+		return "", fmt.Errorf("failed to render %s: %w", tmpl.Name(), err)
+	}
+	key := strings.TrimLeft(b.String(), "/")
+	if key == "" {
+		return "", fmt.Errorf("%s rendered an empty key", tmpl.Name())
+	}
+	return key, nil
+}
+
+// fileExtension returns the extension of the files written by enc
+func fileExtension(enc Encoder) string {
+	ct, _, _ := strings.Cut(enc.ContentType(), ";")
+	switch ct {
+	case "application/json":
+		return ".json"
+	case "application/x-ndjson":
+		return ".ndjson"
+	case "application/logplex-1", "text/plain":
+		return ".log"
+	case "text/csv":
+		return ".csv"
+	case "application/x-protobuf":
+		return ".pb"
+	case "application/avro":
+		return ".avro"
+	case "application/vnd.apache.parquet":
+		return ".parquet"
+	}
+	return ".txt"
+}
@@ -0,0 +1,96 @@
+package sinks
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSplitObjects(t *testing.T) {
+	event := func(namespace string, ts string) EventData {
+		last, err := time.Parse(time.RFC3339, ts)
+		require.NoError(t, err)
+		return NewEventData(&v1.Event{
+			InvolvedObject: v1.ObjectReference{Namespace: namespace},
+			LastTimestamp:  metav1.NewTime(last),
+		}, nil)
+	}
+	events := []EventData{
+		event("default", "2024-03-07T09:59:59+01:00"),
+		event("kube-system", "2024-03-07T10:00:00Z"),
+		event("default", "2024-03-08T00:00:00Z"),
+	}
+	base := objectKey{Dir: "prod", Cluster: "c1", Hostname: "eventrouter-0", UUID: "u", Ext: ".ndjson"}
+
+	testCases := []struct {
+		name     string
+		template string
+		split    bool
+		want     map[string]int
+	}{
+		{
+			"hive partitions",
+			"{{.Dir}}/year={{.Year}}/month={{.Month}}/day={{.Day}}/hour={{.Hour}}/{{.Hostname}}-{{.UUID}}{{.Ext}}",
+			false,
+			map[string]int{"prod/year=2024/month=03/day=07/hour=08/eventrouter-0-u.ndjson": 3},
+		},
+		{
+			"split by namespace",
+			"{{.Dir}}/cluster={{.Cluster}}/namespace={{.Namespace}}/{{.UUID}}{{.Ext}}",
+			true,
+			map[string]int{"prod/cluster=c1/namespace=default/u.ndjson": 2, "prod/cluster=c1/namespace=kube-system/u.ndjson": 1},
+		},
+		{
+			"split by date",
+			`{{.Time.Format "2006-01-02"}}/{{.UUID}}`,
+			true,
+			map[string]int{"2024-03-07/u": 2, "2024-03-08/u": 1},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tmpl, err := parseKeyTemplate("s3SinkKeyTemplate", tc.template)
+			require.NoError(t, err)
+			batches, err := splitObjects(tmpl, base, events, tc.split)
+			require.NoError(t, err)
+			got := map[string]int{}
+			for _, b := range batches {
+				got[b.key] = len(b.events)
+			}
+			require.Equal(t, tc.want, got)
+		})
+	}
+
+	tmpl, err := parseKeyTemplate("s3SinkKeyTemplate", "{{.Namespace}}/{{.UUID}}")
+	require.NoError(t, err)
+	_, err = splitObjects(tmpl, objectKey{}, []EventData{event("", "2024-03-07T10:00:00Z")}, true)
+	require.EqualError(t, err, "s3SinkKeyTemplate rendered an empty key")
+
+	_, err = parseKeyTemplate("s3SinkKeyTemplate", "{{.Bucket}}")
+	require.ErrorContains(t, err, "s3SinkKeyTemplate: template: s3SinkKeyTemplate:1:2: executing")
+
+	// Without the UUID every upload of a namespace and hostname would
+	// overwrite the previous one
+	for _, text := range []string{"{{.Dir}}/{{.Namespace}}/{{.Hostname}}{{.Ext}}", "{{if .Cluster}}{{.UUID}}{{end}}"} {
+		_, err = parseKeyTemplate("s3SinkKeyTemplate", text)
+		require.EqualError(t, err, "s3SinkKeyTemplate must use {{.UUID}} so the uploads do not overwrite each other")
+	}
+}
+
+func TestFileExtension(t *testing.T) {
+	for format, want := range map[string]string{
+		"json":     ".json",
+		"ndjson":   ".ndjson",
+		"flatjson": ".ndjson",
+		"rfc5424":  ".log",
+		"logfmt":   ".log",
+		"csv":      ".csv",
+	} {
+		enc, err := NewEncoder(&OutputConfig{Format: format})
+		require.NoError(t, err)
+		require.Equal(t, want, fileExtension(enc), format)
+	}
+}
@@ -0,0 +1,189 @@
+/*
+Copyright 2017 The Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sinks
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress"
+)
+
+func init() {
+	RegisterEncoder("parquet", func(c *OutputConfig) (Encoder, error) {
+		if c.Transform() != nil {
+			return nil, fmt.Errorf("transform keys cannot be used with the parquet outputFormat, its schema is fixed")
+		}
+		return parquetEncoder{codec: &parquet.Snappy}, nil
+	})
+}
+
+// parquetRow is the EventData written by the parquet format, flattened into
+// the columns of the schema record. Columns are only ever added, so the
+// files of every version can be queried as one table. The old event of
+// updates only keeps the columns which change between them.
+type parquetRow struct {
+	Verb string `parquet:"verb"`
+
+	EventName                     string            `parquet:"event_name"`
+	EventNamespace                string            `parquet:"event_namespace"`
+	EventUID                      string            `parquet:"event_uid"`
+	EventResourceVersion          string            `parquet:"event_resource_version"`
+	EventLabels                   map[string]string `parquet:"event_labels"`
+	EventAnnotations              map[string]string `parquet:"event_annotations"`
+	EventInvolvedObjectKind       string            `parquet:"event_involved_object_kind"`
+	EventInvolvedObjectNamespace  string            `parquet:"event_involved_object_namespace"`
+	EventInvolvedObjectName       string            `parquet:"event_involved_object_name"`
+	EventInvolvedObjectUID        string            `parquet:"event_involved_object_uid"`
+	EventInvolvedObjectAPIVersion string            `parquet:"event_involved_object_api_version"`
+	EventInvolvedObjectFieldPath  string            `parquet:"event_involved_object_field_path"`
+	EventReason                   string            `parquet:"event_reason"`
+	EventMessage                  string            `parquet:"event_message"`
+	EventType                     string            `parquet:"event_type"`
+	EventSourceComponent          string            `parquet:"event_source_component"`
+	EventSourceHost               string            `parquet:"event_source_host"`
+	EventFirstTimestamp           int64             `parquet:"event_first_timestamp,optional,timestamp(millisecond)"`
+	EventLastTimestamp            int64             `parquet:"event_last_timestamp,optional,timestamp(millisecond)"`
+	EventEventTime                int64             `parquet:"event_event_time,optional,timestamp(millisecond)"`
+	EventCount                    int32             `parquet:"event_count"`
+	EventAction                   string            `parquet:"event_action"`
+	EventReportingController      string            `parquet:"event_reporting_controller"`
+	EventReportingInstance        string            `parquet:"event_reporting_instance"`
+	EventRelatedKind              *string           `parquet:"event_related_kind,optional"`
+	EventRelatedNamespace         *string           `parquet:"event_related_namespace,optional"`
+	EventRelatedName              *string           `parquet:"event_related_name,optional"`
+	OldEventResourceVersion       *string           `parquet:"old_event_resource_version,optional"`
+	OldEventMessage               *string           `parquet:"old_event_message,optional"`
+	OldEventCount                 *int32            `parquet:"old_event_count,optional"`
+	OldEventLastTimestamp         int64             `parquet:"old_event_last_timestamp,optional,timestamp(millisecond)"`
+	ClusterID                     string            `parquet:"cluster_id"`
+	InstanceID                    string            `parquet:"instance_id"`
+	Sequence                      int64             `parquet:"sequence"`
+	ProcessedAt                   int64             `parquet:"processed_at,optional,timestamp(millisecond)"`
+}
+
+// newParquetRow flattens the schema record of e
+func newParquetRow(e *EventData) parquetRow {
+	r := newSchemaRecord(e)
+	row := parquetRow{
+		Verb:                          r.Verb,
+		EventName:                     r.Event.Name,
+		EventNamespace:                r.Event.Namespace,
+		EventUID:                      r.Event.UID,
+		EventResourceVersion:          r.Event.ResourceVersion,
+		EventLabels:                   r.Event.Labels,
+		EventAnnotations:              r.Event.Annotations,
+		EventInvolvedObjectKind:       r.Event.InvolvedObject.Kind,
+		EventInvolvedObjectNamespace:  r.Event.InvolvedObject.Namespace,
+		EventInvolvedObjectName:       r.Event.InvolvedObject.Name,
+		EventInvolvedObjectUID:        r.Event.InvolvedObject.UID,
+		EventInvolvedObjectAPIVersion: r.Event.InvolvedObject.APIVersion,
+		EventInvolvedObjectFieldPath:  r.Event.InvolvedObject.FieldPath,
+		EventReason:                   r.Event.Reason,
+		EventMessage:                  r.Event.Message,
+		EventType:                     r.Event.Type,
+		EventSourceComponent:          r.Event.SourceComponent,
+		EventSourceHost:               r.Event.SourceHost,
+		EventFirstTimestamp:           parquetMillis(r.Event.FirstTimestamp),
+		EventLastTimestamp:            parquetMillis(r.Event.LastTimestamp),
+		EventEventTime:                parquetMillis(r.Event.EventTime),
+		EventCount:                    r.Event.Count,
+		EventAction:                   r.Event.Action,
+		EventReportingController:      r.Event.ReportingController,
+		EventReportingInstance:        r.Event.ReportingInstance,
+		ClusterID:                     r.ClusterID,
+		InstanceID:                    r.InstanceID,
+		Sequence:                      r.Sequence,
+		ProcessedAt:                   parquetMillis(r.ProcessedAt),
+	}
+	if related := r.Event.Related; related != nil {
+		row.EventRelatedKind, row.EventRelatedNamespace, row.EventRelatedName = &related.Kind, &related.Namespace, &related.Name
+	}
+	if old := r.OldEvent; old != nil {
+		row.OldEventResourceVersion, row.OldEventMessage, row.OldEventCount = &old.ResourceVersion, &old.Message, &old.Count
+		row.OldEventLastTimestamp = parquetMillis(old.LastTimestamp)
+	}
+	return row
+}
+
+// parquetMillis returns the value of a timestamp column, the zero value of
+// optional columns is written as null
+func parquetMillis(t *time.Time) int64 {
+	if t == nil {
+		return 0
+	}
+	return t.UnixMilli()
+}
+
+// parquetEncoder writes parquet files of parquetRow, compressed with codec.
+// Batches are cut in row groups of about rowGroupBytes, a single row group
+// when it is 0. A record is a file of one row.
+type parquetEncoder struct {
+	codec         compress.Codec
+	rowGroupBytes int
+}
+
+func (enc parquetEncoder) Encode(e *EventData) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := enc.WriteBatch(&buf, []EventData{*e}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (enc parquetEncoder) WriteBatch(w io.Writer, events []EventData) error {
+	pw := parquet.NewGenericWriter[parquetRow](w, parquet.Compression(enc.codec))
+	size := 0
+	for i := range events {
+		row := newParquetRow(&events[i])
+		if _, err := pw.Write([]parquetRow{row}); err != nil {
+			return err
+		}
+		if enc.rowGroupBytes == 0 {
+			continue
+		}
+		if size += parquetRowSize(&row); size >= enc.rowGroupBytes {
+			if err := pw.Flush(); err != nil {
+				return err
+			}
+			size = 0
+		}
+	}
+	return pw.Close()
+}
+
+func (parquetEncoder) ContentType() string {
+	return "application/vnd.apache.parquet"
+}
+
+// recordSize implements recordSizer, a record of one row is mostly made of
+// the schema of the file
+func (parquetEncoder) recordSize(e *EventData) int {
+	row := newParquetRow(e)
+	return parquetRowSize(&row)
+}
+
+// parquetRowSize estimates the uncompressed size of row with the size of its
+// JSON document
+func parquetRowSize(row *parquetRow) int {
+	b, _ := json.Marshal(row)
+	return len(b)
+}
@@ -0,0 +1,115 @@
+package sinks
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+)
+
+// readParquet returns the rows of a parquet file and its number of row groups
+func readParquet(t *testing.T, b []byte) ([]parquetRow, int) {
+	t.Helper()
+	f, err := parquet.OpenFile(bytes.NewReader(b), int64(len(b)))
+	require.NoError(t, err)
+	r := parquet.NewGenericReader[parquetRow](f)
+	defer r.Close()
+	rows := make([]parquetRow, f.NumRows())
+	n, err := r.Read(rows)
+	if err != io.EOF {
+		require.NoError(t, err)
+	}
+	return rows[:n], len(f.RowGroups())
+}
+
+func TestParquetEncoder(t *testing.T) {
+	enc, err := NewEncoder(&OutputConfig{Format: "parquet"})
+	require.NoError(t, err)
+	require.Equal(t, ".parquet", fileExtension(enc))
+
+	s := &eventShaper{envelopes: NewEnvelopes("cluster-1", "eventrouter-1"), encoder: enc}
+	events := []EventData{
+		s.eventData(schemaTestEvent(), &v1.Event{ObjectMeta: schemaTestEvent().ObjectMeta, Message: "old", Count: 2}),
+		s.eventData(&v1.Event{Reason: "Scheduled"}, nil),
+	}
+	var buf bytes.Buffer
+	require.NoError(t, enc.WriteBatch(&buf, events))
+	rows, groups := readParquet(t, buf.Bytes())
+	require.Equal(t, 1, groups)
+	require.Len(t, rows, 2)
+
+	r := rows[0]
+	require.Equal(t, "UPDATED", r.Verb)
+	require.Equal(t, "web-1", r.EventInvolvedObjectName)
+	require.Equal(t, map[string]string{"app": "web"}, r.EventLabels)
+	require.Equal(t, int32(3), r.EventCount)
+	require.Equal(t, time.Date(2024, 3, 7, 10, 0, 0, 0, time.UTC).UnixMilli(), r.EventLastTimestamp)
+	require.Zero(t, r.EventFirstTimestamp)
+	require.Equal(t, "node-1", *r.EventRelatedName)
+	require.Equal(t, "old", *r.OldEventMessage)
+	require.Equal(t, int32(2), *r.OldEventCount)
+	require.Equal(t, "cluster-1", r.ClusterID)
+	require.Equal(t, int64(1), r.Sequence)
+
+	r = rows[1]
+	require.Equal(t, "Scheduled", r.EventReason)
+	require.Zero(t, r.EventLastTimestamp)
+	require.Nil(t, r.EventRelatedKind)
+	require.Nil(t, r.OldEventCount)
+
+	// A record is a file of one row
+	b, err := enc.Encode(&events[1])
+	require.NoError(t, err)
+	rows, _ = readParquet(t, b)
+	require.Len(t, rows, 1)
+	require.Equal(t, "ADDED", rows[0].Verb)
+}
+
+func TestParquetEncoder_schema(t *testing.T) {
+	schema := parquet.SchemaOf(parquetRow{}).String()
+	require.Contains(t, schema, "required binary event_involved_object_kind (STRING);")
+	require.Contains(t, schema, "optional int64 event_last_timestamp (TIMESTAMP(isAdjustedToUTC=true,unit=MILLIS));")
+	require.Contains(t, schema, "required int32 event_count (INT(32,true));")
+
+	// Unset timestamps are nulls
+	var buf bytes.Buffer
+	require.NoError(t, parquetEncoder{codec: &parquet.Snappy}.WriteBatch(&buf, []EventData{NewEventData(&v1.Event{}, nil)}))
+	f, err := parquet.OpenFile(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	column, ok := f.Schema().Lookup("event_last_timestamp")
+	require.True(t, ok)
+	rows := make([]parquet.Row, 1)
+	_, err = f.RowGroups()[0].Rows().ReadRows(rows)
+	if err != io.EOF {
+		require.NoError(t, err)
+	}
+	require.True(t, rows[0][column.ColumnIndex].IsNull())
+}
+
+func TestParquetEncoder_rowGroups(t *testing.T) {
+	events := make([]EventData, 10)
+	for i := range events {
+		events[i] = NewEventData(schemaTestEvent(), nil)
+	}
+	enc, err := NewEncoder(&OutputConfig{Format: "parquet"})
+	require.NoError(t, err)
+	p := enc.(parquetEncoder)
+	size := p.recordSize(&events[0])
+
+	// Row groups are cut once they reach rowGroupBytes
+	p.rowGroupBytes = 3 * size
+	var buf bytes.Buffer
+	require.NoError(t, p.WriteBatch(&buf, events))
+	rows, groups := readParquet(t, buf.Bytes())
+	require.Len(t, rows, 10)
+	require.Equal(t, 4, groups)
+}
+
+func TestParquetEncoder_transform(t *testing.T) {
+	c := &OutputConfig{Format: "parquet", TransformConfig: TransformConfig{Drop: []string{"event.message"}}}
+	require.EqualError(t, c.Validate(), "transform keys cannot be used with the parquet outputFormat, its schema is fixed")
+}
@@ -0,0 +1,180 @@
+/*
+Copyright 2017 The Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package plugin defines the protocol spoken between eventrouter and an
+// out-of-process sink plugin.
+//
+// Messages are JSON objects, one per line, exchanged over the stdin/stdout of
+// a launched plugin process or over a TCP or unix socket. The router opens
+// every session with a hello carrying ProtocolVersion and the plugin answers
+// with its own hello. After that the router sends event messages, each with a
+// sequence number the plugin acknowledges with ack or nack, and ping messages
+// the plugin answers with pong:
+//
+//	router -> {"type":"hello","version":1}
+//	plugin <- {"type":"hello","version":1,"name":"my-sink"}
+//	router -> {"type":"event","seq":1,"data":{"verb":"ADDED","event":{...}}}
+//	plugin <- {"type":"ack","seq":1}
+//	router -> {"type":"ping"}
+//	plugin <- {"type":"pong"}
+//
+// The router never has more unacknowledged events outstanding than its
+// configured window, so a slow plugin applies back pressure instead of
+// being flooded. Events that were not acknowledged when a session ends are
+// sent again in the next session.
+package plugin
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ProtocolVersion is the version of the protocol implemented by this package.
+const ProtocolVersion = 1
+
+// Message types
+const (
+	TypeHello = "hello"
+	TypeEvent = "event"
+	TypeAck   = "ack"
+	TypeNack  = "nack"
+	TypePing  = "ping"
+	TypePong  = "pong"
+	TypeError = "error"
+)
+
+// maxMessageSize bounds the size of one encoded message.
+const maxMessageSize = 4 * 1024 * 1024
+
+// Message is a single frame of the protocol.
+type Message struct {
+	Type    string          `json:"type"`
+	Version int             `json:"version,omitempty"`
+	Name    string          `json:"name,omitempty"`
+	Seq     uint64          `json:"seq,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
+	Error   string          `json:"error,omitempty"`
+}
+
+// Encoder writes messages, one JSON object per line. It is safe for
+// concurrent use.
+type Encoder struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewEncoder returns an Encoder writing to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{enc: json.NewEncoder(w)}
+}
+
+// Encode writes m followed by a newline.
+func (e *Encoder) Encode(m *Message) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.enc.Encode(m)
+}
+
+// Decoder reads messages written by an Encoder.
+type Decoder struct {
+	s *bufio.Scanner
+}
+
+// NewDecoder returns a Decoder reading from r.
+func NewDecoder(r io.Reader) *Decoder {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 0, 64*1024), maxMessageSize)
+	return &Decoder{s: s}
+}
+
+// Decode reads the next message. It returns io.EOF when r is exhausted.
+func (d *Decoder) Decode() (*Message, error) {
+	for d.s.Scan() {
+		line := d.s.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var m Message
+		if err := json.Unmarshal(line, &m); err != nil {
+			return nil, fmt.Errorf("invalid message: %w", err)
+		}
+		return &m, nil
+	}
+	if err := d.s.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+// Handler receives the JSON encoded EventData of one event. Returning an
+// error rejects the event with a nack.
+type Handler func(data json.RawMessage) error
+
+// Serve runs the plugin side of the protocol on r and w until r is closed,
+// calling h for every event. A plugin binary usually calls it with its stdin
+// and stdout:
+//
+//	err := plugin.Serve("my-sink", os.Stdin, os.Stdout, handle)
+func Serve(name string, r io.Reader, w io.Writer, h Handler) error {
+	dec := NewDecoder(r)
+	enc := NewEncoder(w)
+
+	hello, err := dec.Decode()
+	if err != nil {
+		return fmt.Errorf("handshake: %w", err)
+	}
+	if hello.Type != TypeHello {
+		return fmt.Errorf("handshake: expected %s, got %s", TypeHello, hello.Type)
+	}
+	if hello.Version != ProtocolVersion {
+		msg := fmt.Sprintf("unsupported protocol version %d, want %d", hello.Version, ProtocolVersion)
+		_ = enc.Encode(&Message{Type: TypeError, Error: msg})
+		return errors.New(msg)
+	}
+	if err := enc.Encode(&Message{Type: TypeHello, Version: ProtocolVersion, Name: name}); err != nil {
+		return fmt.Errorf("handshake: %w", err)
+	}
+
+	for {
+		m, err := dec.Decode()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var reply *Message
+		switch m.Type {
+		case TypeEvent:
+			reply = &Message{Type: TypeAck, Seq: m.Seq}
+			if err := h(m.Data); err != nil {
+				reply = &Message{Type: TypeNack, Seq: m.Seq, Error: err.Error()}
+			}
+		case TypePing:
+			reply = &Message{Type: TypePong}
+		default:
+			reply = &Message{Type: TypeError, Error: fmt.Sprintf("unexpected message type %q", m.Type)}
+		}
+		if err := enc.Encode(reply); err != nil {
+			return err
+		}
+	}
+}
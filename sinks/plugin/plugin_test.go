@@ -0,0 +1,61 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServe(t *testing.T) {
+	in := strings.Join([]string{
+		`{"type":"hello","version":1}`,
+		`{"type":"event","seq":1,"data":{"verb":"ADDED"}}`,
+		``,
+		`{"type":"ping"}`,
+		`{"type":"event","seq":2,"data":{"verb":"bad"}}`,
+		`{"type":"unknown"}`,
+	}, "\n")
+	var out bytes.Buffer
+	var got []string
+
+	err := Serve("test", strings.NewReader(in), &out, func(data json.RawMessage) error {
+		got = append(got, string(data))
+		if strings.Contains(string(data), "bad") {
+			return errors.New("rejected")
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{`{"verb":"ADDED"}`, `{"verb":"bad"}`}, got)
+	require.Equal(t, `{"type":"hello","version":1,"name":"test"}
+{"type":"ack","seq":1}
+{"type":"pong"}
+{"type":"nack","seq":2,"error":"rejected"}
+{"type":"error","error":"unexpected message type \"unknown\""}
+`, out.String())
+}
+
+func TestServe_handshake(t *testing.T) {
+	testCases := []struct {
+		in        string
+		wantOut   string
+		wantError string
+	}{
+		{"", "", "handshake: EOF"},
+		{`{"type":"ping"}`, "", "handshake: expected hello, got ping"},
+		{`{"type":"hello","version":2}`, `{"type":"error","error":"unsupported protocol version 2, want 1"}` + "\n", "unsupported protocol version 2, want 1"},
+		{`not json`, "", "handshake: invalid message: invalid character 'o' in literal null (expecting 'u')"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.in, func(t *testing.T) {
+			var out bytes.Buffer
+			err := Serve("test", strings.NewReader(tc.in), &out, func(json.RawMessage) error { return nil })
+			require.EqualError(t, err, tc.wantError)
+			require.Equal(t, tc.wantOut, out.String())
+		})
+	}
+}
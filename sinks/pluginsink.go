@@ -0,0 +1,487 @@
+/*
+Copyright 2017 The Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sinks
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"sort"
+	"time"
+
+	"github.com/eapache/channels"
+	"github.com/golang/glog"
+	"github.com/kuoss/eventrouter/sinks/plugin"
+	v1 "k8s.io/api/core/v1"
+)
+
+func init() {
+	Register("plugin", SinkFactory{
+		NewConfig: func() interface{} { return defaultPluginSinkConfig() },
+		New: func(name string, cfg interface{}) (EventSinkInterface, error) {
+			c := cfg.(*PluginSinkConfig)
+			p := NewPluginSink(*c)
+			p.name = name
+			p.configure(&c.OutputConfig)
+			runUntilShutdown(p.Run)
+			return p, nil
+		},
+	})
+}
+
+// minPluginBackoff is the delay before the first restart of a failed plugin.
+const minPluginBackoff = time.Second
+
+/*
+PluginSink forwards events to a sink implemented outside of eventrouter, for
+example one that needs an SDK which cannot be compiled into the image. The
+plugin is either launched as a child process speaking the protocol of package
+plugin over its stdin and stdout, or reached over a TCP or unix socket.
+
+The sink survives plugin crashes: a failed session is restarted with an
+exponential backoff and every event the plugin did not acknowledge is sent
+again, so delivery is at-least-once while the events fit in the buffer.
+*/
+type PluginSink struct {
+	cfg PluginSinkConfig
+
+	// name identifies the sink in the health reports and metrics
+	name string
+
+	// eventCh buffers events between the informer and the plugin session
+	eventCh channels.Channel
+
+	// connect opens a new connection to the plugin
+	connect func() (pluginConn, error)
+
+	// minBackoff is the delay before the first restart
+	minBackoff time.Duration
+
+	// seq is the sequence number of the last event sent
+	seq uint64
+
+	// inflight holds the events sent to the plugin and not acknowledged yet
+	inflight map[uint64]EventData
+
+	// eventShaper shapes the events sent to the plugin
+	eventShaper
+}
+
+// PluginSinkConfig is the configuration of the plugin sink
+type PluginSinkConfig struct {
+	// Command launches the plugin, e.g. ["/plugins/my-sink", "--flag"]
+	Command []string `mapstructure:"pluginCommand"`
+
+	// Address connects to a running plugin, e.g. "tcp://127.0.0.1:9000" or
+	// "unix:///var/run/my-sink.sock"
+	Address string `mapstructure:"pluginAddress"`
+
+	// By default we buffer up to 1500 events, and drop messages if more than
+	// 1500 have come in without getting consumed
+	BufferSize      int  `mapstructure:"pluginBufferSize"`
+	DiscardMessages bool `mapstructure:"pluginDiscardMessages"`
+
+	// MaxInFlight is the number of events sent without an acknowledgement
+	// before the sink waits for the plugin
+	MaxInFlight int `mapstructure:"pluginMaxInFlight"`
+
+	// HealthInterval is the period of the health check pings. A plugin that
+	// stays silent for two periods is restarted.
+	HealthInterval time.Duration `mapstructure:"pluginHealthInterval"`
+
+	// Timeout bounds connecting, the handshake and every write
+	Timeout time.Duration `mapstructure:"pluginTimeout"`
+
+	// MaxBackoff caps the delay between two restarts
+	MaxBackoff time.Duration `mapstructure:"pluginMaxBackoff"`
+
+	OutputConfig `mapstructure:",squash"`
+}
+
+func defaultPluginSinkConfig() *PluginSinkConfig {
+	return &PluginSinkConfig{
+		BufferSize:      1500,
+		DiscardMessages: true,
+		MaxInFlight:     100,
+		HealthInterval:  10 * time.Second,
+		Timeout:         10 * time.Second,
+		MaxBackoff:      time.Minute,
+	}
+}
+
+// Validate implements config.Validator
+func (c *PluginSinkConfig) Validate() error {
+	var errs []error
+	if (len(c.Command) == 0) == (c.Address == "") {
+		errs = append(errs, errors.New("exactly one of pluginCommand and pluginAddress must be set"))
+	}
+	if c.Address != "" {
+		if _, _, err := parsePluginAddress(c.Address); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.MaxInFlight < 1 {
+		errs = append(errs, errors.New("pluginMaxInFlight must be at least 1"))
+	}
+	if c.HealthInterval <= 0 || c.Timeout <= 0 || c.MaxBackoff <= 0 {
+		errs = append(errs, errors.New("pluginHealthInterval, pluginTimeout and pluginMaxBackoff must be positive"))
+	}
+	errs = append(errs, c.OutputConfig.Validate())
+	return errors.Join(errs...)
+}
+
+// pluginConn is a connection to a plugin
+type pluginConn interface {
+	io.ReadWriteCloser
+	SetWriteDeadline(t time.Time) error
+}
+
+// NewPluginSink constructs a new PluginSink, the plugin is started by Run.
+func NewPluginSink(cfg PluginSinkConfig) *PluginSink {
+	p := &PluginSink{
+		cfg:        cfg,
+		name:       "plugin",
+		minBackoff: minPluginBackoff,
+		inflight:   map[uint64]EventData{},
+	}
+
+	if cfg.DiscardMessages {
+		p.eventCh = channels.NewOverflowingChannel(channels.BufferCap(cfg.BufferSize))
+	} else {
+		p.eventCh = channels.NewNativeChannel(channels.BufferCap(cfg.BufferSize))
+	}
+
+	if len(cfg.Command) > 0 {
+		p.connect = func() (pluginConn, error) { return startPluginProcess(cfg.Command) }
+	} else {
+		p.connect = func() (pluginConn, error) { return dialPlugin(cfg.Address, cfg.Timeout) }
+	}
+	return p
+}
+
+// UpdateEvents implements the EventSinkInterface. It really just writes the
+// event data to the event channel, which should never block when messages
+// are discarded on overflow.
+func (p *PluginSink) UpdateEvents(eNew *v1.Event, eOld *v1.Event) {
+	bufferEvent(p.name, p.eventCh, p.eventData(eNew, eOld))
+}
+
+// Run keeps a session with the plugin open until stopCh is closed,
+// restarting it with an exponential backoff whenever it fails. Once stopCh
+// is closed the buffered events are still sent, until the plugin has
+// acknowledged them all or acknowledges nothing for pluginTimeout.
+func (p *PluginSink) Run(stopCh <-chan bool) {
+	backoff := p.minBackoff
+	for {
+		connected, err := p.session(stopCh)
+		if err == nil {
+			return
+		}
+		if connected {
+			backoff = p.minBackoff
+		}
+		glog.Errorf("Plugin session failed, restarting in %v: %v", backoff, err)
+		reportFailure(p.name, err)
+
+		select {
+		case <-time.After(backoff):
+		case <-stopCh:
+			return
+		}
+		backoff *= 2
+		if backoff > p.cfg.MaxBackoff {
+			backoff = p.cfg.MaxBackoff
+		}
+	}
+}
+
+// session runs a single connection to the plugin. It reports whether the
+// handshake succeeded and returns a nil error only when stopCh was closed
+// and the buffered events are drained.
+func (p *PluginSink) session(stopCh <-chan bool) (bool, error) {
+	conn, err := p.connect()
+	if err != nil {
+		return false, fmt.Errorf("connect: %w", err)
+	}
+	defer conn.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+
+	msgCh := make(chan *plugin.Message)
+	errCh := make(chan error, 1)
+	go func() {
+		dec := plugin.NewDecoder(conn)
+		for {
+			m, err := dec.Decode()
+			if err != nil {
+				errCh <- err
+				return
+			}
+			select {
+			case msgCh <- m:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	enc := plugin.NewEncoder(conn)
+	send := func(m *plugin.Message) error {
+		if err := conn.SetWriteDeadline(time.Now().Add(p.cfg.Timeout)); err != nil {
+			return err
+		}
+		return enc.Encode(m)
+	}
+
+	if err := p.handshake(send, msgCh, errCh); err != nil {
+		return false, err
+	}
+
+	// Whatever the previous session left unacknowledged goes first
+	seqs := make([]uint64, 0, len(p.inflight))
+	for seq := range p.inflight {
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	for _, seq := range seqs {
+		if err := p.sendEvent(send, seq, p.inflight[seq]); err != nil {
+			return true, err
+		}
+	}
+
+	ticker := time.NewTicker(p.cfg.HealthInterval)
+	defer ticker.Stop()
+	lastSeen := time.Now()
+
+	// drainTimeout is set once stopCh is closed and fires when the plugin
+	// acknowledged nothing for cfg.Timeout
+	var drainTimeout <-chan time.Time
+
+	for {
+		if drainTimeout != nil && len(p.inflight) == 0 && p.eventCh.Len() == 0 {
+			return true, nil
+		}
+
+		// Stop reading new events while the window is full
+		var in <-chan interface{}
+		if len(p.inflight) < p.cfg.MaxInFlight {
+			in = p.eventCh.Out()
+		}
+
+		select {
+		case e := <-in:
+			evt, ok := e.(EventData)
+			if !ok {
+				glog.Warningf("Invalid type sent through event channel: %T", e)
+				continue
+			}
+			p.seq++
+			p.inflight[p.seq] = evt
+			if err := p.sendEvent(send, p.seq, evt); err != nil {
+				return true, err
+			}
+		case m := <-msgCh:
+			lastSeen = time.Now()
+			switch m.Type {
+			case plugin.TypeAck:
+				delete(p.inflight, m.Seq)
+				reportSuccess(p.name)
+				if drainTimeout != nil {
+					drainTimeout = time.After(p.cfg.Timeout)
+				}
+			case plugin.TypeNack:
+				glog.Warningf("Plugin rejected event %d: %s", m.Seq, m.Error)
+				delete(p.inflight, m.Seq)
+				discardEvents(p.name, 1)
+				reportFailure(p.name, fmt.Errorf("plugin rejected event %d: %s", m.Seq, m.Error))
+			case plugin.TypePong:
+			case plugin.TypeError:
+				return true, fmt.Errorf("plugin error: %s", m.Error)
+			default:
+				glog.Warningf("Unexpected message type from plugin: %q", m.Type)
+			}
+		case err := <-errCh:
+			return true, fmt.Errorf("read: %w", err)
+		case <-ticker.C:
+			if time.Since(lastSeen) > 2*p.cfg.HealthInterval {
+				return true, fmt.Errorf("no answer to health checks since %v", lastSeen.Format(time.RFC3339))
+			}
+			if err := send(&plugin.Message{Type: plugin.TypePing}); err != nil {
+				return true, err
+			}
+		case <-stopCh:
+			stopCh = nil
+			drainTimeout = time.After(p.cfg.Timeout)
+		case <-drainTimeout:
+			lost := len(p.inflight) + p.eventCh.Len()
+			glog.Warningf("Plugin acknowledged nothing for %v, %d events not delivered", p.cfg.Timeout, lost)
+			discardEvents(p.name, lost)
+			return true, nil
+		}
+	}
+}
+
+// handshake exchanges hello messages and checks the plugin protocol version.
+func (p *PluginSink) handshake(send func(*plugin.Message) error, msgCh <-chan *plugin.Message, errCh <-chan error) error {
+	if err := send(&plugin.Message{Type: plugin.TypeHello, Version: plugin.ProtocolVersion}); err != nil {
+		return fmt.Errorf("handshake: %w", err)
+	}
+
+	timer := time.NewTimer(p.cfg.Timeout)
+	defer timer.Stop()
+
+	select {
+	case m := <-msgCh:
+		if m.Type == plugin.TypeError {
+			return fmt.Errorf("handshake: plugin error: %s", m.Error)
+		}
+		if m.Type != plugin.TypeHello {
+			return fmt.Errorf("handshake: expected %s, got %s", plugin.TypeHello, m.Type)
+		}
+		if m.Version != plugin.ProtocolVersion {
+			return fmt.Errorf("handshake: unsupported plugin protocol version %d, want %d", m.Version, plugin.ProtocolVersion)
+		}
+		glog.Infof("Connected to plugin %q", m.Name)
+		return nil
+	case err := <-errCh:
+		return fmt.Errorf("handshake: %w", err)
+	case <-timer.C:
+		return errors.New("handshake: timed out")
+	}
+}
+
+func (p *PluginSink) sendEvent(send func(*plugin.Message) error, seq uint64, evt EventData) error {
+	data, err := p.encodeJSONValue(&evt)
+	if err != nil {
+		glog.Warningf("Failed to serialize event: %v", err)
+		delete(p.inflight, seq)
+		discardEvents(p.name, 1)
+		reportFailure(p.name, err)
+		return nil
+	}
+	return send(&plugin.Message{Type: plugin.TypeEvent, Seq: seq, Data: data})
+}
+
+// parsePluginAddress splits "tcp://host:port" and "unix:///path" addresses
+// into the network and address understood by net.Dial.
+func parsePluginAddress(address string) (string, string, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid pluginAddress: %w", err)
+	}
+	switch u.Scheme {
+	case "tcp":
+		return "tcp", u.Host, nil
+	case "unix":
+		return "unix", u.Path, nil
+	}
+	return "", "", fmt.Errorf("invalid pluginAddress %q, scheme must be tcp or unix", address)
+}
+
+func dialPlugin(address string, timeout time.Duration) (pluginConn, error) {
+	network, addr, err := parsePluginAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	return net.DialTimeout(network, addr, timeout)
+}
+
+// processConn talks to a plugin process over its stdin and stdout.
+type processConn struct {
+	cmd *exec.Cmd
+	r   *os.File
+	w   *os.File
+}
+
+// startPluginProcess launches the plugin. Its stderr is copied to the log.
+func startPluginProcess(command []string) (*processConn, error) {
+	stdinR, stdinW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	stdoutR, stdoutW, err := os.Pipe()
+	if err != nil {
+		stdinR.Close()
+		stdinW.Close()
+		return nil, err
+	}
+
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdin = stdinR
+	cmd.Stdout = stdoutW
+	cmd.Stderr = &pluginLogWriter{name: command[0]}
+	err = cmd.Start()
+	stdinR.Close()
+	stdoutW.Close()
+	if err != nil {
+		stdinW.Close()
+		stdoutR.Close()
+		return nil, err
+	}
+	return &processConn{cmd: cmd, r: stdoutR, w: stdinW}, nil
+}
+
+func (c *processConn) Read(b []byte) (int, error)  { return c.r.Read(b) }
+func (c *processConn) Write(b []byte) (int, error) { return c.w.Write(b) }
+
+func (c *processConn) SetWriteDeadline(t time.Time) error {
+	return c.w.SetWriteDeadline(t)
+}
+
+// Close closes the stdin of the plugin, giving it a moment to exit on its
+// own before it is killed.
+func (c *processConn) Close() error {
+	c.w.Close()
+	exited := make(chan error, 1)
+	go func() { exited <- c.cmd.Wait() }()
+
+	var err error
+	select {
+	case err = <-exited:
+	case <-time.After(5 * time.Second):
+		_ = c.cmd.Process.Kill()
+		err = <-exited
+	}
+	c.r.Close()
+	return err
+}
+
+// pluginLogWriter logs every line a plugin writes to its stderr.
+type pluginLogWriter struct {
+	name string
+	buf  bytes.Buffer
+}
+
+func (w *pluginLogWriter) Write(b []byte) (int, error) {
+	w.buf.Write(b)
+	for {
+		line, err := w.buf.ReadString('\n')
+		if err != nil {
+			// keep the incomplete line for the next write
+			w.buf.Reset()
+			w.buf.WriteString(line)
+			return len(b), nil
+		}
+		glog.Infof("plugin %s: %s", w.name, line[:len(line)-1])
+	}
+}
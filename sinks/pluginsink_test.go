@@ -0,0 +1,297 @@
+package sinks
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kuoss/eventrouter/sinks/plugin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+)
+
+func newTestPluginSink(connect func() (pluginConn, error)) *PluginSink {
+	cfg := defaultPluginSinkConfig()
+	cfg.Address = "tcp://unused:1"
+	cfg.HealthInterval = 50 * time.Millisecond
+	cfg.Timeout = time.Second
+	cfg.MaxBackoff = 10 * time.Millisecond
+	p := NewPluginSink(*cfg)
+	p.minBackoff = time.Millisecond
+	p.connect = connect
+	return p
+}
+
+// servePipe connects the sink to an in-process plugin collecting event messages.
+func servePipe(got chan<- string) func() (pluginConn, error) {
+	return func() (pluginConn, error) {
+		client, server := net.Pipe()
+		go func() {
+			defer server.Close()
+			_ = plugin.Serve("test", server, server, func(data json.RawMessage) error {
+				var evt EventData
+				if err := json.Unmarshal(data, &evt); err != nil {
+					return err
+				}
+				got <- evt.Event.Message
+				return nil
+			})
+		}()
+		return client, nil
+	}
+}
+
+func TestPluginSink_delivers(t *testing.T) {
+	got := make(chan string, 10)
+	p := newTestPluginSink(servePipe(got))
+	stopCh := make(chan bool)
+	doneCh := make(chan bool)
+	go func() {
+		p.Run(stopCh)
+		doneCh <- true
+	}()
+
+	p.UpdateEvents(&v1.Event{Message: "one"}, nil)
+	p.UpdateEvents(&v1.Event{Message: "two"}, nil)
+	require.Equal(t, "one", <-got)
+	require.Equal(t, "two", <-got)
+
+	// Let a couple of health checks pass
+	time.Sleep(200 * time.Millisecond)
+	close(stopCh)
+	<-doneCh
+	require.Empty(t, p.inflight)
+}
+
+func TestPluginSink_drainsOnStop(t *testing.T) {
+	got := make(chan string, 10)
+	p := newTestPluginSink(servePipe(got))
+	// net.Pipe has no buffer, sending an event while the plugin writes the
+	// acknowledgement of the previous one would block both
+	p.cfg.MaxInFlight = 1
+	for _, msg := range []string{"one", "two", "three"} {
+		p.UpdateEvents(&v1.Event{Message: msg}, nil)
+	}
+	stopCh := make(chan bool)
+	close(stopCh)
+	p.Run(stopCh)
+	require.Empty(t, p.inflight)
+	require.Zero(t, p.eventCh.Len())
+	require.Len(t, got, 3)
+}
+
+func TestPluginSink_nack(t *testing.T) {
+	r := &testHealthReporter{}
+	SetHealthReporter(r)
+	defer SetHealthReporter(nil)
+	dropped := testutil.ToFloat64(EventsDroppedTotal.WithLabelValues("plugin"))
+
+	p := newTestPluginSink(func() (pluginConn, error) {
+		client, server := net.Pipe()
+		go func() {
+			defer server.Close()
+			_ = plugin.Serve("test", server, server, func(data json.RawMessage) error {
+				return errors.New("rejected")
+			})
+		}()
+		return client, nil
+	})
+	p.UpdateEvents(&v1.Event{}, nil)
+	stopCh := make(chan bool)
+	close(stopCh)
+	p.Run(stopCh)
+	require.Empty(t, p.inflight)
+	require.Equal(t, []string{"plugin: plugin rejected event 1: rejected"}, r.failures)
+	require.Equal(t, dropped+1, testutil.ToFloat64(EventsDroppedTotal.WithLabelValues("plugin")))
+}
+
+func TestPluginSink_restartResendsUnacked(t *testing.T) {
+	got := make(chan string, 10)
+	sessions := 0
+	p := newTestPluginSink(func() (pluginConn, error) {
+		sessions++
+		if sessions == 1 {
+			// A plugin which crashes after receiving its first event
+			client, server := net.Pipe()
+			go func() {
+				defer server.Close()
+				dec := plugin.NewDecoder(server)
+				enc := plugin.NewEncoder(server)
+				_, _ = dec.Decode()
+				_ = enc.Encode(&plugin.Message{Type: plugin.TypeHello, Version: plugin.ProtocolVersion})
+				_, _ = dec.Decode()
+			}()
+			return client, nil
+		}
+		return servePipe(got)()
+	})
+	stopCh := make(chan bool)
+	doneCh := make(chan bool)
+	go func() {
+		p.Run(stopCh)
+		doneCh <- true
+	}()
+
+	p.UpdateEvents(&v1.Event{Message: "lost"}, nil)
+	require.Equal(t, "lost", <-got)
+	close(stopCh)
+	<-doneCh
+	require.Equal(t, 2, sessions)
+}
+
+func TestPluginSink_flowControl(t *testing.T) {
+	received := make(chan *plugin.Message, 10)
+	p := newTestPluginSink(func() (pluginConn, error) {
+		// A plugin which never acknowledges events but answers pings
+		client, server := net.Pipe()
+		go func() {
+			defer server.Close()
+			dec := plugin.NewDecoder(server)
+			enc := plugin.NewEncoder(server)
+			_, _ = dec.Decode()
+			_ = enc.Encode(&plugin.Message{Type: plugin.TypeHello, Version: plugin.ProtocolVersion})
+			for {
+				m, err := dec.Decode()
+				if err != nil {
+					return
+				}
+				if m.Type == plugin.TypePing {
+					_ = enc.Encode(&plugin.Message{Type: plugin.TypePong})
+					continue
+				}
+				received <- m
+			}
+		}()
+		return client, nil
+	})
+	p.cfg.MaxInFlight = 2
+	p.cfg.Timeout = 100 * time.Millisecond
+	stopCh := make(chan bool)
+	doneCh := make(chan bool)
+	go func() {
+		p.Run(stopCh)
+		doneCh <- true
+	}()
+
+	for i := 0; i < 5; i++ {
+		p.UpdateEvents(&v1.Event{}, nil)
+	}
+	time.Sleep(200 * time.Millisecond)
+	close(stopCh)
+	<-doneCh
+	require.Len(t, received, 2)
+	require.Len(t, p.inflight, 2)
+	require.Equal(t, 3, p.eventCh.Len())
+}
+
+func TestPluginSink_handshake(t *testing.T) {
+	testCases := []struct {
+		reply     *plugin.Message
+		wantError string
+	}{
+		{&plugin.Message{Type: plugin.TypeHello, Version: 2}, "handshake: unsupported plugin protocol version 2, want 1"},
+		{&plugin.Message{Type: plugin.TypePong}, "handshake: expected hello, got pong"},
+		{&plugin.Message{Type: plugin.TypeError, Error: "boom"}, "handshake: plugin error: boom"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.wantError, func(t *testing.T) {
+			p := newTestPluginSink(func() (pluginConn, error) {
+				client, server := net.Pipe()
+				go func() {
+					defer server.Close()
+					_, _ = plugin.NewDecoder(server).Decode()
+					_ = plugin.NewEncoder(server).Encode(tc.reply)
+				}()
+				return client, nil
+			})
+			connected, err := p.session(make(chan bool))
+			require.False(t, connected)
+			require.EqualError(t, err, tc.wantError)
+		})
+	}
+}
+
+// TestPluginHelperProcess is not a real test, it is the plugin process
+// launched by TestPluginSink_process.
+func TestPluginHelperProcess(t *testing.T) {
+	out := os.Getenv("EVENTROUTER_TEST_PLUGIN_OUT")
+	if out == "" {
+		return
+	}
+	f, err := os.Create(out)
+	if err != nil {
+		os.Exit(2)
+	}
+	defer f.Close()
+	err = plugin.Serve("helper", os.Stdin, os.Stdout, func(data json.RawMessage) error {
+		_, err := f.Write(append(data, '\n'))
+		return err
+	})
+	if err != nil {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func TestPluginSink_process(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "events")
+	t.Setenv("EVENTROUTER_TEST_PLUGIN_OUT", out)
+
+	cfg := defaultPluginSinkConfig()
+	cfg.Command = []string{os.Args[0], "-test.run=^TestPluginHelperProcess$"}
+	p := NewPluginSink(*cfg)
+	stopCh := make(chan bool)
+	doneCh := make(chan bool)
+	go func() {
+		p.Run(stopCh)
+		doneCh <- true
+	}()
+
+	p.UpdateEvents(&v1.Event{Message: "hello plugin"}, nil)
+	require.Eventually(t, func() bool {
+		data, _ := os.ReadFile(out)
+		return len(data) > 0
+	}, 10*time.Second, 10*time.Millisecond)
+	close(stopCh)
+	<-doneCh
+
+	data, err := os.ReadFile(out)
+	require.NoError(t, err)
+	require.Contains(t, string(data), `"message":"hello plugin"`)
+}
+
+func TestPluginSinkConfig(t *testing.T) {
+	testCases := []struct {
+		settings  map[string]interface{}
+		wantError string
+	}{
+		{map[string]interface{}{"pluginCommand": []interface{}{"/bin/sink"}}, ""},
+		{map[string]interface{}{"pluginAddress": "unix:///run/sink.sock", "pluginHealthInterval": "5s"}, ""},
+		{map[string]interface{}{}, "sink plugin: exactly one of pluginCommand and pluginAddress must be set"},
+		{
+			map[string]interface{}{"pluginAddress": "http://x", "pluginMaxInFlight": 0},
+			"sink plugin: invalid pluginAddress \"http://x\", scheme must be tcp or unix\nsink plugin: pluginMaxInFlight must be at least 1",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.wantError, func(t *testing.T) {
+			v := viper.New()
+			v.Set("sink", "plugin")
+			for k, val := range tc.settings {
+				v.Set(k, val)
+			}
+			err := ValidateConfig(v)
+			if tc.wantError == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tc.wantError)
+			}
+		})
+	}
+}
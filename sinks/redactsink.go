@@ -0,0 +1,85 @@
+/*
+Copyright 2017 The Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sinks
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/kuoss/eventrouter/config"
+	"github.com/kuoss/eventrouter/redact"
+	"github.com/spf13/viper"
+	v1 "k8s.io/api/core/v1"
+)
+
+// RedactSink masks the secrets of the events before forwarding them to
+// another sink, see package redact for the rules.
+type RedactSink struct {
+	redactor *redact.Redactor
+	sink     EventSinkInterface
+}
+
+// redactConfig holds the redaction keys applying to every sink
+type redactConfig struct {
+	// Detectors lists the built-in rules to enable, e.g. "bearer-token"
+	Detectors []string `mapstructure:"redactDetectors"`
+
+	// Rules are "name=regexp" pairs
+	Rules []string `mapstructure:"redactRules"`
+
+	// Replacement masks every secret, "[REDACTED]" by default
+	Replacement string `mapstructure:"redactReplacement"`
+}
+
+// NewRedactSink constructs a RedactSink in front of sink
+func NewRedactSink(redactor *redact.Redactor, sink EventSinkInterface) *RedactSink {
+	return &RedactSink{redactor: redactor, sink: sink}
+}
+
+// UpdateEvents implements the EventSinkInterface
+func (r *RedactSink) UpdateEvents(eNew *v1.Event, eOld *v1.Event) {
+	r.sink.UpdateEvents(r.redactor.Event(eNew), r.redactor.Event(eOld))
+}
+
+// loadRedactor compiles the redaction rules of v, it returns nil when none
+// is configured.
+func loadRedactor(v *viper.Viper) (*redact.Redactor, error) {
+	var cfg redactConfig
+	if err := config.Decode(v, &cfg); err != nil {
+		return nil, err
+	}
+	if len(cfg.Detectors) == 0 && len(cfg.Rules) == 0 {
+		return nil, nil
+	}
+
+	var errs []error
+	rules := make([]redact.Rule, 0, len(cfg.Rules))
+	for _, r := range cfg.Rules {
+		name, pattern, ok := strings.Cut(r, "=")
+		if !ok || name == "" || pattern == "" {
+			errs = append(errs, fmt.Errorf("invalid rule %q, expected name=regexp", r))
+			continue
+		}
+		rules = append(rules, redact.Rule{Name: name, Pattern: pattern})
+	}
+	redactor, err := redact.New(cfg.Detectors, rules, cfg.Replacement)
+	if err = errors.Join(append(errs, err)...); err != nil {
+		return nil, prefixErrors("redact", err)
+	}
+	return redactor, nil
+}
@@ -0,0 +1,38 @@
+package sinks
+
+import (
+	"testing"
+
+	"github.com/kuoss/eventrouter/redact"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestRedactSink_UpdateEvents(t *testing.T) {
+	r, err := redact.New([]string{"bearer-token"}, nil, "")
+	require.NoError(t, err)
+	inner := &testSink{}
+	sink := NewRedactSink(r, inner)
+
+	sink.UpdateEvents(&v1.Event{Message: "sent Bearer abc.def"}, nil)
+	require.Len(t, inner.events, 1)
+	require.Equal(t, "sent Bearer [REDACTED]", inner.events[0].Message)
+}
+
+func TestManufactureSink_redact(t *testing.T) {
+	defer viper.Set("redactRules", nil)
+	viper.Set("sink", "glog")
+
+	viper.Set("redactRules", []string{"host", "bad=("})
+	_, err := ManufactureSink()
+	require.EqualError(t, err, "redact: invalid rule \"host\", expected name=regexp\n"+
+		"redact: rule \"bad\": error parsing regexp: missing closing ): `(`")
+
+	viper.Set("redactRules", []string{`host=\w+\.internal`})
+	sink, err := ManufactureSink()
+	require.NoError(t, err)
+	r, ok := sink.(*RedactSink)
+	require.True(t, ok, "Expected RedactSink")
+	require.Equal(t, &GlogSink{eventShaper{encoder: jsonEncoder{}}}, r.sink)
+}
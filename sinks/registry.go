@@ -0,0 +1,87 @@
+/*
+Copyright 2017 The Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sinks
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// SinkFactory describes how one type of sink is configured and built.
+//
+// A sink package registers its factory from an init function, so a build can
+// include extra sinks with a blank import:
+//
+//	import _ "example.com/eventrouter-mysink"
+type SinkFactory struct {
+	// NewConfig returns the typed configuration of the sink with its defaults
+	// applied, or nil when the sink has no settings. The struct is filled with
+	// config.Decode and may implement config.Validator for extra checks.
+	NewConfig func() interface{}
+
+	// New builds the sink from the configuration returned by NewConfig after
+	// it has been decoded and validated. name is the name of the sink
+	// instance under "sinks", or the type name for the single "sink", and
+	// identifies the sink in the health reports and metrics.
+	New func(name string, cfg interface{}) (EventSinkInterface, error)
+}
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = map[string]SinkFactory{}
+)
+
+// Register makes a sink available under the given type name, which is what
+// the "sink" config key selects. It panics if the name is registered twice
+// or the factory cannot build a sink.
+func Register(name string, factory SinkFactory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+
+	if factory.New == nil {
+		panic(fmt.Sprintf("sinks: Register of sink %q without New", name))
+	}
+	if _, dup := factories[name]; dup {
+		panic(fmt.Sprintf("sinks: Register called twice for sink %q", name))
+	}
+	if factory.NewConfig == nil {
+		factory.NewConfig = func() interface{} { return nil }
+	}
+	factories[name] = factory
+}
+
+// Registered returns the sorted type names of all registered sinks.
+func Registered() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func lookupFactory(name string) (SinkFactory, bool) {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+
+	f, ok := factories[name]
+	return f, ok
+}
@@ -0,0 +1,60 @@
+package sinks
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+)
+
+type testSinkConfig struct {
+	Endpoint string `mapstructure:"testSinkEndpoint" validate:"required"`
+}
+
+type testSink struct {
+	endpoint string
+	events   []*v1.Event
+}
+
+func (s *testSink) UpdateEvents(eNew *v1.Event, eOld *v1.Event) {
+	s.events = append(s.events, eNew)
+}
+
+func init() {
+	Register("test", SinkFactory{
+		NewConfig: func() interface{} { return &testSinkConfig{} },
+		New: func(_ string, cfg interface{}) (EventSinkInterface, error) {
+			return &testSink{endpoint: cfg.(*testSinkConfig).Endpoint}, nil
+		},
+	})
+}
+
+func TestRegistered(t *testing.T) {
+	require.Equal(t, []string{"azureblob", "eventhub", "gcs", "glog", "http", "influxdb", "kafka", "plugin", "s3sink", "stdout", "test"}, Registered())
+}
+
+func TestRegister_panics(t *testing.T) {
+	require.PanicsWithValue(t, `sinks: Register called twice for sink "glog"`, func() {
+		Register("glog", SinkFactory{New: func(string, interface{}) (EventSinkInterface, error) { return nil, nil }})
+	})
+	require.PanicsWithValue(t, `sinks: Register of sink "nonew" without New`, func() {
+		Register("nonew", SinkFactory{})
+	})
+}
+
+func TestManufactureSink_registered(t *testing.T) {
+	viper.Set("sink", "test")
+	defer viper.Set("sink", "glog")
+
+	viper.Set("testSinkEndpoint", "")
+	_, err := ManufactureSink()
+	require.EqualError(t, err, "sink test: testSinkEndpoint: required but not set")
+
+	viper.Set("testSinkEndpoint", "mem://")
+	sink, err := ManufactureSink()
+	require.NoError(t, err)
+	require.Equal(t, &testSink{endpoint: "mem://"}, sink)
+
+	require.Contains(t, ConfigKeys(), "testSinkEndpoint")
+}
@@ -0,0 +1,348 @@
+/*
+Copyright 2017 The Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sinks
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/kuoss/eventrouter/config"
+	"github.com/kuoss/eventrouter/filter"
+	"github.com/mitchellh/mapstructure"
+	"github.com/spf13/viper"
+	v1 "k8s.io/api/core/v1"
+)
+
+/*
+RouterSink sends every event to the named sink instances whose routes match
+it. It is used instead of the single "sink" when the config declares sink
+instances under "sinks" and a routing table under "routes":
+
+	{
+	  "sinks": {
+	    "alerting": {"sink": "http", "httpSinkUrl": "http://alerts"},
+	    "archive":  {"sink": "s3sink", "s3SinkBucket": "events", ...},
+	    "infra":    {"sink": "kafka", "kafkaTopic": "infra-events"}
+	  },
+	  "routes": [
+	    {"match": {"namespaces": ["payments"], "types": ["Warning"]}, "sinks": ["alerting"], "continue": true},
+	    {"match": {"namespaces": ["kube-system"]}, "sinks": ["infra"], "continue": true},
+	    {"sinks": ["archive"]}
+	  ]
+	}
+
+Routes are evaluated in order. The first matching route delivers the event
+and ends the evaluation, unless it sets "continue". Routes marked "default"
+are only used when no other route matched. An event is delivered at most once
+to each sink instance. Sink instance names are case-insensitive.
+*/
+type RouterSink struct {
+	sinks  map[string]EventSinkInterface
+	routes []Route
+}
+
+// Route sends the events it matches to one or more sink instances
+type Route struct {
+	Match    RouteMatch `mapstructure:"match"`
+	Sinks    []string   `mapstructure:"sinks"`
+	Continue bool       `mapstructure:"continue"`
+	Default  bool       `mapstructure:"default"`
+}
+
+// RouteMatch selects events. Every non-empty field must match, a list
+// matches when it contains the value of the event. An empty match selects
+// every event.
+type RouteMatch struct {
+	// Namespaces match the namespace of the event
+	Namespaces []string `mapstructure:"namespaces"`
+	// Types match the type of the event, e.g. Normal or Warning
+	Types []string `mapstructure:"types"`
+	// Reasons match the reason of the event, e.g. BackOff
+	Reasons []string `mapstructure:"reasons"`
+	// Kinds match the kind of the involved object, e.g. Pod
+	Kinds []string `mapstructure:"kinds"`
+	// Labels must all be present on the event with the given values
+	Labels map[string]string `mapstructure:"labels"`
+	// Expression is a CEL expression the event must satisfy, see package
+	// filter
+	Expression string `mapstructure:"expression"`
+
+	// expr is the compiled Expression
+	expr *filter.Expression
+}
+
+// compile compiles the expression of m, if any
+func (m *RouteMatch) compile() error {
+	if m.Expression == "" {
+		return nil
+	}
+	expr, err := filter.Compile(m.Expression)
+	if err != nil {
+		return err
+	}
+	m.expr = expr
+	return nil
+}
+
+// routeErrors counts the evaluation errors of the route expressions
+var routeErrors = newEvalErrors("route")
+
+// Matches reports whether e is selected by m. in holds the variables of e
+// for the expression, which does not match when it fails to evaluate.
+func (m *RouteMatch) Matches(e *v1.Event, in *filter.Input) bool {
+	if !matchesAny(m.Namespaces, e.Namespace) ||
+		!matchesAny(m.Types, e.Type) ||
+		!matchesAny(m.Reasons, e.Reason) ||
+		!matchesAny(m.Kinds, e.InvolvedObject.Kind) {
+		return false
+	}
+	for k, want := range m.Labels {
+		if got, ok := lookupLabel(e.Labels, k); !ok || got != want {
+			return false
+		}
+	}
+	if m.expr != nil {
+		ok, err := m.expr.Match(in)
+		if err != nil {
+			routeErrors.report(e, err)
+			return false
+		}
+		return ok
+	}
+	return true
+}
+
+func matchesAny(values []string, v string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupLabel finds a label ignoring the case of its key, since viper
+// lowercases the keys of the config.
+func lookupLabel(labels map[string]string, key string) (string, bool) {
+	if v, ok := labels[key]; ok {
+		return v, true
+	}
+	for k, v := range labels {
+		if strings.EqualFold(k, key) {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// NewRouterSink constructs a RouterSink sending to the given sink instances
+// according to routes.
+func NewRouterSink(sinks map[string]EventSinkInterface, routes []Route) (*RouterSink, error) {
+	r := &RouterSink{
+		sinks:  map[string]EventSinkInterface{},
+		routes: routes,
+	}
+	for name, s := range sinks {
+		r.sinks[strings.ToLower(name)] = s
+	}
+	if err := checkRoutes(routes, r.sinks); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// UpdateEvents implements the EventSinkInterface
+func (r *RouterSink) UpdateEvents(eNew *v1.Event, eOld *v1.Event) {
+	in := filter.NewInput(NewEventData(eNew, eOld).Verb, eNew, eOld)
+	delivered := map[string]bool{}
+	matched := r.route(eNew, eOld, in, false, delivered)
+	if !matched {
+		r.route(eNew, eOld, in, true, delivered)
+	}
+}
+
+// route walks either the regular or the default routes and reports whether
+// any of them matched.
+func (r *RouterSink) route(eNew *v1.Event, eOld *v1.Event, in *filter.Input, defaults bool, delivered map[string]bool) bool {
+	matched := false
+	for i := range r.routes {
+		rt := &r.routes[i]
+		if rt.Default != defaults || !rt.Match.Matches(eNew, in) {
+			continue
+		}
+		matched = true
+		for _, name := range rt.Sinks {
+			name = strings.ToLower(name)
+			if delivered[name] {
+				continue
+			}
+			delivered[name] = true
+			r.sinks[name].UpdateEvents(eNew, eOld)
+		}
+		if !rt.Continue {
+			break
+		}
+	}
+	return matched
+}
+
+// checkRoutes verifies that every route names at least one known sink and
+// compiles the route expressions.
+func checkRoutes(routes []Route, sinks map[string]EventSinkInterface) error {
+	var errs []error
+	for i := range routes {
+		rt := &routes[i]
+		if err := rt.Match.compile(); err != nil {
+			errs = append(errs, fmt.Errorf("routes[%d]: %w", i, err))
+		}
+		if len(rt.Sinks) == 0 {
+			errs = append(errs, fmt.Errorf("routes[%d]: no sinks", i))
+		}
+		for _, name := range rt.Sinks {
+			if _, ok := sinks[strings.ToLower(name)]; !ok {
+				errs = append(errs, fmt.Errorf("routes[%d]: unknown sink %q", i, name))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// sinkSpec is a sink factory together with its decoded configuration
+type sinkSpec struct {
+	typeName string
+	factory  SinkFactory
+	cfg      interface{}
+}
+
+// build builds the sink of s, named name
+func (s sinkSpec) build(name string, env *envelopeConfig) (EventSinkInterface, error) {
+	sink, err := s.factory.New(name, s.cfg)
+	if err != nil || env == nil {
+		return sink, err
+	}
+	if es, ok := sink.(envelopeSetter); ok {
+		es.setClusterID(env.ClusterID)
+		if env.Version == "v2" {
+			es.setEnvelopes(NewEnvelopes(env.ClusterID, env.InstanceID))
+		}
+	}
+	return sink, nil
+}
+
+// routingEnabled reports whether v declares sink instances and routes
+// instead of a single sink.
+func routingEnabled(v *viper.Viper) bool {
+	return v.IsSet("sinks") || v.IsSet("routes")
+}
+
+// loadRouting decodes the sink instances declared under "sinks" and the
+// routing table under "routes".
+func loadRouting(v *viper.Viper) (map[string]sinkSpec, []Route, error) {
+	var errs []error
+
+	raw, ok := v.Get("sinks").(map[string]interface{})
+	if !ok || len(raw) == 0 {
+		errs = append(errs, errors.New("sinks: must map sink instance names to their configuration"))
+	}
+	names := make([]string, 0, len(raw))
+	for name := range raw {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	specs := map[string]sinkSpec{}
+	for _, name := range names {
+		if strings.Contains(name, ".") {
+			errs = append(errs, fmt.Errorf("sinks.%s: instance names must not contain dots", name))
+			continue
+		}
+		sub := v.Sub("sinks." + name)
+		if sub == nil {
+			errs = append(errs, fmt.Errorf("sinks.%s: must be an object", name))
+			continue
+		}
+		spec, err := loadSinkConfig(sub)
+		if err == nil {
+			known := append([]string{"sink"}, config.Keys(spec.cfg)...)
+			err = config.CheckUnknownKeys(sub, known)
+		}
+		if err != nil {
+			errs = append(errs, prefixErrors("sinks."+name, err))
+			continue
+		}
+		specs[name] = spec
+	}
+
+	var routes []Route
+	if err := v.UnmarshalKey("routes", &routes, func(c *mapstructure.DecoderConfig) {
+		c.ErrorUnused = true
+	}); err != nil {
+		errs = append(errs, fmt.Errorf("routes: %w", err))
+	} else if len(routes) == 0 {
+		errs = append(errs, errors.New("routes: at least one route is required"))
+	} else {
+		// The instances that failed to decode are still declared, so that
+		// the routes naming them are not reported as unknown
+		stubs := make(map[string]EventSinkInterface, len(raw))
+		for name := range raw {
+			stubs[strings.ToLower(name)] = nil
+		}
+		if err := checkRoutes(routes, stubs); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return specs, routes, errors.Join(errs...)
+}
+
+// manufactureRouter builds every sink instance and the RouterSink in front
+// of them.
+func manufactureRouter(v *viper.Viper, env *envelopeConfig) (EventSinkInterface, error) {
+	specs, routes, err := loadRouting(v)
+	if err != nil {
+		return nil, err
+	}
+
+	built := make(map[string]EventSinkInterface, len(specs))
+	for name, spec := range specs {
+		s, err := spec.build(name, env)
+		if err != nil {
+			return nil, fmt.Errorf("sinks.%s: %w", name, err)
+		}
+		glog.Infof("Sink %s is [%v]", name, spec.typeName)
+		built[name] = s
+	}
+	return NewRouterSink(built, routes)
+}
+
+// prefixErrors prefixes every error joined in err
+func prefixErrors(prefix string, err error) error {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		var errs []error
+		for _, e := range joined.Unwrap() {
+			errs = append(errs, prefixErrors(prefix, e))
+		}
+		return errors.Join(errs...)
+	}
+	return fmt.Errorf("%s: %w", prefix, err)
+}
@@ -0,0 +1,177 @@
+package sinks
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newRoutedEvent(namespace, eventType, reason, kind string, labels map[string]string) *v1.Event {
+	return &v1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Namespace: namespace, Labels: labels},
+		InvolvedObject: v1.ObjectReference{Kind: kind},
+		Type:           eventType,
+		Reason:         reason,
+	}
+}
+
+func TestRouteMatch(t *testing.T) {
+	e := newRoutedEvent("payments", "Warning", "BackOff", "Pod", map[string]string{"team": "pay"})
+	testCases := []struct {
+		match RouteMatch
+		want  bool
+	}{
+		{RouteMatch{}, true},
+		{RouteMatch{Namespaces: []string{"kube-system", "payments"}}, true},
+		{RouteMatch{Namespaces: []string{"kube-system"}}, false},
+		{RouteMatch{Types: []string{"Warning"}, Reasons: []string{"BackOff"}, Kinds: []string{"Pod"}}, true},
+		{RouteMatch{Types: []string{"Warning"}, Kinds: []string{"Node"}}, false},
+		{RouteMatch{Labels: map[string]string{"team": "pay"}}, true},
+		{RouteMatch{Labels: map[string]string{"TEAM": "pay"}}, true},
+		{RouteMatch{Labels: map[string]string{"team": "infra"}}, false},
+		{RouteMatch{Labels: map[string]string{"owner": ""}}, false},
+	}
+	for _, tc := range testCases {
+		require.Equal(t, tc.want, tc.match.Matches(e, nil), "%+v", tc.match)
+	}
+}
+
+func TestRouterSink_UpdateEvents(t *testing.T) {
+	alerting, archive, infra := &testSink{}, &testSink{}, &testSink{}
+	r, err := NewRouterSink(map[string]EventSinkInterface{
+		"alerting": alerting,
+		"Archive":  archive,
+		"infra":    infra,
+	}, []Route{
+		{Match: RouteMatch{Namespaces: []string{"payments"}, Types: []string{"Warning"}}, Sinks: []string{"alerting"}, Continue: true},
+		{Match: RouteMatch{Namespaces: []string{"kube-system"}}, Sinks: []string{"infra", "archive"}},
+		{Match: RouteMatch{Namespaces: []string{"payments"}}, Sinks: []string{"archive", "alerting"}},
+		{Sinks: []string{"archive"}, Default: true},
+	})
+	require.NoError(t, err)
+
+	paymentsWarning := newRoutedEvent("payments", "Warning", "", "", nil)
+	paymentsNormal := newRoutedEvent("payments", "Normal", "", "", nil)
+	system := newRoutedEvent("kube-system", "Normal", "", "", nil)
+	other := newRoutedEvent("default", "Normal", "", "", nil)
+	for _, e := range []*v1.Event{paymentsWarning, paymentsNormal, system, other} {
+		r.UpdateEvents(e, nil)
+	}
+
+	require.Equal(t, []*v1.Event{paymentsWarning, paymentsNormal}, alerting.events)
+	require.Equal(t, []*v1.Event{paymentsWarning, paymentsNormal, system, other}, archive.events)
+	require.Equal(t, []*v1.Event{system}, infra.events)
+}
+
+func TestRouterSink_expression(t *testing.T) {
+	oom, rest := &testSink{}, &testSink{}
+	r, err := NewRouterSink(map[string]EventSinkInterface{"oom": oom, "rest": rest}, []Route{
+		{Match: RouteMatch{Types: []string{"Warning"}, Expression: `event.message.contains("OOMKilled") && event.count > 3`}, Sinks: []string{"oom"}},
+		{Sinks: []string{"rest"}, Default: true},
+	})
+	require.NoError(t, err)
+
+	match := &v1.Event{Type: "Warning", Message: "OOMKilled", Count: 4}
+	few := &v1.Event{Type: "Warning", Message: "OOMKilled", Count: 2}
+	r.UpdateEvents(match, nil)
+	r.UpdateEvents(few, nil)
+	require.Equal(t, []*v1.Event{match}, oom.events)
+	require.Equal(t, []*v1.Event{few}, rest.events)
+}
+
+func TestRouterSink_expressionError(t *testing.T) {
+	web, rest := &testSink{}, &testSink{}
+	r, err := NewRouterSink(map[string]EventSinkInterface{"web": web, "rest": rest}, []Route{
+		{Match: RouteMatch{Expression: `event.metadata.labels["app"] == "web"`}, Sinks: []string{"web"}},
+		{Sinks: []string{"rest"}},
+	})
+	require.NoError(t, err)
+
+	unlabeled := &v1.Event{}
+	r.UpdateEvents(unlabeled, nil)
+	require.Empty(t, web.events)
+	require.Equal(t, []*v1.Event{unlabeled}, rest.events)
+}
+
+func TestNewRouterSink_unknownSink(t *testing.T) {
+	_, err := NewRouterSink(map[string]EventSinkInterface{"a": &testSink{}}, []Route{
+		{Sinks: []string{"a", "b"}},
+		{},
+		{Match: RouteMatch{Expression: "event"}, Sinks: []string{"a"}},
+	})
+	require.EqualError(t, err, "routes[0]: unknown sink \"b\"\nroutes[1]: no sinks\n"+
+		"routes[2]: invalid expression \"event\": must evaluate to bool, not filter.event")
+}
+
+func TestManufactureSink_routing(t *testing.T) {
+	v := viper.GetViper()
+	defer func() {
+		viper.Reset()
+		viper.Set("sink", "glog")
+	}()
+
+	v.SetConfigType("json")
+	require.NoError(t, v.ReadConfig(bytes.NewBufferString(`{
+		"sinks": {
+			"alerting": {"sink": "test", "testSinkEndpoint": "mem://alerts"},
+			"out": {"sink": "stdout"}
+		},
+		"routes": [
+			{"match": {"types": ["Warning"]}, "sinks": ["alerting"], "continue": true},
+			{"sinks": ["out"]}
+		]
+	}`)))
+	require.NoError(t, ValidateConfig(v))
+
+	sink, err := ManufactureSink()
+	require.NoError(t, err)
+	r, ok := sink.(*RouterSink)
+	require.True(t, ok, "Expected RouterSink")
+	require.Equal(t, &testSink{endpoint: "mem://alerts"}, r.sinks["alerting"])
+	require.Equal(t, &StdoutSink{eventShaper: eventShaper{encoder: jsonEncoder{}}}, r.sinks["out"])
+	require.Len(t, r.routes, 2)
+	require.True(t, r.routes[0].Continue)
+}
+
+func TestValidateConfig_routing(t *testing.T) {
+	v := viper.New()
+	v.SetConfigType("json")
+	require.NoError(t, v.ReadConfig(bytes.NewBufferString(`{
+		"sinks": {
+			"alerting": {"sink": "http", "httpSinkBufferSize": "big"},
+			"bad": {"sink": "nope"},
+			"extra": {"sink": "stdout", "kafkaTopic": "x"}
+		},
+		"routes": [
+			{"match": {"types": ["Warning"], "severity": "high"}, "sinks": ["alerting"]}
+		]
+	}`)))
+	err := ValidateConfig(v)
+	require.EqualError(t, err, "sinks.alerting: sink http: httpSinkUrl: required but not set\n"+
+		"sinks.alerting: sink http: httpSinkBufferSize: invalid value big (string), expected integer\n"+
+		"sinks.bad: sink: invalid Sink Specified \"nope\", must be one of: azureblob, eventhub, gcs, glog, http, influxdb, kafka, plugin, s3sink, stdout, test\n"+
+		"sinks.extra: kafkatopic: unknown key\n"+
+		"routes: 1 error(s) decoding:\n\n* '[0].match' has invalid keys: severity")
+
+	v = viper.New()
+	v.Set("routes", []interface{}{map[string]interface{}{"sinks": []interface{}{"missing"}}})
+	require.EqualError(t, ValidateConfig(v), "sinks: must map sink instance names to their configuration\n"+
+		"routes[0]: unknown sink \"missing\"")
+
+	v = viper.New()
+	v.Set("sinks", map[string]interface{}{"bad": map[string]interface{}{"sink": "nope"}})
+	v.Set("routes", []interface{}{map[string]interface{}{"sinks": []interface{}{"bad", "missing"}}})
+	err = ValidateConfig(v)
+	require.ErrorContains(t, err, "sinks.bad: sink: invalid Sink Specified \"nope\"")
+	require.ErrorContains(t, err, "routes[0]: unknown sink \"missing\"")
+	require.NotContains(t, err.Error(), "unknown sink \"bad\"")
+
+	v = viper.New()
+	v.Set("sinks", map[string]interface{}{"a": map[string]interface{}{"sink": "glog"}})
+	v.Set("routes", []interface{}{map[string]interface{}{"sinks": []interface{}{"missing"}}})
+	require.EqualError(t, ValidateConfig(v), "routes[0]: unknown sink \"missing\"")
+}
@@ -4,26 +4,43 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
-	"time"
+	"net/http"
+	"net/url"
+	"os"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
-	"github.com/eapache/channels"
-	"github.com/golang/glog"
-	v1 "k8s.io/api/core/v1"
 )
 
+func init() {
+	Register("s3sink", SinkFactory{
+		NewConfig: func() interface{} { return defaultS3SinkConfig() },
+		New: func(name string, cfg interface{}) (EventSinkInterface, error) {
+			c := cfg.(*S3SinkConfig)
+			s, err := newS3Sink(name, c)
+			if err != nil {
+				return nil, err
+			}
+			s.configure(&c.OutputConfig)
+			s.start()
+			return s, nil
+		},
+	})
+}
+
 type IUploader interface {
 	Upload(*s3manager.UploadInput, ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error)
 }
 
 /*
 S3Sink is the sink that uploads the kubernetes events as json object stored in a file.
-The sinker uploads it to s3 if any of the below criteria gets fulfilled
+The files are batched by archive, the sinker uploads it to s3 if any of the below criteria gets fulfilled
 1) Time(uploadInterval): If the specfied time has passed since the last upload it uploads
-2) [TODO] Data size: If the total data getting uploaded becomes greater than N bytes
+2) Data size(uploadBytes): If the total data getting uploaded becomes greater than N bytes
 
 S3 is cheap and the sink can be used to store events data. S3 can later then be used with
 Redshift and other visualization tools to use this data.
@@ -35,166 +52,207 @@ type S3Sink struct {
 	// bucket is the s3 bucket name where the events data would be stored
 	bucket string
 
-	// bucketDir is the first level directory in the bucket where the events would be stored
-	bucketDir string
+	archive
+}
 
-	// outPutFormat is the format in which the data is stored in the s3 file
-	outputFormat string
+// S3SinkConfig is the configuration of the s3 sink
+type S3SinkConfig struct {
+	// AccessKeyID and SecretAccessKey are static credentials. When they are
+	// not set the default AWS credential chain is used: the environment,
+	// web identity tokens (IRSA), shared config files and instance roles.
+	AccessKeyID     string `mapstructure:"s3SinkAccessKeyID"`
+	SecretAccessKey string `mapstructure:"s3SinkSecretAccessKey"`
+	Region          string `mapstructure:"s3SinkRegion" validate:"required"`
+	Bucket          string `mapstructure:"s3SinkBucket" validate:"required"`
+	BucketDir       string `mapstructure:"s3SinkBucketDir" validate:"required"`
 
-	// lastUploadTimestamp stores the timestamp when the last upload to s3 happened
-	lastUploadTimestamp int64
+	// RoleARN is a role assumed with the credentials above
+	RoleARN         string `mapstructure:"s3SinkRoleARN"`
+	RoleExternalID  string `mapstructure:"s3SinkRoleExternalID"`
+	RoleSessionName string `mapstructure:"s3SinkRoleSessionName"`
 
-	// uploadInterval tells after how many seconds the next upload can happen
-	// sink waits till this time is passed before next upload can happen
-	uploadInterval time.Duration
+	// Endpoint is the URL of an S3 compatible store such as MinIO or Ceph,
+	// which usually need ForcePathStyle
+	Endpoint       string `mapstructure:"s3SinkEndpoint"`
+	ForcePathStyle bool   `mapstructure:"s3SinkForcePathStyle"`
 
-	// eventCh is used to interact eventRouter and the sharedInformer
-	eventCh channels.Channel
+	TLSCAFile             string `mapstructure:"s3SinkTLSCAFile"`
+	TLSCertFile           string `mapstructure:"s3SinkTLSCertFile"`
+	TLSKeyFile            string `mapstructure:"s3SinkTLSKeyFile"`
+	TLSInsecureSkipVerify bool   `mapstructure:"s3SinkTLSInsecureSkipVerify"`
 
-	// bodyBuf stores all the event captured data in a buffer before upload
-	bodyBuf *bytes.Buffer
-}
+	// By default the json is pushed to s3 in not flatenned rfc5424 write format
+	// The option to write to s3 is in the flattened json format which will help in
+	// using the data in redshift with least effort. outputFormat takes
+	// precedence when set.
+	OutputFormat string `mapstructure:"s3SinkOutputFormat" validate:"oneof=rfc5424 flatjson"`
 
-// NewS3Sink is the factory method constructing a new S3Sink
-func NewS3Sink(awsAccessKeyID string, s3SinkSecretAccessKey string, s3SinkRegion string, s3SinkBucket string, s3SinkBucketDir string, s3SinkUploadInterval int, overflow bool, bufferSize int, outputFormat string) (*S3Sink, error) {
-	awsConfig := &aws.Config{
-		Region:      aws.String(s3SinkRegion),
-		Credentials: credentials.NewStaticCredentials(awsAccessKeyID, s3SinkSecretAccessKey, ""),
-	}
+	// By default we buffer up to 1500 events, and drop messages if more than
+	// 1500 have come in without getting consumed
+	BufferSize      int  `mapstructure:"s3SinkBufferSize"`
+	DiscardMessages bool `mapstructure:"s3SinkDiscardMessages"`
 
-	awsConfig = awsConfig.WithCredentialsChainVerboseErrors(true)
-	sess, err := session.NewSession(awsConfig)
-	if err != nil {
-		return nil, err
-	}
+	// UploadInterval is the minimum number of seconds between two uploads
+	UploadInterval int `mapstructure:"s3SinkUploadInterval"`
 
-	uploader := s3manager.NewUploader(sess)
+	// UploadBytes uploads the buffered events as soon as they reach this
+	// size, whatever UploadInterval, 0 disables the trigger
+	UploadBytes int `mapstructure:"s3SinkUploadBytes"`
 
-	s := &S3Sink{
-		uploader:       uploader,
-		bucket:         s3SinkBucket,
-		bucketDir:      s3SinkBucketDir,
-		uploadInterval: time.Second * time.Duration(s3SinkUploadInterval),
-		outputFormat:   outputFormat,
-		bodyBuf:        bytes.NewBuffer(make([]byte, 0, 4096)),
-	}
+	// RetainBytes is the size of the failed uploads kept to be retried
+	RetainBytes int `mapstructure:"s3SinkRetainBytes"`
 
-	if overflow {
-		s.eventCh = channels.NewOverflowingChannel(channels.BufferCap(bufferSize))
-	} else {
-		s.eventCh = channels.NewNativeChannel(channels.BufferCap(bufferSize))
-	}
+	// Compression compresses the files, or the columns of parquet files
+	Compression string `mapstructure:"s3SinkCompression" validate:"oneof=none gzip zstd"`
 
-	return s, nil
-}
+	// KeyTemplate names the objects with the fields of objectKey, like
+	// {{.Dir}}/year={{.Year}}/month={{.Month}}/day={{.Day}}/{{.UUID}}{{.Ext}}
+	// SplitBatch writes the events of one upload to the objects named by
+	// their own key instead of the key of the first event.
+	KeyTemplate string `mapstructure:"s3SinkKeyTemplate"`
+	SplitBatch  bool   `mapstructure:"s3SinkSplitBatch"`
 
-// UpdateEvents implements the EventSinkInterface. It really just writes the
-// event data to the event OverflowingChannel, which should never block.
-// Messages that are buffered beyond the bufferSize specified for this HTTPSink
-// are discarded.
-func (s *S3Sink) UpdateEvents(eNew *v1.Event, eOld *v1.Event) {
-	s.eventCh.In() <- NewEventData(eNew, eOld)
-}
+	// options are the archive options set by Validate
+	options archiveOptions
 
-// Run sits in a loop, waiting for data to come in through h.eventCh,
-// and forwarding them to the HTTP sink. If multiple events have happened
-// between loop iterations, it puts all of them in one request instead of
-// making a single request per event.
-func (s *S3Sink) Run(stopCh <-chan bool) {
-loop:
-	for {
-		select {
-		case e := <-s.eventCh.Out():
-			var evt EventData
-			var ok bool
-			if evt, ok = e.(EventData); !ok {
-				glog.Warningf("Invalid type sent through event channel: %T", e)
-				continue loop
-			}
-
-			// Start with just this event...
-			arr := []EventData{evt}
-
-			// Consume all buffered events into an array, in case more have been written
-			// since we last forwarded them
-			numEvents := s.eventCh.Len()
-			for i := 0; i < numEvents; i++ {
-				e := <-s.eventCh.Out()
-				if evt, ok = e.(EventData); ok {
-					arr = append(arr, evt)
-				} else {
-					glog.Warningf("Invalid type sent through event channel: %T", e)
-				}
-			}
+	OutputConfig `mapstructure:",squash"`
+}
 
-			s.drainEvents(arr)
-		case <-stopCh:
-			break loop
+// Validate implements config.Validator
+func (c *S3SinkConfig) Validate() error {
+	if c.Format == "" {
+		c.Format = c.OutputFormat
+	}
+	c.options = archiveOptions{
+		prefix:          "s3Sink",
+		dir:             c.BucketDir,
+		bufferSize:      c.BufferSize,
+		discardMessages: c.DiscardMessages,
+		uploadInterval:  c.UploadInterval,
+		uploadBytes:     c.UploadBytes,
+		retainBytes:     c.RetainBytes,
+		compression:     c.Compression,
+		keyTemplate:     c.KeyTemplate,
+		splitBatch:      c.SplitBatch,
+	}
+	errs := c.options.validate(&c.OutputConfig)
+	if (c.AccessKeyID == "") != (c.SecretAccessKey == "") {
+		errs = append(errs, errors.New("s3SinkAccessKeyID and s3SinkSecretAccessKey must be set together"))
+	}
+	if c.RoleARN == "" && (c.RoleExternalID != "" || c.RoleSessionName != "") {
+		errs = append(errs, errors.New("s3SinkRoleExternalID and s3SinkRoleSessionName need s3SinkRoleARN"))
+	}
+	if c.Endpoint != "" {
+		if u, err := url.Parse(c.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("s3SinkEndpoint: invalid URL %q", c.Endpoint))
 		}
 	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		errs = append(errs, errors.New("s3SinkTLSCertFile and s3SinkTLSKeyFile must be set together"))
+	}
+	return errors.Join(errs...)
 }
 
-// drainEvents takes an array of event data and sends it to s3
-func (s *S3Sink) drainEvents(events []EventData) {
-	var written int64
-	for _, evt := range events {
-		switch s.outputFormat {
-		case "rfc5424":
-			w, err := evt.WriteRFC5424(s.bodyBuf)
-			written += w
-			if err != nil {
-				glog.Warningf("Could not write to event request body (wrote %v) bytes: %v", written, err)
-				return
-			}
-		case "flatjson":
-			w, err := evt.WriteFlattenedJSON(s.bodyBuf)
-			written += w
-			if err != nil {
-				glog.Warningf("Could not write to event request body (wrote %v) bytes: %v", written, err)
-				return
-			}
-		default:
-			err := errors.New("invalid Sink Output Format specified")
-			panic(err.Error())
-		}
-		s.bodyBuf.Write([]byte{'\n'})
-		written++
+func defaultS3SinkConfig() *S3SinkConfig {
+	return &S3SinkConfig{
+		OutputFormat:    "rfc5424",
+		BufferSize:      1500,
+		DiscardMessages: true,
+		UploadInterval:  120,
+		RetainBytes:     defaultRetainBytes,
+		Compression:     "none",
 	}
+}
 
-	if !s.canUpload() {
-		return
+// NewS3Sink is the factory method constructing a new S3Sink
+func NewS3Sink(awsAccessKeyID string, s3SinkSecretAccessKey string, s3SinkRegion string, s3SinkBucket string, s3SinkBucketDir string, s3SinkUploadInterval int, overflow bool, bufferSize int, outputFormat string) (*S3Sink, error) {
+	c := defaultS3SinkConfig()
+	c.AccessKeyID, c.SecretAccessKey, c.Region = awsAccessKeyID, s3SinkSecretAccessKey, s3SinkRegion
+	c.Bucket, c.BucketDir = s3SinkBucket, s3SinkBucketDir
+	c.UploadInterval, c.DiscardMessages, c.BufferSize = s3SinkUploadInterval, overflow, bufferSize
+	c.Format = outputFormat
+	if err := c.Validate(); err != nil {
+		return nil, err
 	}
-
-	s.upload()
+	s, err := newS3Sink("s3sink", c)
+	if err != nil {
+		return nil, err
+	}
+	s.configure(&c.OutputConfig)
+	return s, nil
 }
 
-// canUpload verifies the conditions suitable for a new file upload and upload the data
-func (s *S3Sink) canUpload() bool {
-	now := time.Now().UnixNano()
-	return (s.lastUploadTimestamp + s.uploadInterval.Nanoseconds()) < now
+// newS3Sink returns the sink named name of c, which has been validated
+func newS3Sink(name string, c *S3SinkConfig) (*S3Sink, error) {
+	uploader, err := c.newUploader()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &S3Sink{uploader: uploader, bucket: c.Bucket}
+	s.archive = newArchive(name, s, &c.options)
+	return s, nil
 }
 
-// getNewKey gets the key name based on time
-func (s *S3Sink) getNewKey(t time.Time) string {
-	return fmt.Sprintf("%s/%d/%d/%d/%d.txt", s.bucketDir, t.Year(), t.Month(), t.Day(), t.UnixNano())
+// put implements objectStore
+func (s *S3Sink) put(key string, body []byte, contentType string, contentEncoding string) error {
+	input := &s3manager.UploadInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String(contentType),
+	}
+	if contentEncoding != "" {
+		input.ContentEncoding = aws.String(contentEncoding)
+	}
+	_, err := s.uploader.Upload(input)
+	return err
 }
 
-// upload uploads the events stored in buffer to s3 in the specified key
-// and clears the buffer
-func (s *S3Sink) upload() {
-	now := time.Now()
-	key := s.getNewKey(now)
+// newUploader returns the uploader of the sink. Credentials come from the
+// static keys when set, or the default chain, and are used to assume RoleARN.
+// Only the S3 client talks to Endpoint, STS keeps its AWS endpoint.
+func (c *S3SinkConfig) newUploader() (*s3manager.Uploader, error) {
+	awsConfig := aws.NewConfig().WithRegion(c.Region).WithCredentialsChainVerboseErrors(true)
+	if c.AccessKeyID != "" {
+		awsConfig.Credentials = credentials.NewStaticCredentials(c.AccessKeyID, c.SecretAccessKey, "")
+	}
+	if c.TLSCAFile != "" || c.TLSCertFile != "" || c.TLSInsecureSkipVerify {
+		tlsConfig, err := newTLSConfig(c.TLSCAFile, c.TLSCertFile, c.TLSKeyFile, "", c.TLSInsecureSkipVerify)
+		if err != nil {
+			return nil, err
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		awsConfig.HTTPClient = &http.Client{Transport: transport}
+	}
 
-	_, err := s.uploader.Upload(&s3manager.UploadInput{
-		Bucket: aws.String(s.bucket),
-		Key:    aws.String(key),
-		Body:   s.bodyBuf,
-	})
+	opts := session.Options{Config: *awsConfig, SharedConfigState: session.SharedConfigEnable}
+	if c.TLSCAFile != "" {
+		// The session replaces the roots of the client with AWS_CA_BUNDLE
+		// unless it is given a bundle
+		pem, err := os.ReadFile(c.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		opts.CustomCABundle = bytes.NewReader(pem)
+	}
+	sess, err := session.NewSessionWithOptions(opts)
 	if err != nil {
-		glog.Errorf("Error uploading %s to s3, %v", key, err)
+		return nil, err
 	}
-	glog.Infof("Uploaded at %s", key)
-	s.lastUploadTimestamp = now.UnixNano()
 
-	s.bodyBuf.Truncate(0)
+	s3Config := aws.NewConfig().WithS3ForcePathStyle(c.ForcePathStyle)
+	if c.Endpoint != "" {
+		s3Config.Endpoint = aws.String(c.Endpoint)
+	}
+	if c.RoleARN != "" {
+		s3Config.Credentials = stscreds.NewCredentials(sess, c.RoleARN, func(p *stscreds.AssumeRoleProvider) {
+			if c.RoleExternalID != "" {
+				p.ExternalID = aws.String(c.RoleExternalID)
+			}
+			p.RoleSessionName = c.RoleSessionName
+		})
+	}
+	return s3manager.NewUploaderWithClient(s3.New(sess, s3Config)), nil
 }
@@ -1,10 +1,19 @@
 package sinks
 
 import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 	v1 "k8s.io/api/core/v1"
@@ -45,7 +54,8 @@ func TestS3Sink_Upload(t *testing.T) {
 
 	require.False(t, s3Sink.canUpload())
 
-	// Make upload happen
+	// Make upload happen for the event still in the channel
+	s3Sink.drainEvents([]EventData{(<-s3Sink.eventCh.Out()).(EventData)})
 	s3Sink.upload()
 
 	// Verify that the upload happened once
@@ -63,3 +73,340 @@ func TestCanUpload(t *testing.T) {
 	s3Sink.lastUploadTimestamp = time.Now().UnixNano()
 	require.False(t, s3Sink.canUpload())
 }
+
+func TestArchive_tick(t *testing.T) {
+	for interval, want := range map[time.Duration]time.Duration{
+		0:                 time.Second,
+		2 * time.Second:   time.Second,
+		120 * time.Second: 30 * time.Second,
+	} {
+		a := archive{uploadInterval: interval}
+		require.Equal(t, want, a.tick(), "uploadInterval %v", interval)
+	}
+}
+
+// recordingUploader records the uploaded files, failing while err is set
+type recordingUploader struct {
+	mu       sync.Mutex
+	err      error
+	attempts []string
+	uploaded map[string]string
+
+	// encodings are the Content-Encoding of the uploaded files
+	encodings map[string]string
+}
+
+func (u *recordingUploader) Upload(input *s3manager.UploadInput, options ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	key := aws.StringValue(input.Key)
+	u.attempts = append(u.attempts, key)
+	if u.err != nil {
+		return nil, u.err
+	}
+	b, _ := io.ReadAll(input.Body)
+	if u.uploaded == nil {
+		u.uploaded, u.encodings = map[string]string{}, map[string]string{}
+	}
+	u.uploaded[key] = string(b)
+	u.encodings[key] = aws.StringValue(input.ContentEncoding)
+	return &s3manager.UploadOutput{}, nil
+}
+
+func (u *recordingUploader) setErr(err error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.err = err
+}
+
+func (u *recordingUploader) files() map[string]string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	files := map[string]string{}
+	for k, v := range u.uploaded {
+		files[k] = v
+	}
+	return files
+}
+
+func TestS3Sink_retainFailedUploads(t *testing.T) {
+	uploader := &recordingUploader{err: errors.New("s3 unavailable")}
+	s, err := NewS3Sink("accessKeyID", "secretAccessKey", "region", "bucket", "bucketDir", 0, true, 10, "ndjson")
+	require.NoError(t, err)
+	s.uploader = uploader
+
+	s.drainEvents([]EventData{NewEventData(&v1.Event{Message: "first"}, nil)})
+	require.Len(t, s.retained, 1)
+	firstKey := s.retained[0].key
+
+	// The retained file is retried first, under the same key
+	s.drainEvents([]EventData{NewEventData(&v1.Event{Message: "second"}, nil)})
+	require.Len(t, s.retained, 2)
+	require.Equal(t, []string{firstKey, firstKey}, uploader.attempts)
+
+	uploader.setErr(nil)
+	s.flush()
+	require.Empty(t, s.retained)
+	require.Zero(t, s.retainedBytes)
+	require.Equal(t, firstKey, uploader.attempts[2])
+	files := uploader.files()
+	require.Len(t, files, 2)
+	require.Contains(t, files[firstKey], `"message":"first"`)
+}
+
+func TestS3Sink_retainBytes(t *testing.T) {
+	uploader := &recordingUploader{err: errors.New("s3 unavailable")}
+	s, err := NewS3Sink("accessKeyID", "secretAccessKey", "region", "bucket", "bucketDir", 0, true, 10, "ndjson")
+	require.NoError(t, err)
+	s.uploader = uploader
+	s.retainBytes = 600
+	dropped := testutil.ToFloat64(EventsDroppedTotal.WithLabelValues("s3sink"))
+
+	// Every file holds two events of about 200 bytes
+	for i := 0; i < 3; i++ {
+		s.drainEvents([]EventData{NewEventData(&v1.Event{Message: "a"}, nil), NewEventData(&v1.Event{Message: "b"}, nil)})
+	}
+	require.Len(t, s.retained, 1)
+	require.LessOrEqual(t, s.retainedBytes, 600)
+	require.Equal(t, dropped+4, testutil.ToFloat64(EventsDroppedTotal.WithLabelValues("s3sink")))
+}
+
+func TestS3Sink_uploadBytes(t *testing.T) {
+	uploader := &recordingUploader{}
+	s, err := NewS3Sink("accessKeyID", "secretAccessKey", "region", "bucket", "bucketDir", 3600, true, 10, "ndjson")
+	require.NoError(t, err)
+	s.uploader = uploader
+	s.uploadBytes = 500
+	s.lastUploadTimestamp = time.Now().UnixNano()
+
+	s.drainEvents([]EventData{NewEventData(&v1.Event{Message: "a"}, nil)})
+	require.Empty(t, uploader.attempts)
+	require.Len(t, s.pending, 1)
+
+	s.drainEvents([]EventData{NewEventData(&v1.Event{Message: "b"}, nil), NewEventData(&v1.Event{Message: "c"}, nil)})
+	require.Len(t, uploader.attempts, 1)
+	require.Empty(t, s.pending)
+	require.Zero(t, s.pendingBytes)
+}
+
+func TestS3Sink_Run(t *testing.T) {
+	uploader := &recordingUploader{}
+	s, err := NewS3Sink("accessKeyID", "secretAccessKey", "region", "bucket", "bucketDir", 1, true, 10, "ndjson")
+	require.NoError(t, err)
+	s.uploader = uploader
+	stop, done := make(chan bool), make(chan struct{})
+	go func() {
+		defer close(done)
+		s.Run(stop)
+	}()
+
+	// The ticker uploads the event arriving within the upload interval
+	s.UpdateEvents(&v1.Event{Message: "first"}, nil)
+	require.Eventually(t, func() bool { return len(uploader.files()) == 1 }, time.Second, time.Millisecond)
+	s.UpdateEvents(&v1.Event{Message: "quiet"}, nil)
+	require.Eventually(t, func() bool { return len(uploader.files()) == 2 }, 3*time.Second, 10*time.Millisecond)
+
+	// The buffered events are uploaded when stopping
+	s.UpdateEvents(&v1.Event{Message: "last"}, nil)
+	close(stop)
+	<-done
+	var bodies []string
+	for _, b := range uploader.files() {
+		bodies = append(bodies, b)
+	}
+	require.Len(t, bodies, 3)
+	require.Contains(t, bodies[0]+bodies[1]+bodies[2], `"message":"last"`)
+}
+
+func TestS3Sink_keyTemplate(t *testing.T) {
+	c := defaultS3SinkConfig()
+	c.AccessKeyID, c.SecretAccessKey, c.Region, c.Bucket, c.BucketDir = "id", "secret", "us-east-1", "bucket", "archive"
+	c.Format = "ndjson"
+	c.KeyTemplate = "{{.Dir}}/namespace={{.Namespace}}/{{.Hostname}}-{{.UUID}}{{.Ext}}"
+	c.SplitBatch = true
+	require.NoError(t, c.Validate())
+	s, err := newS3Sink("s3sink", c)
+	require.NoError(t, err)
+	s.configure(&c.OutputConfig)
+	s.hostname = "eventrouter-0"
+	uploader := &recordingUploader{}
+	s.uploader = uploader
+
+	s.drainEvents([]EventData{
+		NewEventData(&v1.Event{InvolvedObject: v1.ObjectReference{Namespace: "a"}, Message: "1"}, nil),
+		NewEventData(&v1.Event{InvolvedObject: v1.ObjectReference{Namespace: "b"}, Message: "2"}, nil),
+		NewEventData(&v1.Event{InvolvedObject: v1.ObjectReference{Namespace: "a"}, Message: "3"}, nil),
+	})
+	files := uploader.files()
+	require.Len(t, files, 2)
+	for key, body := range files {
+		switch {
+		case strings.HasPrefix(key, "archive/namespace=a/eventrouter-0-"):
+			require.Equal(t, 2, strings.Count(body, "\n"))
+		case strings.HasPrefix(key, "archive/namespace=b/eventrouter-0-"):
+			require.Contains(t, body, `"message":"2"`)
+		default:
+			t.Fatalf("unexpected key %s", key)
+		}
+		require.True(t, strings.HasSuffix(key, ".ndjson"), key)
+	}
+}
+
+func TestS3Sink_compression(t *testing.T) {
+	testCases := []struct {
+		format, compression string
+		ext, encoding       string
+	}{
+		{"ndjson", "gzip", ".ndjson.gz", "gzip"},
+		{"csv", "zstd", ".csv.zst", "zstd"},
+		{"parquet", "zstd", ".parquet", ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.format+" "+tc.compression, func(t *testing.T) {
+			c := defaultS3SinkConfig()
+			c.Region, c.Bucket, c.BucketDir = "us-east-1", "bucket", "archive"
+			c.Format, c.Compression = tc.format, tc.compression
+			c.KeyTemplate = "{{.Dir}}/{{.UUID}}{{.Ext}}"
+			require.NoError(t, c.Validate())
+			s, err := newS3Sink("s3sink", c)
+			require.NoError(t, err)
+			s.configure(&c.OutputConfig)
+			uploader := &recordingUploader{}
+			s.uploader = uploader
+
+			s.drainEvents([]EventData{NewEventData(&v1.Event{Message: "compressed"}, nil)})
+			require.Len(t, uploader.files(), 1)
+			for key, body := range uploader.files() {
+				require.True(t, strings.HasSuffix(key, tc.ext), key)
+				require.Equal(t, tc.encoding, uploader.encodings[key])
+				switch tc.encoding {
+				case "gzip":
+					zr, err := gzip.NewReader(strings.NewReader(body))
+					require.NoError(t, err)
+					b, err := io.ReadAll(zr)
+					require.NoError(t, err)
+					require.Contains(t, string(b), `"message":"compressed"`)
+				case "":
+					rows, _ := readParquet(t, []byte(body))
+					require.Equal(t, "compressed", rows[0].EventMessage)
+				}
+			}
+		})
+	}
+}
+
+// fakeS3 is an in-process S3 server keeping the objects put with path-style
+// requests by path, and the credentials that signed them
+type fakeS3 struct {
+	mu          sync.Mutex
+	objects     map[string]string
+	credentials []string
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "not implemented", http.StatusNotImplemented)
+		return
+	}
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.objects == nil {
+		f.objects = map[string]string{}
+	}
+	f.objects[r.URL.Path] = string(b)
+	auth := r.Header.Get("Authorization")
+	if i := strings.Index(auth, "Credential="); i >= 0 {
+		auth = strings.SplitN(auth[i+len("Credential="):], "/", 2)[0]
+	}
+	f.credentials = append(f.credentials, auth)
+	w.Header().Set("ETag", `"d41d8cd98f00b204e9800998ecf8427e"`)
+}
+
+func TestS3Sink_endpoint(t *testing.T) {
+	certs := newTestCerts(t, "localhost")
+	tests := []struct {
+		name       string
+		tls        bool
+		env        map[string]string
+		config     func(c *S3SinkConfig)
+		credential string
+		err        bool
+	}{
+		{
+			name: "static keys",
+			config: func(c *S3SinkConfig) {
+				c.AccessKeyID, c.SecretAccessKey = "static-id", "secret"
+			},
+			credential: "static-id",
+		},
+		{
+			name:       "default credential chain",
+			env:        map[string]string{"AWS_ACCESS_KEY_ID": "env-id", "AWS_SECRET_ACCESS_KEY": "secret"},
+			config:     func(c *S3SinkConfig) {},
+			credential: "env-id",
+		},
+		{
+			name: "mutual tls",
+			tls:  true,
+			config: func(c *S3SinkConfig) {
+				c.AccessKeyID, c.SecretAccessKey = "static-id", "secret"
+				c.TLSCAFile, c.TLSCertFile, c.TLSKeyFile = certs.caFile, certs.certFile, certs.keyFile
+			},
+			credential: "static-id",
+		},
+		{
+			name: "untrusted server",
+			tls:  true,
+			config: func(c *S3SinkConfig) {
+				c.AccessKeyID, c.SecretAccessKey = "static-id", "secret"
+			},
+			err: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			f := &fakeS3{}
+			srv := httptest.NewUnstartedServer(f)
+			if tt.tls {
+				srv.TLS = certs.serverConfig()
+				srv.StartTLS()
+			} else {
+				srv.Start()
+			}
+			defer srv.Close()
+			endpoint := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+
+			c := defaultS3SinkConfig()
+			c.Region, c.Bucket, c.BucketDir = "us-east-1", "events", "archive"
+			c.Endpoint, c.ForcePathStyle = endpoint, true
+			c.Format = "ndjson"
+			tt.config(c)
+			require.NoError(t, c.Validate())
+			s, err := newS3Sink("s3sink", c)
+			require.NoError(t, err)
+			s.configure(&c.OutputConfig)
+
+			s.drainEvents([]EventData{NewEventData(&v1.Event{Message: "archived"}, nil)})
+			if tt.err {
+				require.Len(t, s.retained, 1)
+				require.Empty(t, f.objects)
+				return
+			}
+			require.Empty(t, s.retained)
+			require.Len(t, f.objects, 1)
+			for path, body := range f.objects {
+				require.True(t, strings.HasPrefix(path, "/events/archive/"), path)
+				require.Contains(t, body, `"message":"archived"`)
+			}
+			require.Equal(t, []string{tt.credential}, f.credentials)
+		})
+	}
+}
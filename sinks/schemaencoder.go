@@ -0,0 +1,380 @@
+/*
+Copyright 2017 The Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sinks
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hamba/avro/v2"
+	"github.com/hamba/avro/v2/ocf"
+	"google.golang.org/protobuf/encoding/protowire"
+	v1 "k8s.io/api/core/v1"
+)
+
+// The published schemas of the protobuf and avro output formats
+var (
+	//go:embed schemas/eventdata.proto
+	EventDataProto string
+
+	//go:embed schemas/eventdata.avsc
+	EventDataAvro string
+)
+
+// Schema is a schema as known to a schema registry
+type Schema struct {
+	// Type is AVRO or PROTOBUF
+	Type       string
+	Definition string
+}
+
+// schemaEncoder is implemented by the encoders of binary formats with a
+// published schema. Transforms do not apply to them.
+type schemaEncoder interface {
+	Encoder
+
+	// Schema returns the schema of the records
+	Schema() Schema
+
+	// messageIndexes returns what follows the schema ID in the Confluent
+	// wire format before the record
+	messageIndexes() []byte
+}
+
+func init() {
+	RegisterEncoder("protobuf", func(c *OutputConfig) (Encoder, error) {
+		if c.Transform() != nil {
+			return nil, fmt.Errorf("transform keys cannot be used with the protobuf outputFormat, its schema is fixed")
+		}
+		return protobufEncoder{}, nil
+	})
+	RegisterEncoder("avro", func(c *OutputConfig) (Encoder, error) {
+		if c.Transform() != nil {
+			return nil, fmt.Errorf("transform keys cannot be used with the avro outputFormat, its schema is fixed")
+		}
+		schema, err := eventDataAvroSchema()
+		if err != nil {
+			return nil, err
+		}
+		return avroEncoder{schema: schema}, nil
+	})
+}
+
+// eventDataAvroSchema parses EventDataAvro once
+var eventDataAvroSchema = sync.OnceValues(func() (avro.Schema, error) {
+	schema, err := avro.Parse(EventDataAvro)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the avro schema: %w", err)
+	}
+	return schema, nil
+})
+
+// schemaRecord is the EventData as described by the published schemas
+type schemaRecord struct {
+	Verb        string       `avro:"verb"`
+	Event       schemaEvent  `avro:"event"`
+	OldEvent    *schemaEvent `avro:"old_event"`
+	ClusterID   string       `avro:"cluster_id"`
+	InstanceID  string       `avro:"instance_id"`
+	Sequence    int64        `avro:"sequence"`
+	ProcessedAt *time.Time   `avro:"processed_at"`
+}
+
+type schemaEvent struct {
+	Name                string            `avro:"name"`
+	Namespace           string            `avro:"namespace"`
+	UID                 string            `avro:"uid"`
+	ResourceVersion     string            `avro:"resource_version"`
+	Labels              map[string]string `avro:"labels"`
+	Annotations         map[string]string `avro:"annotations"`
+	InvolvedObject      schemaObjectRef   `avro:"involved_object"`
+	Reason              string            `avro:"reason"`
+	Message             string            `avro:"message"`
+	Type                string            `avro:"type"`
+	SourceComponent     string            `avro:"source_component"`
+	SourceHost          string            `avro:"source_host"`
+	FirstTimestamp      *time.Time        `avro:"first_timestamp"`
+	LastTimestamp       *time.Time        `avro:"last_timestamp"`
+	EventTime           *time.Time        `avro:"event_time"`
+	Count               int32             `avro:"count"`
+	Action              string            `avro:"action"`
+	ReportingController string            `avro:"reporting_controller"`
+	ReportingInstance   string            `avro:"reporting_instance"`
+	Related             *schemaObjectRef  `avro:"related"`
+}
+
+type schemaObjectRef struct {
+	Kind            string `avro:"kind"`
+	Namespace       string `avro:"namespace"`
+	Name            string `avro:"name"`
+	UID             string `avro:"uid"`
+	APIVersion      string `avro:"api_version"`
+	ResourceVersion string `avro:"resource_version"`
+	FieldPath       string `avro:"field_path"`
+}
+
+// newSchemaRecord maps e to the schema record
+func newSchemaRecord(e *EventData) *schemaRecord {
+	r := &schemaRecord{Verb: e.Verb, Event: newSchemaEvent(e.Event)}
+	if e.OldEvent != nil {
+		old := newSchemaEvent(e.OldEvent)
+		r.OldEvent = &old
+	}
+	if e.envelope != nil {
+		r.ClusterID = e.envelope.ClusterID
+		r.InstanceID = e.envelope.InstanceID
+		r.Sequence = int64(e.envelope.Sequence)
+		r.ProcessedAt = &e.envelope.ProcessedAt
+	}
+	return r
+}
+
+func newSchemaEvent(e *v1.Event) schemaEvent {
+	if e == nil {
+		return schemaEvent{}
+	}
+	s := schemaEvent{
+		Name:                e.Name,
+		Namespace:           e.Namespace,
+		UID:                 string(e.UID),
+		ResourceVersion:     e.ResourceVersion,
+		Labels:              e.Labels,
+		Annotations:         e.Annotations,
+		InvolvedObject:      newSchemaObjectRef(&e.InvolvedObject),
+		Reason:              e.Reason,
+		Message:             e.Message,
+		Type:                e.Type,
+		SourceComponent:     e.Source.Component,
+		SourceHost:          e.Source.Host,
+		Count:               e.Count,
+		Action:              e.Action,
+		ReportingController: e.ReportingController,
+		ReportingInstance:   e.ReportingInstance,
+	}
+	if !e.FirstTimestamp.IsZero() {
+		s.FirstTimestamp = &e.FirstTimestamp.Time
+	}
+	if !e.LastTimestamp.IsZero() {
+		s.LastTimestamp = &e.LastTimestamp.Time
+	}
+	if !e.EventTime.IsZero() {
+		s.EventTime = &e.EventTime.Time
+	}
+	if e.Related != nil {
+		related := newSchemaObjectRef(e.Related)
+		s.Related = &related
+	}
+	return s
+}
+
+func newSchemaObjectRef(o *v1.ObjectReference) schemaObjectRef {
+	return schemaObjectRef{
+		Kind:            o.Kind,
+		Namespace:       o.Namespace,
+		Name:            o.Name,
+		UID:             string(o.UID),
+		APIVersion:      o.APIVersion,
+		ResourceVersion: o.ResourceVersion,
+		FieldPath:       o.FieldPath,
+	}
+}
+
+// protobufEncoder writes EventData messages of EventDataProto with the
+// fields in the order of their numbers, so equal events give equal records.
+// Batches are length-delimited: every message is preceded by its size as a
+// varint.
+type protobufEncoder struct{}
+
+func (protobufEncoder) Encode(e *EventData) ([]byte, error) {
+	r := newSchemaRecord(e)
+	var b []byte
+	b = appendProtoString(b, 1, r.Verb)
+	b = appendProtoMessage(b, 2, protoEvent(&r.Event))
+	if r.OldEvent != nil {
+		b = appendProtoMessage(b, 3, protoEvent(r.OldEvent))
+	}
+	b = appendProtoString(b, 4, r.ClusterID)
+	b = appendProtoString(b, 5, r.InstanceID)
+	b = appendProtoVarint(b, 6, uint64(r.Sequence))
+	b = appendProtoMillis(b, 7, r.ProcessedAt)
+	return b, nil
+}
+
+func (enc protobufEncoder) WriteBatch(w io.Writer, events []EventData) error {
+	for i := range events {
+		b, err := enc.Encode(&events[i])
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(protowire.AppendBytes(nil, b)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (protobufEncoder) ContentType() string {
+	return "application/x-protobuf"
+}
+
+func (protobufEncoder) Schema() Schema {
+	return Schema{Type: "PROTOBUF", Definition: EventDataProto}
+}
+
+// messageIndexes implements schemaEncoder, EventData is the first message
+func (protobufEncoder) messageIndexes() []byte {
+	return []byte{0}
+}
+
+func protoEvent(e *schemaEvent) []byte {
+	var b []byte
+	b = appendProtoString(b, 1, e.Name)
+	b = appendProtoString(b, 2, e.Namespace)
+	b = appendProtoString(b, 3, e.UID)
+	b = appendProtoString(b, 4, e.ResourceVersion)
+	b = appendProtoStringMap(b, 5, e.Labels)
+	b = appendProtoStringMap(b, 6, e.Annotations)
+	b = appendProtoMessage(b, 7, protoObjectRef(&e.InvolvedObject))
+	b = appendProtoString(b, 8, e.Reason)
+	b = appendProtoString(b, 9, e.Message)
+	b = appendProtoString(b, 10, e.Type)
+	b = appendProtoString(b, 11, e.SourceComponent)
+	b = appendProtoString(b, 12, e.SourceHost)
+	b = appendProtoMillis(b, 13, e.FirstTimestamp)
+	b = appendProtoMillis(b, 14, e.LastTimestamp)
+	b = appendProtoMillis(b, 15, e.EventTime)
+	b = appendProtoVarint(b, 16, uint64(int64(e.Count)))
+	b = appendProtoString(b, 17, e.Action)
+	b = appendProtoString(b, 18, e.ReportingController)
+	b = appendProtoString(b, 19, e.ReportingInstance)
+	if e.Related != nil {
+		b = appendProtoMessage(b, 20, protoObjectRef(e.Related))
+	}
+	return b
+}
+
+func protoObjectRef(o *schemaObjectRef) []byte {
+	var b []byte
+	b = appendProtoString(b, 1, o.Kind)
+	b = appendProtoString(b, 2, o.Namespace)
+	b = appendProtoString(b, 3, o.Name)
+	b = appendProtoString(b, 4, o.UID)
+	b = appendProtoString(b, 5, o.APIVersion)
+	b = appendProtoString(b, 6, o.ResourceVersion)
+	b = appendProtoString(b, 7, o.FieldPath)
+	return b
+}
+
+// The appendProto helpers follow proto3: zero values are not written
+
+func appendProtoString(b []byte, num protowire.Number, v string) []byte {
+	if v == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, v)
+}
+
+func appendProtoVarint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func appendProtoMillis(b []byte, num protowire.Number, t *time.Time) []byte {
+	if t == nil {
+		return b
+	}
+	return appendProtoVarint(b, num, uint64(t.UnixMilli()))
+}
+
+func appendProtoMessage(b []byte, num protowire.Number, m []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, m)
+}
+
+// appendProtoStringMap writes the entries of a map<string, string> sorted
+// by key
+func appendProtoStringMap(b []byte, num protowire.Number, values map[string]string) []byte {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		entry := appendProtoString(nil, 1, k)
+		entry = appendProtoString(entry, 2, values[k])
+		b = appendProtoMessage(b, num, entry)
+	}
+	return b
+}
+
+// avroEncoder writes EventData records of EventDataAvro. Batches are Avro
+// object container files.
+type avroEncoder struct {
+	schema avro.Schema
+}
+
+func (enc avroEncoder) Encode(e *EventData) ([]byte, error) {
+	return avro.Marshal(enc.schema, newSchemaRecord(e))
+}
+
+func (enc avroEncoder) WriteBatch(w io.Writer, events []EventData) error {
+	ow, err := ocf.NewEncoderWithSchema(enc.schema, w)
+	if err != nil {
+		return err
+	}
+	for i := range events {
+		if err := ow.Encode(newSchemaRecord(&events[i])); err != nil {
+			return err
+		}
+	}
+	return ow.Close()
+}
+
+func (avroEncoder) ContentType() string {
+	return "application/avro"
+}
+
+func (avroEncoder) Schema() Schema {
+	return Schema{Type: "AVRO", Definition: EventDataAvro}
+}
+
+// messageIndexes implements schemaEncoder, avro has none
+func (avroEncoder) messageIndexes() []byte {
+	return nil
+}
+
+// confluentWireFormat frames record as expected by Confluent serializers:
+// a zero magic byte, the schema ID as a big-endian uint32 and the message
+// indexes of enc.
+func confluentWireFormat(id int, enc schemaEncoder, record []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(0)
+	_ = binary.Write(&buf, binary.BigEndian, uint32(id))
+	buf.Write(enc.messageIndexes())
+	buf.Write(record)
+	return buf.Bytes()
+}
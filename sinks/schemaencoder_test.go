@@ -0,0 +1,155 @@
+package sinks
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/protocompile"
+	"github.com/hamba/avro/v2"
+	"github.com/hamba/avro/v2/ocf"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func schemaTestEvent() *v1.Event {
+	return &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "web-1.17b", Namespace: "default", UID: "0b5e", ResourceVersion: "42",
+			Labels: map[string]string{"app": "web"},
+		},
+		InvolvedObject: v1.ObjectReference{Kind: "Pod", Namespace: "default", Name: "web-1"},
+		Related:        &v1.ObjectReference{Kind: "Node", Name: "node-1"},
+		Reason:         "BackOff",
+		Message:        "Back-off restarting failed container",
+		Type:           "Warning",
+		Source:         v1.EventSource{Component: "kubelet", Host: "node-1"},
+		Count:          3,
+		LastTimestamp:  metav1.NewTime(time.Date(2024, 3, 7, 10, 0, 0, 0, time.UTC)),
+	}
+}
+
+// eventDataDescriptor compiles EventDataProto, the published schema the
+// records of the protobuf encoder are checked against
+func eventDataDescriptor() (protoreflect.MessageDescriptor, error) {
+	compiler := protocompile.Compiler{
+		Resolver: &protocompile.SourceResolver{
+			Accessor: protocompile.SourceAccessorFromMap(map[string]string{"eventdata.proto": EventDataProto}),
+		},
+	}
+	files, err := compiler.Compile(context.Background(), "eventdata.proto")
+	if err != nil {
+		return nil, err
+	}
+	return files[0].Messages().ByName("EventData"), nil
+}
+
+func TestProtobufEncoder(t *testing.T) {
+	enc, err := NewEncoder(&OutputConfig{Format: "protobuf"})
+	require.NoError(t, err)
+	desc, err := eventDataDescriptor()
+	require.NoError(t, err)
+
+	s := &eventShaper{envelopes: NewEnvelopes("cluster-1", "eventrouter-1"), encoder: enc}
+	e := s.eventData(schemaTestEvent(), &v1.Event{Reason: "BackOff", Count: 2})
+	b, err := enc.Encode(&e)
+	require.NoError(t, err)
+
+	m := dynamicpb.NewMessage(desc)
+	require.NoError(t, proto.Unmarshal(b, m))
+	get := func(m protoreflect.Message, name string) protoreflect.Value {
+		return m.Get(m.Descriptor().Fields().ByName(protoreflect.Name(name)))
+	}
+	require.Equal(t, "UPDATED", get(m, "verb").String())
+	require.Equal(t, "cluster-1", get(m, "cluster_id").String())
+	require.Equal(t, uint64(1), get(m, "sequence").Uint())
+	event := get(m, "event").Message()
+	require.Equal(t, "BackOff", get(event, "reason").String())
+	require.Equal(t, int64(3), get(event, "count").Int())
+	require.Equal(t, int64(1709805600000), get(event, "last_timestamp_ms").Int())
+	require.Equal(t, int64(0), get(event, "first_timestamp_ms").Int())
+	require.Equal(t, "web", get(event, "labels").Map().Get(protoreflect.ValueOfString("app").MapKey()).String())
+	require.Equal(t, "web-1", get(get(event, "involved_object").Message(), "name").String())
+	require.Equal(t, "node-1", get(get(event, "related").Message(), "name").String())
+	require.Equal(t, int64(2), get(get(m, "old_event").Message(), "count").Int())
+
+	// Batches are length-delimited
+	var buf bytes.Buffer
+	require.NoError(t, enc.WriteBatch(&buf, []EventData{e, e}))
+	rest := buf.Bytes()
+	for i := 0; i < 2; i++ {
+		msg, n := protowire.ConsumeBytes(rest)
+		require.Greater(t, n, 0)
+		require.Equal(t, b, msg)
+		rest = rest[n:]
+	}
+	require.Empty(t, rest)
+}
+
+func TestAvroEncoder(t *testing.T) {
+	enc, err := NewEncoder(&OutputConfig{Format: "avro"})
+	require.NoError(t, err)
+	schema, err := eventDataAvroSchema()
+	require.NoError(t, err)
+
+	e := NewEventData(schemaTestEvent(), nil)
+	b, err := enc.Encode(&e)
+	require.NoError(t, err)
+
+	var got schemaRecord
+	require.NoError(t, avro.Unmarshal(schema, b, &got))
+	require.Equal(t, "ADDED", got.Verb)
+	require.Nil(t, got.OldEvent)
+	require.Nil(t, got.ProcessedAt)
+	require.Equal(t, "BackOff", got.Event.Reason)
+	require.Equal(t, map[string]string{"app": "web"}, got.Event.Labels)
+	require.Equal(t, "node-1", got.Event.Related.Name)
+	require.True(t, got.Event.LastTimestamp.Equal(time.Date(2024, 3, 7, 10, 0, 0, 0, time.UTC)))
+	require.Nil(t, got.Event.FirstTimestamp)
+
+	// Batches are object container files
+	var buf bytes.Buffer
+	require.NoError(t, enc.WriteBatch(&buf, []EventData{e, e}))
+	dec, err := ocf.NewDecoder(&buf)
+	require.NoError(t, err)
+	n := 0
+	for dec.HasNext() {
+		var r schemaRecord
+		require.NoError(t, dec.Decode(&r))
+		require.Equal(t, "web-1", r.Event.InvolvedObject.Name)
+		n++
+	}
+	require.NoError(t, dec.Error())
+	require.Equal(t, 2, n)
+}
+
+func TestSchemaEncoders_transform(t *testing.T) {
+	for _, format := range []string{"protobuf", "avro"} {
+		c := &OutputConfig{Format: format, TransformConfig: TransformConfig{Drop: []string{"event.message"}}}
+		require.EqualError(t, c.Validate(), "transform keys cannot be used with the "+format+" outputFormat, its schema is fixed")
+	}
+}
+
+func TestSchemaEncoders_jsonValue(t *testing.T) {
+	enc, err := NewEncoder(&OutputConfig{Format: "protobuf"})
+	require.NoError(t, err)
+	s := &eventShaper{encoder: enc}
+	e := s.eventData(schemaTestEvent(), nil)
+
+	// Binary records are base64 encoded where JSON is needed
+	got, err := s.encodeJSONValue(&e)
+	require.NoError(t, err)
+	require.Equal(t, byte('"'), got[0])
+
+	ce, err := (&CloudEvents{typePrefix: "io.k8s.event"}).event(s, &e)
+	require.NoError(t, err)
+	require.Equal(t, "application/x-protobuf", ce.DataContentType)
+	require.Empty(t, ce.Data)
+	require.NotEmpty(t, ce.DataBase64)
+}
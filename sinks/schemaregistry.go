@@ -0,0 +1,143 @@
+/*
+Copyright 2017 The Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sinks
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// schemaRegistryContentType is the media type of the schema registry API
+const schemaRegistryContentType = "application/vnd.schemaregistry.v1+json"
+
+// SchemaRegistryConfig connects a sink to a Confluent compatible schema
+// registry. The schema of the avro or protobuf output format is registered,
+// or only looked up, under Subject and records carry its ID in the Confluent
+// wire format.
+type SchemaRegistryConfig struct {
+	URL string `mapstructure:"schemaRegistryUrl"`
+
+	// Subject defaults to "<topic>-value", the topic name strategy
+	Subject string `mapstructure:"schemaRegistrySubject"`
+
+	// AutoRegister registers the schema when it is missing, otherwise it
+	// must have been registered beforehand
+	AutoRegister bool `mapstructure:"schemaRegistryAutoRegister"`
+
+	User     string `mapstructure:"schemaRegistryUser"`
+	Password string `mapstructure:"schemaRegistryPassword"`
+}
+
+// validate checks the registry settings against the encoder of the sink
+func (c *SchemaRegistryConfig) validate(enc Encoder) error {
+	if c.URL == "" {
+		return nil
+	}
+	var errs []error
+	if _, err := url.Parse(c.URL); err != nil {
+		errs = append(errs, fmt.Errorf("invalid schemaRegistryUrl: %w", err))
+	}
+	if _, ok := enc.(schemaEncoder); !ok {
+		errs = append(errs, errors.New("schemaRegistryUrl needs the avro or protobuf outputFormat"))
+	}
+	if (c.User == "") != (c.Password == "") {
+		errs = append(errs, errors.New("schemaRegistryUser and schemaRegistryPassword must be set together"))
+	}
+	return errors.Join(errs...)
+}
+
+// SchemaRegistry is a client of the Confluent schema registry REST API
+type SchemaRegistry struct {
+	client *resty.Client
+}
+
+// NewSchemaRegistry constructs a client of the registry at baseURL. user
+// and password enable basic authentication when set.
+func NewSchemaRegistry(baseURL string, user string, password string) *SchemaRegistry {
+	client := resty.New().
+		SetBaseURL(baseURL).
+		SetHeader("Content-Type", schemaRegistryContentType).
+		SetHeader("Accept", schemaRegistryContentType)
+	if user != "" {
+		client.SetBasicAuth(user, password)
+	}
+	return &SchemaRegistry{client: client}
+}
+
+type schemaRequest struct {
+	Schema     string `json:"schema"`
+	SchemaType string `json:"schemaType,omitempty"`
+}
+
+type schemaResponse struct {
+	ID int `json:"id"`
+}
+
+type schemaRegistryError struct {
+	ErrorCode int    `json:"error_code"`
+	Message   string `json:"message"`
+}
+
+// Register registers s under subject and returns its ID. Registering a
+// schema again returns the ID it already has.
+func (r *SchemaRegistry) Register(subject string, s Schema) (int, error) {
+	return r.post("/subjects/"+url.PathEscape(subject)+"/versions", s)
+}
+
+// Lookup returns the ID of s, which must be registered under subject
+func (r *SchemaRegistry) Lookup(subject string, s Schema) (int, error) {
+	return r.post("/subjects/"+url.PathEscape(subject), s)
+}
+
+func (r *SchemaRegistry) post(path string, s Schema) (int, error) {
+	req := schemaRequest{Schema: s.Definition}
+	// AVRO is the default schema type and older registries only know it
+	if s.Type != "AVRO" {
+		req.SchemaType = s.Type
+	}
+	var result schemaResponse
+	var apiErr schemaRegistryError
+	resp, err := r.client.R().
+		SetBody(req).
+		SetResult(&result).
+		SetError(&apiErr).
+		Post(path)
+	if err != nil {
+		return 0, fmt.Errorf("schema registry: %w", err)
+	}
+	if resp.IsError() {
+		return 0, fmt.Errorf("schema registry: %s: %d %s", path, apiErr.ErrorCode, apiErr.Message)
+	}
+	return result.ID, nil
+}
+
+// schemaID returns the ID of the schema of enc in the registry configured
+// by c, registering it when AutoRegister is set.
+func (c *SchemaRegistryConfig) schemaID(enc schemaEncoder, defaultSubject string) (int, error) {
+	subject := c.Subject
+	if subject == "" {
+		subject = defaultSubject
+	}
+	registry := NewSchemaRegistry(c.URL, c.User, c.Password)
+	if c.AutoRegister {
+		return registry.Register(subject, enc.Schema())
+	}
+	return registry.Lookup(subject, enc.Schema())
+}
@@ -0,0 +1,144 @@
+package sinks
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// testSchemaRegistry is an in-process stand-in for a Confluent schema
+// registry, implementing the register and lookup endpoints.
+type testSchemaRegistry struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	schemas  map[string]int
+	requests []schemaRequest
+}
+
+func newTestSchemaRegistry(t *testing.T) *testSchemaRegistry {
+	r := &testSchemaRegistry{schemas: map[string]int{}}
+	r.Server = httptest.NewServer(http.HandlerFunc(r.serve))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *testSchemaRegistry) serve(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", schemaRegistryContentType)
+	if user, pwd, ok := req.BasicAuth(); ok && (user != "registry" || pwd != "secret") {
+		w.WriteHeader(http.StatusUnauthorized)
+		_ = json.NewEncoder(w).Encode(schemaRegistryError{ErrorCode: 40101, Message: "Unauthorized"})
+		return
+	}
+	var body schemaRequest
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, body)
+	subject, register := strings.CutSuffix(strings.TrimPrefix(req.URL.Path, "/subjects/"), "/versions")
+	key := subject + "\x00" + body.SchemaType + "\x00" + body.Schema
+	id, ok := r.schemas[key]
+	switch {
+	case ok:
+	case register:
+		id = len(r.schemas) + 1
+		r.schemas[key] = id
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		_ = json.NewEncoder(w).Encode(schemaRegistryError{ErrorCode: 40403, Message: "Schema not found"})
+		return
+	}
+	_ = json.NewEncoder(w).Encode(schemaResponse{ID: id})
+}
+
+func TestSchemaRegistry(t *testing.T) {
+	srv := newTestSchemaRegistry(t)
+	registry := NewSchemaRegistry(srv.URL, "registry", "secret")
+	protoSchema := Schema{Type: "PROTOBUF", Definition: EventDataProto}
+	avroSchema := Schema{Type: "AVRO", Definition: EventDataAvro}
+
+	_, err := registry.Lookup("events-value", protoSchema)
+	require.EqualError(t, err, "schema registry: /subjects/events-value: 40403 Schema not found")
+
+	id, err := registry.Register("events-value", protoSchema)
+	require.NoError(t, err)
+	require.Equal(t, 1, id)
+	id, err = registry.Register("events-value", protoSchema)
+	require.NoError(t, err)
+	require.Equal(t, 1, id)
+	id, err = registry.Lookup("events-value", protoSchema)
+	require.NoError(t, err)
+	require.Equal(t, 1, id)
+
+	id, err = registry.Register("archive-value", avroSchema)
+	require.NoError(t, err)
+	require.Equal(t, 2, id)
+	require.Equal(t, "PROTOBUF", srv.requests[0].SchemaType)
+	require.Empty(t, srv.requests[len(srv.requests)-1].SchemaType)
+
+	_, err = NewSchemaRegistry(srv.URL, "registry", "wrong").Register("events-value", protoSchema)
+	require.EqualError(t, err, "schema registry: /subjects/events-value/versions: 40101 Unauthorized")
+}
+
+func TestKafkaSink_schemaRegistry(t *testing.T) {
+	srv := newTestSchemaRegistry(t)
+	c := defaultKafkaSinkConfig()
+	c.Topic = "events"
+	c.Format = "protobuf"
+	c.SchemaRegistryConfig.URL = srv.URL
+	require.NoError(t, c.Validate())
+
+	enc := c.Encoder().(schemaEncoder)
+	c.AutoRegister = false
+	_, err := c.schemaID(enc, "events-value")
+	require.Error(t, err)
+	c.AutoRegister = true
+	id, err := c.schemaID(enc, "events-value")
+	require.NoError(t, err)
+
+	ks := &KafkaSink{Topic: "events", schemaEnc: enc, schemaID: id}
+	ks.configure(&c.OutputConfig)
+	msg, err := ks.message(schemaTestEvent(), nil)
+	require.NoError(t, err)
+	value, err := msg.Value.Encode()
+	require.NoError(t, err)
+
+	// Magic byte, schema ID and the message index of EventData
+	require.Equal(t, byte(0), value[0])
+	require.Equal(t, uint32(id), binary.BigEndian.Uint32(value[1:5]))
+	require.Equal(t, byte(0), value[5])
+	desc, err := eventDataDescriptor()
+	require.NoError(t, err)
+	got, want := dynamicpb.NewMessage(desc), dynamicpb.NewMessage(desc)
+	require.NoError(t, proto.Unmarshal(value[6:], got))
+	record, err := enc.Encode(&EventData{Verb: "ADDED", Event: schemaTestEvent()})
+	require.NoError(t, err)
+	require.NoError(t, proto.Unmarshal(record, want))
+	require.True(t, proto.Equal(want, got))
+}
+
+func TestValidateConfig_schemaRegistry(t *testing.T) {
+	v := viper.New()
+	v.Set("sink", "kafka")
+	v.Set("schemaRegistryUrl", "http://registry:8081")
+	v.Set("schemaRegistryUser", "registry")
+	require.EqualError(t, ValidateConfig(v), "sink kafka: schemaRegistryUrl needs the avro or protobuf outputFormat\n"+
+		"sink kafka: schemaRegistryUser and schemaRegistryPassword must be set together")
+
+	v.Set("outputFormat", "avro")
+	v.Set("schemaRegistryPassword", "secret")
+	require.NoError(t, ValidateConfig(v))
+}
@@ -0,0 +1,214 @@
+/*
+Copyright 2017 The Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sinks
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/kuoss/eventrouter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/viper"
+	v1 "k8s.io/api/core/v1"
+)
+
+// SelfEventAnnotation marks the events tagged by the SelfSink
+const SelfEventAnnotation = "eventrouter.kuoss.io/self-event"
+
+// SelfEventsSuppressedTotal counts the self events dropped by the SelfSink
+var SelfEventsSuppressedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "eventrouter_self_events_suppressed_total",
+	Help: "Total number of events about eventrouter itself or its sink workloads that were not forwarded",
+})
+
+// SelfSink recognizes the events about the eventrouter pod and the workloads
+// its sinks write to, which could otherwise feed back into the sinks in a
+// loop. Depending on its policy it tags them with the SelfEventAnnotation or
+// drops them.
+type SelfSink struct {
+	policy    string
+	pod       workloadRef
+	workloads []workloadRef
+	sink      EventSinkInterface
+}
+
+// workloadRef identifies objects by namespace and name. A workload matches
+// the objects Kubernetes names after it too, such as the ReplicaSets and pods
+// of a Deployment.
+type workloadRef struct {
+	namespace string
+	name      string
+}
+
+// generatedChars are the characters of the suffixes Kubernetes appends to
+// the names of the objects it creates, which leave out vowels so that no
+// word is generated.
+const generatedChars = "[bcdfghjklmnpqrstvwxz2456789]"
+
+var (
+	// replicaSetSuffix is the pod-template-hash a Deployment appends to
+	// the names of its ReplicaSets
+	replicaSetSuffix = regexp.MustCompile(`^` + generatedChars + `{1,10}$`)
+
+	// podSuffix is what follows the name of a workload in the names of its
+	// pods: the pod-template-hash and random suffix of a Deployment, the
+	// random suffix of a ReplicaSet, DaemonSet or Job, or the ordinal of a
+	// StatefulSet
+	podSuffix = regexp.MustCompile(`^(` + generatedChars + `{1,10}-)?` + generatedChars + `{5}$|^[0-9]+$`)
+
+	// deploymentPod captures the name of the Deployment in the name of a
+	// pod it created
+	deploymentPod = regexp.MustCompile(`^(.+)-` + generatedChars + `{1,10}-` + generatedChars + `{5}$`)
+)
+
+// owns reports whether obj is w or one of the ReplicaSets and pods named
+// after it
+func (w workloadRef) owns(obj v1.ObjectReference) bool {
+	if obj.Namespace != w.namespace {
+		return false
+	}
+	if obj.Name == w.name {
+		return true
+	}
+	suffix, ok := strings.CutPrefix(obj.Name, w.name+"-")
+	if !ok {
+		return false
+	}
+	switch obj.Kind {
+	case "ReplicaSet":
+		return replicaSetSuffix.MatchString(suffix)
+	case "Pod":
+		return podSuffix.MatchString(suffix)
+	}
+	return false
+}
+
+// selfConfig holds the loop prevention keys applying to every sink
+type selfConfig struct {
+	// Policy is what happens to self events: forward, tag or drop
+	Policy string `mapstructure:"selfEventPolicy" validate:"oneof=forward tag drop"`
+
+	// PodName and PodNamespace identify the eventrouter pod, they default to
+	// the POD_NAME and POD_NAMESPACE environment variables set with the
+	// downward API
+	PodName      string `mapstructure:"selfPodName"`
+	PodNamespace string `mapstructure:"selfPodNamespace"`
+
+	// Workloads are the "namespace/name" of the workloads the sinks write
+	// to, e.g. "kafka/kafka-broker"
+	Workloads []string `mapstructure:"selfWorkloads"`
+}
+
+func defaultSelfConfig() *selfConfig {
+	return &selfConfig{
+		Policy:       "tag",
+		PodName:      os.Getenv("POD_NAME"),
+		PodNamespace: os.Getenv("POD_NAMESPACE"),
+	}
+}
+
+// Validate implements config.Validator
+func (c *selfConfig) Validate() error {
+	for _, w := range c.Workloads {
+		if _, err := parseWorkloadRef(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseWorkloadRef(s string) (workloadRef, error) {
+	ns, name, ok := strings.Cut(s, "/")
+	if !ok || ns == "" || name == "" || strings.Contains(name, "/") {
+		return workloadRef{}, fmt.Errorf("selfWorkloads: invalid workload %q, expected namespace/name", s)
+	}
+	return workloadRef{namespace: ns, name: name}, nil
+}
+
+// NewSelfSink constructs a SelfSink in front of sink, podName and
+// podNamespace may be empty when the pod is unknown. When podName is the name
+// of a pod created by a Deployment, the events about the Deployment, its
+// ReplicaSets and their pods are self events too.
+func NewSelfSink(policy string, podName string, podNamespace string, workloads []string, sink EventSinkInterface) (*SelfSink, error) {
+	s := &SelfSink{
+		policy: policy,
+		pod:    workloadRef{namespace: podNamespace, name: podName},
+		sink:   sink,
+	}
+	if m := deploymentPod.FindStringSubmatch(podName); m != nil && podNamespace != "" {
+		s.workloads = append(s.workloads, workloadRef{namespace: podNamespace, name: m[1]})
+	}
+	for _, w := range workloads {
+		ref, err := parseWorkloadRef(w)
+		if err != nil {
+			return nil, err
+		}
+		s.workloads = append(s.workloads, ref)
+	}
+	return s, nil
+}
+
+// IsSelfEvent reports whether e is about the eventrouter pod or one of the
+// sink workloads.
+func (s *SelfSink) IsSelfEvent(e *v1.Event) bool {
+	obj := e.InvolvedObject
+	if s.pod.name != "" && obj.Kind == "Pod" && obj.Name == s.pod.name &&
+		(s.pod.namespace == "" || obj.Namespace == s.pod.namespace) {
+		return true
+	}
+	for _, w := range s.workloads {
+		if w.owns(obj) {
+			return true
+		}
+	}
+	return false
+}
+
+// UpdateEvents implements the EventSinkInterface
+func (s *SelfSink) UpdateEvents(eNew *v1.Event, eOld *v1.Event) {
+	if s.policy == "forward" || !s.IsSelfEvent(eNew) {
+		s.sink.UpdateEvents(eNew, eOld)
+		return
+	}
+	if s.policy == "drop" {
+		SelfEventsSuppressedTotal.Inc()
+		return
+	}
+
+	// eNew is shared with the informer cache
+	tagged := eNew.DeepCopy()
+	if tagged.Annotations == nil {
+		tagged.Annotations = map[string]string{}
+	}
+	tagged.Annotations[SelfEventAnnotation] = "true"
+	s.sink.UpdateEvents(tagged, eOld)
+}
+
+// loadSelfConfig reads the loop prevention keys of v. It returns nil when
+// self events are forwarded as is or cannot be recognized.
+func loadSelfConfig(v *viper.Viper) (*selfConfig, error) {
+	cfg := defaultSelfConfig()
+	if err := config.Decode(v, cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Policy == "forward" || (cfg.PodName == "" && len(cfg.Workloads) == 0) {
+		return nil, nil
+	}
+	return cfg, nil
+}
@@ -0,0 +1,116 @@
+package sinks
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestSelfSink_IsSelfEvent(t *testing.T) {
+	s, err := NewSelfSink("drop", "eventrouter-7d9f-x2x4z", "kube-system", []string{"kafka/kafka-broker"}, &testSink{})
+	require.NoError(t, err)
+
+	testCases := []struct {
+		obj  v1.ObjectReference
+		want bool
+	}{
+		{v1.ObjectReference{Kind: "Pod", Namespace: "kube-system", Name: "eventrouter-7d9f-x2x4z"}, true},
+		{v1.ObjectReference{Kind: "Pod", Namespace: "default", Name: "eventrouter-7d9f-x2x4z"}, false},
+		{v1.ObjectReference{Kind: "Node", Name: "eventrouter-7d9f-x2x4z"}, false},
+		{v1.ObjectReference{Kind: "StatefulSet", Namespace: "kafka", Name: "kafka-broker"}, true},
+		{v1.ObjectReference{Kind: "Pod", Namespace: "kafka", Name: "kafka-broker-0"}, true},
+		{v1.ObjectReference{Kind: "Pod", Namespace: "kafka", Name: "kafka-brokers"}, false},
+		{v1.ObjectReference{Kind: "Pod", Namespace: "default", Name: "kafka-broker-0"}, false},
+		{v1.ObjectReference{Kind: "Deployment", Namespace: "kube-system", Name: "eventrouter"}, true},
+		{v1.ObjectReference{Kind: "ReplicaSet", Namespace: "kube-system", Name: "eventrouter-7d9f"}, true},
+		{v1.ObjectReference{Kind: "Pod", Namespace: "kube-system", Name: "eventrouter-7d9f-b6mqt"}, true},
+		{v1.ObjectReference{Kind: "Deployment", Namespace: "kube-system", Name: "eventrouter-exporter"}, false},
+		{v1.ObjectReference{Kind: "ReplicaSet", Namespace: "kube-system", Name: "eventrouter-exporter-7d9f"}, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.obj.Kind+"/"+tc.obj.Namespace+"/"+tc.obj.Name, func(t *testing.T) {
+			require.Equal(t, tc.want, s.IsSelfEvent(&v1.Event{InvolvedObject: tc.obj}))
+		})
+	}
+}
+
+func TestSelfSink_IsSelfEvent_workloadPrefix(t *testing.T) {
+	s, err := NewSelfSink("drop", "", "", []string{"kafka/kafka"}, &testSink{})
+	require.NoError(t, err)
+
+	testCases := []struct {
+		obj  v1.ObjectReference
+		want bool
+	}{
+		{v1.ObjectReference{Kind: "Deployment", Namespace: "kafka", Name: "kafka"}, true},
+		{v1.ObjectReference{Kind: "ReplicaSet", Namespace: "kafka", Name: "kafka-5c8b9f6d47"}, true},
+		{v1.ObjectReference{Kind: "Pod", Namespace: "kafka", Name: "kafka-5c8b9f6d47-x2x4z"}, true},
+		{v1.ObjectReference{Kind: "Pod", Namespace: "kafka", Name: "kafka-x2x4z"}, true},
+		{v1.ObjectReference{Kind: "Pod", Namespace: "kafka", Name: "kafka-2"}, true},
+		{v1.ObjectReference{Kind: "Deployment", Namespace: "kafka", Name: "kafka-connect"}, false},
+		{v1.ObjectReference{Kind: "ReplicaSet", Namespace: "kafka", Name: "kafka-connect-5c8b9f6d47"}, false},
+		{v1.ObjectReference{Kind: "Pod", Namespace: "kafka", Name: "kafka-connect-5c8b9f6d47-x2x4z"}, false},
+		{v1.ObjectReference{Kind: "Pod", Namespace: "kafka", Name: "kafka-connect-0"}, false},
+		{v1.ObjectReference{Kind: "Service", Namespace: "kafka", Name: "kafka-5c8b9f6d47"}, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.obj.Kind+"/"+tc.obj.Name, func(t *testing.T) {
+			require.Equal(t, tc.want, s.IsSelfEvent(&v1.Event{InvolvedObject: tc.obj}))
+		})
+	}
+}
+
+func TestSelfSink_UpdateEvents(t *testing.T) {
+	self := &v1.Event{InvolvedObject: v1.ObjectReference{Kind: "Pod", Namespace: "kube-system", Name: "eventrouter-1"}}
+	other := &v1.Event{InvolvedObject: v1.ObjectReference{Kind: "Pod", Namespace: "default", Name: "app-1"}}
+
+	t.Run("drop", func(t *testing.T) {
+		inner := &testSink{}
+		s, err := NewSelfSink("drop", "eventrouter-1", "kube-system", nil, inner)
+		require.NoError(t, err)
+		before := testutil.ToFloat64(SelfEventsSuppressedTotal)
+
+		s.UpdateEvents(self, nil)
+		s.UpdateEvents(other, nil)
+		require.Equal(t, []*v1.Event{other}, inner.events)
+		require.Equal(t, before+1, testutil.ToFloat64(SelfEventsSuppressedTotal))
+	})
+
+	t.Run("tag", func(t *testing.T) {
+		inner := &testSink{}
+		s, err := NewSelfSink("tag", "eventrouter-1", "kube-system", nil, inner)
+		require.NoError(t, err)
+
+		s.UpdateEvents(self, nil)
+		s.UpdateEvents(other, nil)
+		require.Len(t, inner.events, 2)
+		require.Equal(t, map[string]string{SelfEventAnnotation: "true"}, inner.events[0].Annotations)
+		require.Nil(t, self.Annotations, "the cached event must not be modified")
+		require.Same(t, other, inner.events[1])
+	})
+}
+
+func TestManufactureSink_self(t *testing.T) {
+	defer viper.Set("selfWorkloads", nil)
+	defer viper.Set("selfEventPolicy", nil)
+	viper.Set("sink", "glog")
+
+	viper.Set("selfWorkloads", []string{"kafka"})
+	_, err := ManufactureSink()
+	require.EqualError(t, err, `selfWorkloads: invalid workload "kafka", expected namespace/name`)
+
+	viper.Set("selfWorkloads", []string{"kafka/kafka-broker"})
+	viper.Set("selfEventPolicy", "ignore")
+	_, err = ManufactureSink()
+	require.EqualError(t, err, `selfEventPolicy: invalid value "ignore", must be one of: forward, tag, drop`)
+
+	viper.Set("selfEventPolicy", "drop")
+	sink, err := ManufactureSink()
+	require.NoError(t, err)
+	s, ok := sink.(*SelfSink)
+	require.True(t, ok, "Expected SelfSink")
+	require.Equal(t, &GlogSink{eventShaper{encoder: jsonEncoder{}}}, s.sink)
+}
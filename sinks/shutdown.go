@@ -0,0 +1,112 @@
+/*
+Copyright 2017 The Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sinks
+
+import (
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+var (
+	shutdownMu    sync.Mutex
+	shutdownFuncs []func()
+)
+
+// onShutdown registers f to flush and close a sink when Shutdown is called
+func onShutdown(f func()) {
+	shutdownMu.Lock()
+	defer shutdownMu.Unlock()
+	shutdownFuncs = append(shutdownFuncs, f)
+}
+
+// runUntilShutdown runs run in the background until Shutdown, which stops it
+// and waits for it to return
+func runUntilShutdown(run func(stopCh <-chan bool)) {
+	stop, done := make(chan bool), make(chan struct{})
+	go func() {
+		defer close(done)
+		run(stop)
+	}()
+	onShutdown(func() {
+		close(stop)
+		<-done
+	})
+}
+
+// Shutdown flushes the records buffered by the sinks and closes them, giving
+// up after timeout. It reports whether every sink was closed in time.
+func Shutdown(timeout time.Duration) bool {
+	shutdownMu.Lock()
+	funcs := shutdownFuncs
+	shutdownFuncs = nil
+	shutdownMu.Unlock()
+	return closeSinks(funcs, timeout)
+}
+
+// Rebuild builds the sink of the current configuration like ManufactureSink,
+// to replace the running sinks after a configuration reload. closeOld flushes
+// and closes the sinks built before, once they no longer receive events. On
+// error the sinks built before stay registered for Shutdown.
+func Rebuild() (sink EventSinkInterface, closeOld func(timeout time.Duration) bool, err error) {
+	shutdownMu.Lock()
+	old := shutdownFuncs
+	shutdownFuncs = nil
+	shutdownMu.Unlock()
+
+	sink, err = ManufactureSink()
+
+	shutdownMu.Lock()
+	built := shutdownFuncs
+	if err != nil {
+		shutdownFuncs = old
+	}
+	shutdownMu.Unlock()
+	if err != nil {
+		// The sinks built before the error are not used
+		for _, f := range built {
+			go f()
+		}
+		return nil, nil, err
+	}
+	return sink, func(timeout time.Duration) bool { return closeSinks(old, timeout) }, nil
+}
+
+// closeSinks calls funcs concurrently and waits for them up to timeout
+func closeSinks(funcs []func(), timeout time.Duration) bool {
+	var wg sync.WaitGroup
+	for _, f := range funcs {
+		wg.Add(1)
+		go func(f func()) {
+			defer wg.Done()
+			f()
+		}(f)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		glog.Warningf("Sinks not closed after %v, buffered records may be lost", timeout)
+		return false
+	}
+}
@@ -0,0 +1,50 @@
+package sinks
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShutdown(t *testing.T) {
+	var closed atomic.Int32
+	onShutdown(func() { closed.Add(1) })
+	onShutdown(func() { closed.Add(1) })
+	require.True(t, Shutdown(time.Second))
+	require.Equal(t, int32(2), closed.Load())
+
+	// The sinks are closed once
+	require.True(t, Shutdown(time.Second))
+	require.Equal(t, int32(2), closed.Load())
+
+	release := make(chan struct{})
+	defer close(release)
+	onShutdown(func() { <-release })
+	require.False(t, Shutdown(10*time.Millisecond))
+}
+
+func TestRebuild(t *testing.T) {
+	defer viper.Set("sink", nil)
+	defer Shutdown(time.Second)
+	var closed atomic.Int32
+	onShutdown(func() { closed.Add(1) })
+
+	viper.Set("sink", "nope")
+	_, _, err := Rebuild()
+	require.Error(t, err)
+
+	viper.Set("sink", "stdout")
+	sink, closeOld, err := Rebuild()
+	require.NoError(t, err)
+	require.IsType(t, &StdoutSink{}, sink)
+	require.Zero(t, closed.Load(), "the running sinks are closed by closeOld")
+	require.True(t, closeOld(time.Second))
+	require.Equal(t, int32(1), closed.Load())
+
+	// The sinks built before are no longer registered
+	require.True(t, Shutdown(time.Second))
+	require.Equal(t, int32(1), closed.Load())
+}
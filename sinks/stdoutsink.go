@@ -24,6 +24,18 @@ import (
 	v1 "k8s.io/api/core/v1"
 )
 
+func init() {
+	Register("stdout", SinkFactory{
+		NewConfig: func() interface{} { return &StdoutSinkConfig{} },
+		New: func(_ string, cfg interface{}) (EventSinkInterface, error) {
+			c := cfg.(*StdoutSinkConfig)
+			s := &StdoutSink{namespace: c.JSONNamespace}
+			s.configure(&c.OutputConfig)
+			return s, nil
+		},
+	})
+}
+
 // StdoutSink is the other basic sink
 // By default, Fluentd/ElasticSearch won't index glog formatted lines
 // By logging raw JSON to stdout, we will get automated indexing which
@@ -31,6 +43,16 @@ import (
 type StdoutSink struct {
 	// TODO: create a channel and buffer for scaling
 	namespace string
+	eventShaper
+}
+
+// StdoutSinkConfig is the configuration of the stdout sink
+type StdoutSinkConfig struct {
+	// JSONNamespace, when set, nests every event under this key. It applies
+	// to the json and ndjson output formats only.
+	JSONNamespace string `mapstructure:"stdoutJSONNamespace"`
+
+	OutputConfig `mapstructure:",squash"`
 }
 
 // NewStdoutSink will create a new StdoutSink with default options, returned as
@@ -42,21 +64,37 @@ func NewStdoutSink(namespace string) EventSinkInterface {
 
 // UpdateEvents implements the EventSinkInterface
 func (gs *StdoutSink) UpdateEvents(eNew *v1.Event, eOld *v1.Event) {
-	eData := NewEventData(eNew, eOld)
+	eData := gs.eventData(eNew, eOld)
 
-	if len(gs.namespace) > 0 {
+	// Rendered text and other formats cannot be nested under the namespace
+	if len(gs.namespace) > 0 && !gs.transform.IsText() && gs.nestsJSON() {
+		data, err := eData.document()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to transform event: %v", err)
+			return
+		}
 		namespacedData := map[string]interface{}{}
-		namespacedData[gs.namespace] = eData
+		namespacedData[gs.namespace] = data
 		if eJSONBytes, err := json.Marshal(namespacedData); err == nil {
 			fmt.Println(string(eJSONBytes))
 		} else {
 			fmt.Fprintf(os.Stderr, "Failed to json serialize event: %v", err)
 		}
 	} else {
-		if eJSONBytes, err := json.Marshal(eData); err == nil {
-			fmt.Println(string(eJSONBytes))
+		if b, err := gs.encode(&eData); err == nil {
+			fmt.Println(string(b))
 		} else {
-			fmt.Fprintf(os.Stderr, "Failed to json serialize event: %v", err)
+			fmt.Fprintf(os.Stderr, "Failed to serialize event: %v", err)
 		}
 	}
 }
+
+// nestsJSON reports whether the output format writes JSON documents that
+// can be nested under the namespace
+func (gs *StdoutSink) nestsJSON() bool {
+	switch gs.output().(type) {
+	case jsonEncoder, ndjsonEncoder:
+		return true
+	}
+	return false
+}
@@ -3,6 +3,7 @@ package sinks
 import (
 	"testing"
 
+	"github.com/spf13/viper"
 	"github.com/stretchr/testify/require"
 	v1 "k8s.io/api/core/v1"
 )
@@ -39,3 +40,15 @@ func TestUpdateEvents_stdoutsink(t *testing.T) {
 		})
 	}
 }
+
+func TestManufactureSink_transform(t *testing.T) {
+	defer viper.Set("transformKeep", nil)
+	viper.Set("sink", "stdout")
+	viper.Set("transformKeep", []string{"event.reason"})
+
+	sink, err := ManufactureSink()
+	require.NoError(t, err)
+	stdoutSink, ok := sink.(*StdoutSink)
+	require.True(t, ok, "Expected StdoutSink")
+	require.NotNil(t, stdoutSink.transform)
+}
@@ -0,0 +1,117 @@
+package sinks
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testCerts is a CA with a server certificate for serverName and a client
+// certificate, the CA and the client key pair are written as PEM files
+type testCerts struct {
+	caFile   string
+	certFile string
+	keyFile  string
+
+	// server is presented by test servers, which verify client certificates
+	// with clientCAs
+	server    tls.Certificate
+	clientCAs *x509.CertPool
+}
+
+func newTestCerts(t *testing.T, serverName string) *testCerts {
+	t.Helper()
+	dir := t.TempDir()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	ca, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	issue := func(serial int64, cn string, usage x509.ExtKeyUsage, dnsNames ...string) ([]byte, *ecdsa.PrivateKey) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: cn},
+			DNSNames:     dnsNames,
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		}, ca, &key.PublicKey, caKey)
+		require.NoError(t, err)
+		return der, key
+	}
+	writePEM := func(name, typ string, der []byte) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600))
+		return path
+	}
+
+	c := &testCerts{caFile: writePEM("ca.crt", "CERTIFICATE", caDER), clientCAs: x509.NewCertPool()}
+	c.clientCAs.AddCert(ca)
+
+	serverDER, serverKey := issue(2, serverName, x509.ExtKeyUsageServerAuth, serverName)
+	c.server = tls.Certificate{Certificate: [][]byte{serverDER}, PrivateKey: serverKey}
+
+	clientDER, clientKey := issue(3, "eventrouter", x509.ExtKeyUsageClientAuth)
+	keyDER, err := x509.MarshalECPrivateKey(clientKey)
+	require.NoError(t, err)
+	c.certFile = writePEM("client.crt", "CERTIFICATE", clientDER)
+	c.keyFile = writePEM("client.key", "EC PRIVATE KEY", keyDER)
+	return c
+}
+
+// serverConfig returns the TLS configuration of a test server requiring
+// client certificates signed by the CA
+func (c *testCerts) serverConfig() *tls.Config {
+	return &tls.Config{
+		Certificates: []tls.Certificate{c.server},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    c.clientCAs,
+	}
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	certs := newTestCerts(t, "kafka.test")
+
+	c, err := newTLSConfig(certs.caFile, certs.certFile, certs.keyFile, "kafka.test", false)
+	require.NoError(t, err)
+	require.Equal(t, "kafka.test", c.ServerName)
+	require.NotNil(t, c.RootCAs)
+	require.Len(t, c.Certificates, 1)
+
+	c, err = newTLSConfig("", "", "", "", true)
+	require.NoError(t, err)
+	require.Nil(t, c.RootCAs)
+	require.True(t, c.InsecureSkipVerify)
+
+	_, err = newTLSConfig(filepath.Join(t.TempDir(), "missing.crt"), "", "", "", false)
+	require.ErrorContains(t, err, "failed to read CA bundle")
+	_, err = newTLSConfig(certs.keyFile, "", "", "", false)
+	require.EqualError(t, err, "no certificates found in "+certs.keyFile)
+	_, err = newTLSConfig("", certs.certFile, certs.caFile, "", false)
+	require.ErrorContains(t, err, "failed to load client certificate")
+}
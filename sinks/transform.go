@@ -0,0 +1,317 @@
+/*
+Copyright 2017 The Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sinks
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// TransformConfig reshapes the EventData before a sink serializes it. It is
+// embedded in the configuration of every sink that writes EventData, so each
+// sink instance has its own transform. The stages run in this order:
+//
+//  0. transformDiff replaces the old_event of updates with the fields that
+//     changed. "changes" adds a "changes" field mapping their paths to their
+//     old and new values, "jsonpatch" adds a "patch" field listing the
+//     RFC 6902 operations turning old_event into event.
+//     transformKeepOldEvent keeps old_event as well.
+//  1. transformKeep keeps only the listed paths, e.g. "event.reason"
+//  2. transformDrop removes the listed paths, e.g. "event.metadata.managedFields"
+//  3. transformRename moves values, e.g. "event.involvedObject.kind=kind"
+//  4. transformAdd sets static fields, e.g. "cluster=prod"
+//  5. transformTemplate renders the result as text with text/template,
+//     e.g. "{{.verb}} {{.event.reason}}: {{.event.message}}"
+//
+// Paths are the dot separated JSON field names of the EventData. A path
+// crossing a list applies to every element of the list.
+type TransformConfig struct {
+	Keep     []string `mapstructure:"transformKeep"`
+	Drop     []string `mapstructure:"transformDrop"`
+	Rename   []string `mapstructure:"transformRename"`
+	Add      []string `mapstructure:"transformAdd"`
+	Template string   `mapstructure:"transformTemplate"`
+
+	Diff         string `mapstructure:"transformDiff"`
+	KeepOldEvent bool   `mapstructure:"transformKeepOldEvent"`
+
+	// transform is the compiled configuration, set by Validate
+	transform *Transform
+}
+
+// Validate implements config.Validator by compiling the transform
+func (c *TransformConfig) Validate() error {
+	t, err := NewTransform(c)
+	if err != nil {
+		return err
+	}
+	c.transform = t
+	return nil
+}
+
+// Transform returns the compiled transform, nil when none is configured
+func (c *TransformConfig) Transform() *Transform {
+	return c.transform
+}
+
+// Transform is a compiled TransformConfig. A nil *Transform leaves the
+// EventData untouched.
+type Transform struct {
+	diff         string
+	keepOldEvent bool
+
+	keep   [][]string
+	drop   [][]string
+	rename [][2][]string
+	add    []transformField
+	tmpl   *template.Template
+}
+
+type transformField struct {
+	path  []string
+	value string
+}
+
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+}
+
+// empty reports whether c does not change anything
+func (c *TransformConfig) empty() bool {
+	return len(c.Keep) == 0 && len(c.Drop) == 0 && len(c.Rename) == 0 && len(c.Add) == 0 && c.Template == "" && c.Diff == ""
+}
+
+// NewTransform compiles cfg. It returns nil when cfg does not change
+// anything.
+func NewTransform(cfg *TransformConfig) (*Transform, error) {
+	if cfg.empty() {
+		return nil, nil
+	}
+
+	var errs []error
+	t := &Transform{diff: cfg.Diff, keepOldEvent: cfg.KeepOldEvent}
+	switch cfg.Diff {
+	case "", "changes", "jsonpatch":
+	default:
+		errs = append(errs, fmt.Errorf("transformDiff: invalid value %q, must be one of: changes, jsonpatch", cfg.Diff))
+	}
+	for _, p := range cfg.Keep {
+		t.keep = append(t.keep, splitPath(p))
+	}
+	for _, p := range cfg.Drop {
+		t.drop = append(t.drop, splitPath(p))
+	}
+	for _, r := range cfg.Rename {
+		from, to, ok := strings.Cut(r, "=")
+		if !ok || from == "" || to == "" {
+			errs = append(errs, fmt.Errorf("transformRename: invalid rename %q, expected from=to", r))
+			continue
+		}
+		t.rename = append(t.rename, [2][]string{splitPath(from), splitPath(to)})
+	}
+	for _, a := range cfg.Add {
+		path, value, ok := strings.Cut(a, "=")
+		if !ok || path == "" {
+			errs = append(errs, fmt.Errorf("transformAdd: invalid field %q, expected path=value", a))
+			continue
+		}
+		t.add = append(t.add, transformField{path: splitPath(path), value: value})
+	}
+	if cfg.Template != "" {
+		tmpl, err := template.New("transform").Funcs(templateFuncs).Option("missingkey=zero").Parse(cfg.Template)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("transformTemplate: %w", err))
+		}
+		t.tmpl = tmpl
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func splitPath(p string) []string {
+	return strings.Split(strings.TrimSpace(p), ".")
+}
+
+// IsText reports whether t renders text instead of JSON
+func (t *Transform) IsText() bool {
+	return t != nil && t.tmpl != nil
+}
+
+// Apply returns the generic JSON document of e after the diff, keep, drop,
+// rename and add stages.
+func (t *Transform) Apply(e EventData) (map[string]interface{}, error) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return nil, fmt.Errorf("failed to json serialize event: %w", err)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("failed to json deserialize event: %w", err)
+	}
+	if t == nil {
+		return doc, nil
+	}
+
+	if oldEvent, ok := doc["old_event"].(map[string]interface{}); ok && t.diff != "" {
+		event, _ := doc["event"].(map[string]interface{})
+		if t.diff == "changes" {
+			doc["changes"] = diffChanges(oldEvent, event)
+		} else {
+			doc["patch"] = diffPatch(oldEvent, event)
+		}
+		if !t.keepOldEvent {
+			delete(doc, "old_event")
+		}
+	}
+	if len(t.keep) > 0 {
+		kept, _ := keepPaths(doc, t.keep).(map[string]interface{})
+		if kept == nil {
+			kept = map[string]interface{}{}
+		}
+		doc = kept
+	}
+	for _, p := range t.drop {
+		deletePath(doc, p)
+	}
+	for _, r := range t.rename {
+		if v, ok := getPath(doc, r[0]); ok {
+			deletePath(doc, r[0])
+			setPath(doc, r[1], v)
+		}
+	}
+	for _, f := range t.add {
+		setPath(doc, f.path, f.value)
+	}
+	return doc, nil
+}
+
+// render executes the template of t on doc, the document written by a sink
+func (t *Transform) render(doc interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := t.tmpl.Execute(&buf, doc); err != nil {
+		return nil, fmt.Errorf("failed to render template: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// getPath returns the value at path. Lists are collected element-wise.
+func getPath(v interface{}, path []string) (interface{}, bool) {
+	if len(path) == 0 {
+		return v, true
+	}
+	switch node := v.(type) {
+	case map[string]interface{}:
+		child, ok := node[path[0]]
+		if !ok {
+			return nil, false
+		}
+		return getPath(child, path[1:])
+	case []interface{}:
+		var out []interface{}
+		for _, item := range node {
+			if got, ok := getPath(item, path); ok {
+				out = append(out, got)
+			}
+		}
+		return out, out != nil
+	}
+	return nil, false
+}
+
+// keepPaths returns the parts of v selected by paths, nil when none is
+// found. Lists keep their shape with the selected parts of each element.
+func keepPaths(v interface{}, paths [][]string) interface{} {
+	for _, p := range paths {
+		if len(p) == 0 {
+			return v
+		}
+	}
+	switch node := v.(type) {
+	case map[string]interface{}:
+		children := map[string][][]string{}
+		for _, p := range paths {
+			children[p[0]] = append(children[p[0]], p[1:])
+		}
+		var out map[string]interface{}
+		for k, rest := range children {
+			if child, ok := node[k]; ok {
+				if kept := keepPaths(child, rest); kept != nil {
+					if out == nil {
+						out = map[string]interface{}{}
+					}
+					out[k] = kept
+				}
+			}
+		}
+		if out == nil {
+			return nil
+		}
+		return out
+	case []interface{}:
+		var out []interface{}
+		for _, item := range node {
+			if kept := keepPaths(item, paths); kept != nil {
+				out = append(out, kept)
+			}
+		}
+		if out == nil {
+			return nil
+		}
+		return out
+	}
+	return nil
+}
+
+// setPath stores value at path, creating intermediate objects
+func setPath(doc map[string]interface{}, path []string, value interface{}) {
+	for _, k := range path[:len(path)-1] {
+		child, ok := doc[k].(map[string]interface{})
+		if !ok {
+			child = map[string]interface{}{}
+			doc[k] = child
+		}
+		doc = child
+	}
+	doc[path[len(path)-1]] = value
+}
+
+// deletePath removes path, from every element of the lists it crosses
+func deletePath(v interface{}, path []string) {
+	switch node := v.(type) {
+	case map[string]interface{}:
+		if len(path) == 1 {
+			delete(node, path[0])
+			return
+		}
+		deletePath(node[path[0]], path[1:])
+	case []interface{}:
+		for _, item := range node {
+			deletePath(item, path)
+		}
+	}
+}
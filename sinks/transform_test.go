@@ -0,0 +1,152 @@
+package sinks
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newTestEventData() EventData {
+	return NewEventData(&v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "pod-1.abc",
+			Namespace: "default",
+			ManagedFields: []metav1.ManagedFieldsEntry{
+				{Manager: "kubelet", Operation: metav1.ManagedFieldsOperationUpdate},
+			},
+		},
+		InvolvedObject: v1.ObjectReference{Kind: "Pod", Name: "pod-1"},
+		Reason:         "BackOff",
+		Message:        "Back-off restarting failed container",
+		Type:           "Warning",
+	}, nil)
+}
+
+func TestNewTransform(t *testing.T) {
+	testCases := []struct {
+		name      string
+		cfg       TransformConfig
+		wantNil   bool
+		wantError string
+	}{
+		{"empty", TransformConfig{}, true, ""},
+		{"keep", TransformConfig{Keep: []string{"event.reason"}}, false, ""},
+		{"bad rename", TransformConfig{Rename: []string{"event.reason"}}, false, `transformRename: invalid rename "event.reason", expected from=to`},
+		{"bad add", TransformConfig{Add: []string{"=prod"}}, false, `transformAdd: invalid field "=prod", expected path=value`},
+		{"bad template", TransformConfig{Template: "{{.verb"}, false, "transformTemplate: template: transform:1: unclosed action"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := NewTransform(&tc.cfg)
+			if tc.wantError != "" {
+				require.EqualError(t, err, tc.wantError)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.wantNil, got == nil)
+		})
+	}
+}
+
+func TestTransform_Apply(t *testing.T) {
+	testCases := []struct {
+		name string
+		cfg  TransformConfig
+		want map[string]interface{}
+	}{
+		{
+			"keep",
+			TransformConfig{Keep: []string{"verb", "event.reason", "event.involvedObject.kind", "event.missing"}},
+			map[string]interface{}{
+				"verb": "ADDED",
+				"event": map[string]interface{}{
+					"reason":         "BackOff",
+					"involvedObject": map[string]interface{}{"kind": "Pod"},
+				},
+			},
+		},
+		{
+			"keep across a list",
+			TransformConfig{Keep: []string{"event.metadata.managedFields.manager"}},
+			map[string]interface{}{
+				"event": map[string]interface{}{
+					"metadata": map[string]interface{}{"managedFields": []interface{}{map[string]interface{}{"manager": "kubelet"}}},
+				},
+			},
+		},
+		{
+			"drop, rename and add",
+			TransformConfig{
+				Keep:   []string{"event.metadata", "event.reason"},
+				Drop:   []string{"event.metadata.managedFields", "event.metadata.creationTimestamp"},
+				Rename: []string{"event.reason=reason", "event.absent=x"},
+				Add:    []string{"cluster=prod", "labels.region=eu-west-1"},
+			},
+			map[string]interface{}{
+				"event": map[string]interface{}{
+					"metadata": map[string]interface{}{"name": "pod-1.abc", "namespace": "default"},
+				},
+				"reason":  "BackOff",
+				"cluster": "prod",
+				"labels":  map[string]interface{}{"region": "eu-west-1"},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tr, err := NewTransform(&tc.cfg)
+			require.NoError(t, err)
+			got, err := tr.Apply(newTestEventData())
+			require.NoError(t, err)
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestEventData_Marshal(t *testing.T) {
+	marshal := func(tr *Transform) string {
+		e := newTestEventData()
+		e.transform = tr
+		got, err := e.Marshal()
+		require.NoError(t, err)
+		return string(got)
+	}
+
+	require.Contains(t, marshal(nil), `"managedFields"`)
+
+	tr, err := NewTransform(&TransformConfig{Keep: []string{"event.reason"}, Add: []string{"cluster=prod"}})
+	require.NoError(t, err)
+	require.JSONEq(t, `{"cluster":"prod","event":{"reason":"BackOff"}}`, marshal(tr))
+
+	tr, err = NewTransform(&TransformConfig{
+		Add:      []string{"cluster=prod"},
+		Template: `[{{.cluster}}] {{.verb}} {{upper .event.type}} {{.event.involvedObject.kind}}/{{.event.involvedObject.name}}: {{.event.message}}`,
+	})
+	require.NoError(t, err)
+	require.True(t, tr.IsText())
+	require.Equal(t, "[prod] ADDED WARNING Pod/pod-1: Back-off restarting failed container", marshal(tr))
+
+	tr, err = NewTransform(&TransformConfig{Template: `{{.event.reason}} "quoted"`})
+	require.NoError(t, err)
+	e := newTestEventData()
+	e.transform = tr
+	got, err := e.marshalJSONValue()
+	require.NoError(t, err)
+	require.Equal(t, `"BackOff \"quoted\""`, string(got))
+}
+
+func TestEventData_transformFlattenedJSON(t *testing.T) {
+	tr, err := NewTransform(&TransformConfig{Keep: []string{"event.reason"}})
+	require.NoError(t, err)
+	eData := newTestEventData()
+	eData.transform = tr
+
+	// The flattened form is built from the transformed document
+	var buf bytes.Buffer
+	_, err = eData.WriteFlattenedJSON(&buf)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"event_reason":"BackOff"}`, buf.String())
+}